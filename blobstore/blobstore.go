@@ -0,0 +1,67 @@
+// Package blobstore stores opaque blobs, such as receipt attachments, under
+// string keys. Keys are slash-separated paths chosen by the caller; stores
+// don't interpret them beyond rejecting ones that would escape their root.
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/alexgaudon/budgie/config"
+)
+
+// ErrNotFound is returned by Get when nothing is stored under the key.
+var ErrNotFound = errors.New("blob not found")
+
+// Store is where blobs live. Implementations must be safe for concurrent use.
+type Store interface {
+	// Put stores data under key, replacing anything already there.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get opens the blob stored under key. The caller closes it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob under key. Deleting a missing blob is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the store selected by the configuration: the local filesystem
+// unless BlobStore is "s3".
+func New(c *config.Config) (Store, error) {
+	switch c.BlobStore {
+	case "", "local":
+		return &Local{Root: c.BlobPath}, nil
+	case "s3":
+		if c.S3Endpoint == "" || c.S3Bucket == "" {
+			return nil, fmt.Errorf("the s3 blob store needs S3_ENDPOINT and S3_BUCKET")
+		}
+
+		return &S3{
+			Endpoint:  c.S3Endpoint,
+			Region:    c.S3Region,
+			Bucket:    c.S3Bucket,
+			AccessKey: c.S3AccessKey,
+			SecretKey: c.S3SecretKey,
+			PathStyle: c.S3PathStyle,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown blob store %q", c.BlobStore)
+}
+
+// checkKey rejects empty keys and keys with empty, "." or ".." segments.
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.Contains(segment, "\\") {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,225 @@
+// Package classifier suggests categories for transactions from how a user has
+// categorized past ones, using a multinomial naive Bayes model over the words
+// of the vendor and description and a bucket for the amount. It holds no
+// state beyond the model it is given examples for.
+package classifier
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// minSuggestConfidence is the lowest confidence Best accepts.
+const minSuggestConfidence = 0.5
+
+// Example is one categorized transaction line to learn from.
+type Example struct {
+	CategoryID  string
+	Vendor      string
+	Description string
+	Amount      int
+}
+
+// Suggestion is a ranked category with the model's confidence in it, between
+// 0 and 1.
+type Suggestion struct {
+	CategoryID string  `json:"category_id"`
+	Category   string  `json:"category"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Model is a trained classifier. The zero value suggests nothing.
+type Model struct {
+	examples   int
+	categories map[string]*categoryCounts
+	vocabulary map[string]bool
+}
+
+type categoryCounts struct {
+	examples int
+	tokens   int
+	counts   map[string]int
+}
+
+// Train builds a model from the examples. Examples without a category are
+// ignored.
+func Train(examples []Example) *Model {
+	m := &Model{
+		categories: map[string]*categoryCounts{},
+		vocabulary: map[string]bool{},
+	}
+
+	for _, example := range examples {
+		if example.CategoryID == "" {
+			continue
+		}
+
+		c, ok := m.categories[example.CategoryID]
+
+		if !ok {
+			c = &categoryCounts{counts: map[string]int{}}
+			m.categories[example.CategoryID] = c
+		}
+
+		m.examples++
+		c.examples++
+
+		for _, token := range Tokens(example.Vendor, example.Description, example.Amount) {
+			c.counts[token]++
+			c.tokens++
+			m.vocabulary[token] = true
+		}
+	}
+
+	return m
+}
+
+// Suggest ranks every known category for a transaction, most likely first.
+// Category names are left for the caller to fill in.
+func (m *Model) Suggest(vendor, description string, amount int) []*Suggestion {
+	suggestions := []*Suggestion{}
+
+	if m == nil || m.examples == 0 {
+		return suggestions
+	}
+
+	// Words the model has never seen say nothing about any category.
+	tokens := []string{}
+	for _, token := range Tokens(vendor, description, amount) {
+		if m.vocabulary[token] {
+			tokens = append(tokens, token)
+		}
+	}
+
+	vocabulary := float64(len(m.vocabulary))
+	scores := map[string]float64{}
+	best := math.Inf(-1)
+
+	for id, c := range m.categories {
+		score := math.Log(float64(c.examples) / float64(m.examples))
+
+		for _, token := range tokens {
+			// Laplace smoothing keeps unseen words from ruling a category out.
+			score += math.Log((float64(c.counts[token]) + 1) / (float64(c.tokens) + vocabulary))
+		}
+
+		scores[id] = score
+
+		if score > best {
+			best = score
+		}
+	}
+
+	// Normalize the log scores into probabilities, shifted by the best score
+	// so the exponentials don't underflow.
+	total := 0.0
+	for _, score := range scores {
+		total += math.Exp(score - best)
+	}
+
+	for id, score := range scores {
+		suggestions = append(suggestions, &Suggestion{
+			CategoryID: id,
+			Confidence: math.Exp(score-best) / total,
+		})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Confidence != suggestions[j].Confidence {
+			return suggestions[i].Confidence > suggestions[j].Confidence
+		}
+		return suggestions[i].CategoryID < suggestions[j].CategoryID
+	})
+
+	return suggestions
+}
+
+// Best returns the most likely category when the model is confident enough
+// to pre-fill it, and nil otherwise. The model has to know at least one word
+// of the vendor or description; the amount and how common each category is
+// aren't enough on their own.
+func (m *Model) Best(vendor, description string, amount int) *Suggestion {
+	known := false
+
+	for _, token := range Tokens(vendor, description, 0) {
+		if m != nil && m.vocabulary[token] && !strings.HasPrefix(token, "amount:") {
+			known = true
+			break
+		}
+	}
+
+	if !known {
+		return nil
+	}
+
+	suggestions := m.Suggest(vendor, description, amount)
+
+	if len(suggestions) == 0 || suggestions[0].Confidence < minSuggestConfidence {
+		return nil
+	}
+
+	return suggestions[0]
+}
+
+// Tokens returns the features of a transaction: the lowercased words of the
+// vendor and description (numbers and single letters dropped, since they're
+// mostly store numbers and references), the whole vendor, and the order of
+// magnitude of the amount.
+func Tokens(vendor, description string, amount int) []string {
+	tokens := []string{}
+
+	words := func(s string) []string {
+		found := []string{}
+
+		for _, word := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			if len([]rune(word)) < 2 || isNumber(word) {
+				continue
+			}
+			found = append(found, word)
+		}
+
+		return found
+	}
+
+	vendorWords := words(vendor)
+
+	if len(vendorWords) > 0 {
+		tokens = append(tokens, "vendor:"+strings.Join(vendorWords, " "))
+	}
+
+	tokens = append(tokens, vendorWords...)
+	tokens = append(tokens, words(description)...)
+	tokens = append(tokens, "amount:"+strconv.Itoa(amountBucket(amount)))
+
+	return tokens
+}
+
+// amountBucket groups amounts (in hundredths) by power of two of whole
+// units, so 12.00 and 15.00 land together but 12.00 and 120.00 don't.
+func amountBucket(amount int) int {
+	if amount < 0 {
+		amount = -amount
+	}
+
+	units := amount / 100
+
+	if units < 1 {
+		return 0
+	}
+
+	return int(math.Log2(float64(units))) + 1
+}
+
+func isNumber(word string) bool {
+	for _, r := range word {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
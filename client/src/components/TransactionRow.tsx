@@ -1,6 +1,6 @@
 import React, { useState } from "react";
 
-import { Transaction } from "../types";
+import { Transaction, formatMoney } from "../types";
 
 import { useCategoriesQuery } from "../hooks/useCategories";
 
@@ -163,8 +163,12 @@ export const TransactionRow = ({
                         onBlur={onBlur}
                     />
                 ) : (
-                    (editedRow.type === "income" && "$" + editedRow.amount) ||
-                    "-$" + editedRow.amount
+                    formatMoney(
+                        editedRow.type === "expense"
+                            ? "-" + editedRow.amount
+                            : editedRow.amount,
+                        editedRow.currency
+                    )
                 )}
             </td>
             <td className="px-4 py-2 text-left">
@@ -71,8 +71,15 @@ export const TransactionTable = ({ isAdding }: TransactionTableProps) => {
     ) => {
         if (eventType === "edit" || eventType === "delete") {
             if (eventType === "edit") {
-                console.log(subject);
-                updateTransaction.mutateAsync(subject);
+                const original = transactions?.find(
+                    (t) => t.id === subject.id
+                );
+                if (original) {
+                    updateTransaction.mutateAsync({
+                        original,
+                        edited: subject,
+                    });
+                }
             } else {
                 deleteTransaction.mutateAsync(subject.id);
             }
@@ -2,7 +2,7 @@ import { useQuery, useMutation, useQueryClient } from "react-query";
 
 import { z } from "zod";
 
-import { type Budget, budgetSchema } from "../types";
+import { type Budget, budgetSchema, moneySchema, toMoney } from "../types";
 import { CreateBudgetForm } from "../components/AddBudget";
 
 const budgetUtilSchema = z.object({
@@ -11,37 +11,15 @@ const budgetUtilSchema = z.object({
     updated_at: z.string(),
     user: z.string(),
     category: z.string(),
-    amount: z.number().transform((num) => {
-        // Check if the input number is valid
-        if (isNaN(num)) {
-            throw new Error("Invalid amount value");
-        }
-
-        num /= 100;
-
-        // Convert the number to currency format
-        return num.toLocaleString("en-US", {
-            style: "currency",
-            currency: "USD",
-        });
-    }),
+    amount: moneySchema,
+    currency: z.string(),
     period: z.string().transform((input) => {
         return new Date(input).toISOString().substring(0, 7);
     }),
-    utilization: z.number().transform((num) => {
-        // Check if the input number is valid
-        if (isNaN(num)) {
-            throw new Error("Invalid amount value");
-        }
-
-        num /= 100;
-
-        // Convert the number to currency format
-        return num.toLocaleString("en-US", {
-            style: "currency",
-            currency: "USD",
-        });
-    }),
+    utilization: moneySchema,
+    // Set when some spending couldn't be converted into the budget's
+    // currency and is left out of utilization.
+    missing_rate: z.string().optional(),
 });
 
 const fetchBudgetsByPeriod = async (period: string) => {
@@ -81,11 +59,7 @@ export const useCreateBudgetMutation = () => {
             body: JSON.stringify({
                 category: newBudget.category,
                 period: newBudget.period,
-                amount: Number(
-                    Math.round(
-                        parseFloat(newBudget.amount.toString()) * 100
-                    ).toString()
-                ),
+                amount: toMoney(newBudget.amount),
             }),
             headers: {
                 "Content-Type": "application/json",
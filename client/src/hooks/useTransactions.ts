@@ -2,7 +2,7 @@ import { useQuery, useMutation, useQueryClient } from "react-query";
 
 import { z } from "zod";
 
-import { type Transaction, transactionSchema } from "../types";
+import { type Transaction, transactionSchema, toMoney } from "../types";
 import { CreateTransactionForm } from "../components/AddTransaction";
 
 const fetchTransactions = async (category: string|undefined, period: string|undefined) => {
@@ -37,11 +37,7 @@ export const useCreateTransactionMutation = () => {
                 vendor: transaction.vendor,
                 description: transaction.description,
                 category_id: transaction.category,
-                amount: Number(
-                    Math.round(
-                        parseFloat(transaction.amount.toString()) * 100
-                    ).toString()
-                ),
+                amount: toMoney(transaction.amount),
                 date: new Date(transaction.date),
             }),
             headers: {
@@ -57,26 +53,56 @@ export const useCreateTransactionMutation = () => {
     });
 };
 
+// TransactionEdit is a transaction as loaded and as edited in the table.
+export type TransactionEdit = {
+    original: Transaction;
+    edited: Transaction;
+};
+
+// changedFields is a merge patch of the fields the table edits that differ
+// from the loaded transaction. The account, splits and everything else the
+// table doesn't show are left out, so the server keeps them as they are.
+const changedFields = ({ original, edited }: TransactionEdit) => {
+    const patch: Record<string, unknown> = {};
+    const day = (date: Date) => new Date(date).toISOString().substring(0, 10);
+
+    if (edited.type !== original.type) {
+        patch.type = edited.type;
+    }
+    if (edited.vendor !== original.vendor) {
+        patch.vendor = edited.vendor;
+    }
+    if (edited.description !== original.description) {
+        patch.description = edited.description;
+    }
+    if (edited.category_id !== original.category_id) {
+        patch.category_id = edited.category_id;
+    }
+    if (toMoney(edited.amount) !== original.amount) {
+        patch.amount = toMoney(edited.amount);
+    }
+    if (day(edited.date) !== day(original.date)) {
+        patch.date = new Date(edited.date);
+    }
+
+    return patch;
+};
+
 export const useUpdateTransactionMutation = () => {
     const queryClient = useQueryClient();
 
-    return useMutation(async (transaction: Transaction) => {
-        const res = await fetch(`/api/transactions/${transaction.id}`, {
-            method: "PUT",
-            body: JSON.stringify({
-                type: transaction.type,
-                vendor: transaction.vendor,
-                description: transaction.description,
-                category_id: transaction.category_id,
-                amount: Number(
-                    Math.round(
-                        parseFloat(transaction.amount.toString()) * 100
-                    ).toString()
-                ),
-                date: new Date(transaction.date),
-            }),
+    return useMutation(async (edit: TransactionEdit) => {
+        const patch = changedFields(edit);
+
+        if (Object.keys(patch).length === 0) {
+            return;
+        }
+
+        const res = await fetch(`/api/transactions/${edit.original.id}`, {
+            method: "PATCH",
+            body: JSON.stringify(patch),
             headers: {
-                "Content-Type": "application/json",
+                "Content-Type": "application/merge-patch+json",
             },
         });
 
@@ -5,6 +5,7 @@ import { Link, Navigate } from "react-router-dom";
 import { useAuth } from "../hooks/useAuth";
 import { useBudgetsUtilizationQuery } from "../hooks/useBudgets";
 import { AddBudget } from "../components/AddBudget";
+import { formatMoney } from "../types";
 
 type BudgetProps = {
     id: string;
@@ -12,6 +13,8 @@ type BudgetProps = {
     period: string;
     amount: string;
     utilization: string;
+    currency: string;
+    missingRate?: string;
 };
 
 export const Budget = ({
@@ -20,18 +23,21 @@ export const Budget = ({
     period,
     amount,
     utilization,
+    currency,
+    missingRate,
 }: BudgetProps) => {
     const getAmtAsNums = () => {
-        let amtNum = parseFloat(amount.replace("$", "").replace(",", ""));
-        let utilNum = parseFloat(utilization.replace("$", "").replace(",", ""));
+        let amtNum = parseFloat(amount);
+        let utilNum = parseFloat(utilization);
 
         return { amtNum, utilNum };
     };
     const amountRemaining = () => {
         let { amtNum, utilNum } = getAmtAsNums();
-        return `$${(amtNum - utilNum).toLocaleString("en-US", {
-            currency: "USD",
-        })}`;
+        return (amtNum - utilNum).toLocaleString("en-US", {
+            style: "currency",
+            currency,
+        });
     };
 
     const utilPercent = () => {
@@ -72,8 +78,15 @@ export const Budget = ({
                 </div>
 
                 <p>
-                    {utilization} of {amount}
+                    {formatMoney(utilization, currency)} of{" "}
+                    {formatMoney(amount, currency)}
                 </p>
+
+                {missingRate && (
+                    <p className="text-sm text-red-400">
+                        Some spending is left out: {missingRate}
+                    </p>
+                )}
             </div>
         </div>
     );
@@ -185,6 +198,8 @@ export const Budgets = () => {
                             amount={budget.amount}
                             period={budget.period}
                             utilization={budget.utilization}
+                            currency={budget.currency}
+                            missingRate={budget.missing_rate}
                         ></Budget>
                     );
                 })}
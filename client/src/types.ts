@@ -1,5 +1,25 @@
 import { TypeOf, z } from "zod";
 
+// Amounts are sent and received as decimal strings in their currency, e.g.
+// "12.34", "-5.00" or "1250" for JPY.
+const moneySchema = z.string().regex(/^-?\d+(\.\d+)?$/, {
+    message: "Amount must be a decimal such as 12.34",
+});
+
+// toMoney turns what was typed into an amount field into the decimal string
+// the API expects, dropping dollar signs, spaces and thousands separators.
+const toMoney = (input: string) => {
+    return input.replace(/[$\s]/g, "").replace(/,(?=\d{3}(\D|$))/g, "");
+};
+
+// formatMoney shows an amount with its currency's symbol and decimals.
+const formatMoney = (amount: string, currency: string) => {
+    return parseFloat(amount).toLocaleString("en-US", {
+        style: "currency",
+        currency,
+    });
+};
+
 const transactionSchema = z.object({
     id: z.string(),
     user: z.string(),
@@ -7,23 +27,9 @@ const transactionSchema = z.object({
     description: z.string().nullable(),
     category_id: z.string(),
     category: z.string(),
-    amount: z.number().transform((num) => {
-        // Check if the input number is valid
-        if (isNaN(num)) {
-            throw new Error("Invalid amount value");
-        }
-
-        num /= 100;
-        return num.toFixed(2);
-    }),
-    type: z.string().refine(
-        (str) => {
-            return str == "income" || str == "expense";
-        },
-        {
-            message: 'Type must be equal to "income" or "expense"',
-        }
-    ),
+    amount: moneySchema,
+    currency: z.string(),
+    type: z.enum(["expense", "income", "refund", "transfer", "adjustment"]),
     date: z.string().transform((str) => new Date(str)),
     updated_at: z.string(),
     created_at: z.string(),
@@ -35,7 +41,7 @@ const newTransactionSchema = z.object({
     vendor: z.string(),
     description: z.string(),
     category: z.string(),
-    amount: z.number(),
+    amount: moneySchema,
     type: z.string().refine(
         (str) => {
             return str == "income" || str == "expense";
@@ -63,20 +69,8 @@ const budgetSchema = z.object({
     updated_at: z.string(),
     user: z.string(),
     category: z.string(),
-    amount: z.number().transform((num) => {
-        // Check if the input number is valid
-        if (isNaN(num)) {
-            throw new Error("Invalid amount value");
-        }
-
-        num /= 100;
-
-        // Convert the number to currency format
-        return num.toLocaleString("en-US", {
-            style: "currency",
-            currency: "USD",
-        });
-    }),
+    amount: moneySchema,
+    currency: z.string(),
     period: z.string().transform((input) => {
         return new Date(input).toISOString().substring(0, 7);
     }),
@@ -85,6 +79,9 @@ const budgetSchema = z.object({
 type Budget = z.infer<typeof budgetSchema>;
 
 export {
+    moneySchema,
+    toMoney,
+    formatMoney,
     type Transaction,
     transactionSchema,
     type Budget,
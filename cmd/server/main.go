@@ -5,7 +5,9 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/alexgaudon/budgie/blobstore"
 	"github.com/alexgaudon/budgie/config"
+	"github.com/alexgaudon/budgie/scheduler"
 	"github.com/alexgaudon/budgie/server"
 	"github.com/alexgaudon/budgie/storage"
 )
@@ -29,7 +31,15 @@ func main() {
 		fmt.Println("Err", err.Error())
 	}
 
-	server := server.NewAPIServer(db)
+	scheduler.NewScheduler(db, config.GetConfig().RecurringInterval).Start()
+
+	blobs, err := blobstore.New(config.GetConfig())
+
+	if err != nil {
+		panic(err)
+	}
+
+	server := server.NewAPIServer(db, blobs)
 	server.ConfigureServer()
 
 	port := config.GetConfig().ServerPort
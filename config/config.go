@@ -21,6 +21,19 @@ type Config struct {
 	RefreshTokenExpiresIn time.Duration
 	AccessTokenMaxAge     int
 	RefreshTokenMaxAge    int
+
+	RecurringInterval time.Duration
+
+	// BlobStore selects where attachments are kept: "local" (the default)
+	// for files under BlobPath, or "s3" for an S3-compatible bucket.
+	BlobStore   string
+	BlobPath    string
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3PathStyle bool
 }
 
 var cfg Config
@@ -56,9 +69,24 @@ func LoadConfig() {
 	c.AccessTokenMaxAge = int(time.Minute * 15)
 	c.RefreshTokenMaxAge = int(time.Hour * 24 * 7)
 
+	c.RecurringInterval = time.Hour
+
+	c.BlobStore = os.Getenv("BLOB_STORE")
+	c.BlobPath = os.Getenv("BLOB_PATH")
+	c.S3Endpoint = os.Getenv("S3_ENDPOINT")
+	c.S3Region = os.Getenv("S3_REGION")
+	c.S3Bucket = os.Getenv("S3_BUCKET")
+	c.S3AccessKey = os.Getenv("S3_ACCESS_KEY")
+	c.S3SecretKey = os.Getenv("S3_SECRET_KEY")
+	c.S3PathStyle = os.Getenv("S3_PATH_STYLE") == "true"
+
 	if c.ServerPort == "" {
 		c.ServerPort = "3000"
 	}
 
+	if c.BlobPath == "" {
+		c.BlobPath = "./data/attachments"
+	}
+
 	cfg = *c
 }
@@ -0,0 +1,94 @@
+// Package filter parses the transaction query language used by the listing
+// endpoint and the search box, e.g.
+//
+//	vendor:amazon category:groceries amount>50 date:2023-05..2023-07 -tag:reimbursed type:expense
+//
+// Terms separated by spaces must all hold; OR between terms, parentheses and
+// a leading "-" or NOT combine them differently. A term is either field:value
+// (or a comparison such as amount>=50) or a bare word matched against the
+// vendor, description and category. Values with spaces are quoted:
+// vendor:"corner store".
+//
+// Parsing produces an AST; compiling it to SQL is left to the models package.
+package filter
+
+import (
+	"fmt"
+	"time"
+)
+
+// Node is a node of a parsed query.
+type Node interface {
+	// Pos is the byte offset in the query where the node starts.
+	Pos() int
+}
+
+// And holds when all of its nodes hold.
+type And struct {
+	Nodes []Node
+	At    int
+}
+
+// Or holds when any of its nodes holds.
+type Or struct {
+	Nodes []Node
+	At    int
+}
+
+// Not holds when its node doesn't.
+type Not struct {
+	Node Node
+	At   int
+}
+
+// Text is a bare word or quoted phrase, matched against the vendor,
+// description and category as a prefix of their words.
+type Text struct {
+	Value string
+	At    int
+}
+
+// Match compares a text field: vendor, description, category, payee, account,
+// tag, type, status or currency. With Exact the whole value must match,
+// ignoring case; otherwise it's enough for the field to contain it. Tags,
+// types, statuses and currencies always match exactly.
+type Match struct {
+	Field string
+	Value string
+	Exact bool
+	At    int
+}
+
+// AmountRange limits the amount, in hundredths of a unit whatever the
+// currency, to an inclusive range. A nil bound is open.
+type AmountRange struct {
+	Min *int
+	Max *int
+	At  int
+}
+
+// DateRange limits the date to the half-open range [From, To). A nil bound is
+// open.
+type DateRange struct {
+	From *time.Time
+	To   *time.Time
+	At   int
+}
+
+func (n *And) Pos() int         { return n.At }
+func (n *Or) Pos() int          { return n.At }
+func (n *Not) Pos() int         { return n.At }
+func (n *Text) Pos() int        { return n.At }
+func (n *Match) Pos() int       { return n.At }
+func (n *AmountRange) Pos() int { return n.At }
+func (n *DateRange) Pos() int   { return n.At }
+
+// Error is a syntax error at a byte offset of the query.
+type Error struct {
+	Pos     int    `json:"position"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Pos+1)
+}
@@ -0,0 +1,623 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fields maps the field names, and their short forms, accepted in queries to
+// their canonical names.
+var fields = map[string]string{
+	"vendor":      "vendor",
+	"description": "description",
+	"desc":        "description",
+	"category":    "category",
+	"cat":         "category",
+	"payee":       "payee",
+	"account":     "account",
+	"tag":         "tag",
+	"type":        "type",
+	"status":      "status",
+	"currency":    "currency",
+	"amount":      "amount",
+	"date":        "date",
+}
+
+// types are the values type: accepts.
+var types = map[string]bool{
+	"expense":    true,
+	"income":     true,
+	"refund":     true,
+	"transfer":   true,
+	"adjustment": true,
+}
+
+// statuses are the values status: accepts.
+var statuses = map[string]bool{
+	"pending":    true,
+	"cleared":    true,
+	"reconciled": true,
+}
+
+// maxAmount caps amounts, in hundredths of a unit, well within an int.
+const maxAmount = 1<<31 - 1
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenLParen
+	tokenRParen
+	tokenNot
+	tokenAnd
+	tokenOr
+	tokenText
+	tokenTerm
+)
+
+type token struct {
+	kind     tokenKind
+	pos      int
+	field    string
+	op       string
+	opPos    int
+	value    string
+	valuePos int
+}
+
+// Parse parses a query. An empty query parses to nil, which matches
+// everything.
+func Parse(query string) (Node, error) {
+	tokens, err := lex(query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	if p.peek().kind == tokenEOF {
+		return nil, nil
+	}
+
+	node, err := p.parseOr()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &Error{Pos: t.pos, Message: "unexpected )"}
+	}
+
+	return node, nil
+}
+
+// lex splits the query into tokens.
+func lex(q string) ([]token, error) {
+	tokens := []token{}
+	i := 0
+
+	for i < len(q) {
+		c := q[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, pos: i})
+			i++
+		case c == '-' && i+1 < len(q) && !isDelimiter(q[i+1]):
+			tokens = append(tokens, token{kind: tokenNot, pos: i})
+			i++
+		case c == '"':
+			value, end, err := readQuoted(q, i)
+
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, token{kind: tokenText, pos: i, value: value, valuePos: i})
+			i = end
+		default:
+			t, end, err := readTerm(q, i)
+
+			if err != nil {
+				return nil, err
+			}
+
+			tokens = append(tokens, t)
+			i = end
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(q)}), nil
+}
+
+// readTerm reads a field comparison, a keyword or a bare word starting at i.
+func readTerm(q string, i int) (token, int, error) {
+	j := i
+
+	for j < len(q) && isFieldChar(q[j]) {
+		j++
+	}
+
+	if j > i && j < len(q) && strings.IndexByte(":=<>", q[j]) >= 0 {
+		t := token{kind: tokenTerm, pos: i, field: strings.ToLower(q[i:j]), opPos: j}
+
+		k := j + 1
+
+		if (q[j] == '<' || q[j] == '>') && k < len(q) && q[k] == '=' {
+			k++
+		}
+
+		t.op = q[j:k]
+		t.valuePos = k
+
+		if k < len(q) && q[k] == '"' {
+			value, end, err := readQuoted(q, k)
+
+			if err != nil {
+				return t, 0, err
+			}
+
+			t.value = value
+			return t, end, nil
+		}
+
+		end := k
+
+		for end < len(q) && !isDelimiter(q[end]) {
+			end++
+		}
+
+		if end == k {
+			return t, 0, &Error{Pos: k, Message: fmt.Sprintf("missing value for %s", q[i:j])}
+		}
+
+		t.value = q[k:end]
+		return t, end, nil
+	}
+
+	end := i
+
+	for end < len(q) && !isDelimiter(q[end]) && q[end] != '(' {
+		end++
+	}
+
+	word := q[i:end]
+
+	switch word {
+	case "OR":
+		return token{kind: tokenOr, pos: i}, end, nil
+	case "AND":
+		return token{kind: tokenAnd, pos: i}, end, nil
+	case "NOT":
+		return token{kind: tokenNot, pos: i}, end, nil
+	}
+
+	return token{kind: tokenText, pos: i, value: word, valuePos: i}, end, nil
+}
+
+// readQuoted reads a double-quoted string starting at i, in which \" and \\
+// stand for a quote and a backslash. It returns the string and the offset
+// just past the closing quote.
+func readQuoted(q string, i int) (string, int, error) {
+	var b strings.Builder
+
+	for j := i + 1; j < len(q); j++ {
+		switch q[j] {
+		case '\\':
+			if j+1 < len(q) && (q[j+1] == '"' || q[j+1] == '\\') {
+				j++
+			}
+			b.WriteByte(q[j])
+		case '"':
+			return b.String(), j + 1, nil
+		default:
+			b.WriteByte(q[j])
+		}
+	}
+
+	return "", 0, &Error{Pos: i, Message: "unterminated quote"}
+}
+
+func isDelimiter(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ')'
+}
+
+func isFieldChar(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c == '_'
+}
+
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+
+	if t.kind != tokenEOF {
+		p.next++
+	}
+
+	return t
+}
+
+// parseOr parses terms joined by OR, which binds more loosely than AND.
+func (p *parser) parseOr() (Node, error) {
+	first, err := p.parseAnd()
+
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []Node{first}
+
+	for p.peek().kind == tokenOr {
+		p.advance()
+
+		node, err := p.parseAnd()
+
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 1 {
+		return first, nil
+	}
+
+	return &Or{Nodes: nodes, At: first.Pos()}, nil
+}
+
+// parseAnd parses a run of terms, optionally joined by AND.
+func (p *parser) parseAnd() (Node, error) {
+	nodes := []Node{}
+
+	for {
+		t := p.peek()
+
+		if t.kind == tokenEOF || t.kind == tokenRParen || t.kind == tokenOr {
+			break
+		}
+
+		if t.kind == tokenAnd {
+			if len(nodes) == 0 {
+				return nil, &Error{Pos: t.pos, Message: "expected a term before AND"}
+			}
+
+			p.advance()
+
+			if next := p.peek(); next.kind == tokenEOF || next.kind == tokenRParen || next.kind == tokenOr {
+				return nil, &Error{Pos: next.pos, Message: "expected a term after AND"}
+			}
+
+			continue
+		}
+
+		node, err := p.parseUnary()
+
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	if len(nodes) == 0 {
+		t := p.peek()
+
+		if t.kind == tokenRParen {
+			return nil, &Error{Pos: t.pos, Message: "unexpected )"}
+		}
+
+		return nil, &Error{Pos: t.pos, Message: "expected a term"}
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+
+	return &And{Nodes: nodes, At: nodes[0].Pos()}, nil
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	t := p.peek()
+
+	if t.kind != tokenNot {
+		return p.parsePrimary()
+	}
+
+	p.advance()
+
+	if next := p.peek(); next.kind == tokenEOF || next.kind == tokenRParen || next.kind == tokenOr || next.kind == tokenAnd {
+		return nil, &Error{Pos: next.pos, Message: "expected a term to negate"}
+	}
+
+	node, err := p.parseUnary()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Not{Node: node, At: t.pos}, nil
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	t := p.advance()
+
+	switch t.kind {
+	case tokenLParen:
+		node, err := p.parseOr()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek().kind != tokenRParen {
+			return nil, &Error{Pos: t.pos, Message: "missing )"}
+		}
+
+		p.advance()
+
+		return node, nil
+	case tokenText:
+		return &Text{Value: t.value, At: t.pos}, nil
+	case tokenTerm:
+		return parseTerm(t)
+	}
+
+	return nil, &Error{Pos: t.pos, Message: "expected a term"}
+}
+
+// parseTerm checks a field comparison and turns it into a node.
+func parseTerm(t token) (Node, error) {
+	field, ok := fields[t.field]
+
+	if !ok {
+		return nil, &Error{Pos: t.pos, Message: fmt.Sprintf("unknown field %q", t.field)}
+	}
+
+	switch field {
+	case "amount":
+		return parseAmountTerm(t)
+	case "date":
+		return parseDateTerm(t)
+	}
+
+	if t.op != ":" && t.op != "=" {
+		return nil, &Error{Pos: t.opPos, Message: fmt.Sprintf("%s can't be compared with %s", field, t.op)}
+	}
+
+	match := &Match{
+		Field: field,
+		Value: t.value,
+		Exact: t.op == "=" || field == "tag" || field == "type" || field == "status" || field == "currency",
+		At:    t.pos,
+	}
+
+	if field == "type" {
+		match.Value = strings.ToLower(match.Value)
+
+		if !types[match.Value] {
+			return nil, &Error{Pos: t.valuePos, Message: fmt.Sprintf("unknown type %q", t.value)}
+		}
+	}
+
+	if field == "status" {
+		match.Value = strings.ToLower(match.Value)
+
+		if !statuses[match.Value] {
+			return nil, &Error{Pos: t.valuePos, Message: fmt.Sprintf("unknown status %q", t.value)}
+		}
+	}
+
+	return match, nil
+}
+
+func parseAmountTerm(t token) (Node, error) {
+	node := &AmountRange{At: t.pos}
+
+	if low, high, ok := strings.Cut(t.value, ".."); ok {
+		if t.op != ":" && t.op != "=" {
+			return nil, &Error{Pos: t.opPos, Message: "ranges need : or ="}
+		}
+
+		if low == "" && high == "" {
+			return nil, &Error{Pos: t.valuePos, Message: "range needs at least one bound"}
+		}
+
+		if low != "" {
+			min, err := parseAmount(low, t.valuePos)
+
+			if err != nil {
+				return nil, err
+			}
+
+			node.Min = &min
+		}
+
+		if high != "" {
+			max, err := parseAmount(high, t.valuePos+len(low)+2)
+
+			if err != nil {
+				return nil, err
+			}
+
+			node.Max = &max
+		}
+
+		if node.Min != nil && node.Max != nil && *node.Min > *node.Max {
+			return nil, &Error{Pos: t.valuePos, Message: "amount range is empty"}
+		}
+
+		return node, nil
+	}
+
+	amount, err := parseAmount(t.value, t.valuePos)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch t.op {
+	case ":", "=":
+		node.Min, node.Max = &amount, &amount
+	case ">":
+		amount++
+		node.Min = &amount
+	case ">=":
+		node.Min = &amount
+	case "<":
+		amount--
+		node.Max = &amount
+	case "<=":
+		node.Max = &amount
+	}
+
+	return node, nil
+}
+
+// parseAmount reads an amount in major units, with up to two decimals, as
+// hundredths.
+func parseAmount(s string, pos int) (int, error) {
+	invalid := &Error{Pos: pos, Message: fmt.Sprintf("invalid amount %q", s)}
+
+	whole, fraction, _ := strings.Cut(s, ".")
+
+	if whole == "" || len(fraction) > 2 || !isDigits(whole) || !isDigits(fraction) {
+		return 0, invalid
+	}
+
+	units, err := strconv.Atoi(whole)
+
+	if err != nil {
+		return 0, invalid
+	}
+
+	if units > maxAmount/100 {
+		return 0, &Error{Pos: pos, Message: fmt.Sprintf("amount %q is too large", s)}
+	}
+
+	cents := 0
+
+	if fraction != "" {
+		cents, _ = strconv.Atoi(fraction)
+
+		if len(fraction) == 1 {
+			cents *= 10
+		}
+	}
+
+	return units*100 + cents, nil
+}
+
+func parseDateTerm(t token) (Node, error) {
+	node := &DateRange{At: t.pos}
+
+	if low, high, ok := strings.Cut(t.value, ".."); ok {
+		if t.op != ":" && t.op != "=" {
+			return nil, &Error{Pos: t.opPos, Message: "ranges need : or ="}
+		}
+
+		if low == "" && high == "" {
+			return nil, &Error{Pos: t.valuePos, Message: "range needs at least one bound"}
+		}
+
+		if low != "" {
+			from, _, err := parseDate(low, t.valuePos)
+
+			if err != nil {
+				return nil, err
+			}
+
+			node.From = &from
+		}
+
+		if high != "" {
+			_, to, err := parseDate(high, t.valuePos+len(low)+2)
+
+			if err != nil {
+				return nil, err
+			}
+
+			node.To = &to
+		}
+
+		if node.From != nil && node.To != nil && !node.From.Before(*node.To) {
+			return nil, &Error{Pos: t.valuePos, Message: "date range is empty"}
+		}
+
+		return node, nil
+	}
+
+	start, end, err := parseDate(t.value, t.valuePos)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch t.op {
+	case ":", "=":
+		node.From, node.To = &start, &end
+	case ">":
+		node.From = &end
+	case ">=":
+		node.From = &start
+	case "<":
+		node.To = &start
+	case "<=":
+		node.To = &end
+	}
+
+	return node, nil
+}
+
+// parseDate reads a year (2023), month (2023-05) or day (2023-05-14) and
+// returns the period it covers as [start, end).
+func parseDate(s string, pos int) (time.Time, time.Time, error) {
+	for _, layout := range []struct {
+		format string
+		years  int
+		months int
+		days   int
+	}{
+		{"2006-01-02", 0, 0, 1},
+		{"2006-01", 0, 1, 0},
+		{"2006", 1, 0, 0},
+	} {
+		if len(s) != len(layout.format) {
+			continue
+		}
+
+		start, err := time.Parse(layout.format, s)
+
+		if err == nil {
+			return start, start.AddDate(layout.years, layout.months, layout.days), nil
+		}
+	}
+
+	return time.Time{}, time.Time{}, &Error{Pos: pos, Message: fmt.Sprintf("invalid date %q, expected YYYY, YYYY-MM or YYYY-MM-DD", s)}
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package filter
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func intp(n int) *int {
+	return &n
+}
+
+func datep(year int, month time.Month, day int) *time.Time {
+	d := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	return &d
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  Node
+	}{
+		{"", nil},
+		{
+			"vendor:amazon category:groceries amount>50 date:2023-05..2023-07 -tag:reimbursed type:expense",
+			&And{
+				Nodes: []Node{
+					&Match{Field: "vendor", Value: "amazon", At: 0},
+					&Match{Field: "category", Value: "groceries", At: 14},
+					&AmountRange{Min: intp(5001), At: 33},
+					&DateRange{From: datep(2023, time.May, 1), To: datep(2023, time.August, 1), At: 43},
+					&Not{Node: &Match{Field: "tag", Value: "reimbursed", Exact: true, At: 66}, At: 65},
+					&Match{Field: "type", Value: "expense", Exact: true, At: 81},
+				},
+				At: 0,
+			},
+		},
+		{"coffee", &Text{Value: "coffee", At: 0}},
+		{`vendor:"corner store"`, &Match{Field: "vendor", Value: "corner store", At: 0}},
+		{"desc=Rent", &Match{Field: "description", Value: "Rent", Exact: true, At: 0}},
+		{"type:Refund", &Match{Field: "type", Value: "refund", Exact: true, At: 0}},
+		{
+			"a OR b c",
+			&Or{
+				Nodes: []Node{
+					&Text{Value: "a", At: 0},
+					&And{Nodes: []Node{&Text{Value: "b", At: 5}, &Text{Value: "c", At: 7}}, At: 5},
+				},
+				At: 0,
+			},
+		},
+		{
+			"NOT (a OR b)",
+			&Not{
+				Node: &Or{Nodes: []Node{&Text{Value: "a", At: 5}, &Text{Value: "b", At: 10}}, At: 5},
+				At:   0,
+			},
+		},
+		{"a AND b", &And{Nodes: []Node{&Text{Value: "a", At: 0}, &Text{Value: "b", At: 6}}, At: 0}},
+		{"amount>50", &AmountRange{Min: intp(5001), At: 0}},
+		{"amount>=50", &AmountRange{Min: intp(5000), At: 0}},
+		{"amount<50", &AmountRange{Max: intp(4999), At: 0}},
+		{"amount<=50", &AmountRange{Max: intp(5000), At: 0}},
+		{"amount:12.5", &AmountRange{Min: intp(1250), Max: intp(1250), At: 0}},
+		{"amount:10..20.05", &AmountRange{Min: intp(1000), Max: intp(2005), At: 0}},
+		{"amount:..20", &AmountRange{Max: intp(2000), At: 0}},
+		{"date:2023", &DateRange{From: datep(2023, time.January, 1), To: datep(2024, time.January, 1), At: 0}},
+		{"date>2023-05-14", &DateRange{From: datep(2023, time.May, 15), At: 0}},
+		{"date<2023-05", &DateRange{To: datep(2023, time.May, 1), At: 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := Parse(tt.query)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %#v, want %#v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+	}{
+		{"vendor:", 7},
+		{`vendor:"corner store`, 7},
+		{`"abc`, 0},
+		{"foo:bar", 0},
+		{"a vendor>x", 8},
+		{"type:foo", 5},
+		{"status:done", 7},
+		{"amount:1.234", 7},
+		{"amount:5..abc", 10},
+		{"amount:10..5", 7},
+		{"amount>1..5", 6},
+		{"date:..", 5},
+		{"date:2023-13", 5},
+		{"date:2023-07..2023-05", 5},
+		{"(a b", 0},
+		{"a )", 2},
+		{"a AND", 5},
+		{"AND a", 0},
+		{"a OR", 4},
+		{"NOT", 3},
+		{"a -(", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := Parse(tt.query)
+
+			var parseErr *Error
+
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Parse(%q) error = %v, want *Error", tt.query, err)
+			}
+
+			if parseErr.Pos != tt.pos {
+				t.Errorf("Parse(%q) error at %d (%s), want %d", tt.query, parseErr.Pos, parseErr.Message, tt.pos)
+			}
+		})
+	}
+}
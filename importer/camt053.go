@@ -0,0 +1,193 @@
+package importer
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/alexgaudon/budgie/models"
+)
+
+// camtParty covers both the pre-2019 layout (Cdtr/Nm) and the newer one
+// (Cdtr/Pty/Nm).
+type camtParty struct {
+	Name    string `xml:"Nm"`
+	PtyName string `xml:"Pty>Nm"`
+}
+
+func (p camtParty) name() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.PtyName
+}
+
+type camtTxDetails struct {
+	EndToEndID   string    `xml:"Refs>EndToEndId"`
+	AcctSvcrRef  string    `xml:"Refs>AcctSvcrRef"`
+	Debtor       camtParty `xml:"RltdPties>Dbtr"`
+	Creditor     camtParty `xml:"RltdPties>Cdtr"`
+	Unstructured []string  `xml:"RmtInf>Ustrd"`
+	CreditorRef  string    `xml:"RmtInf>Strd>CdtrRefInf>Ref"`
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+type camtEntry struct {
+	EntryRef       string          `xml:"NtryRef"`
+	Amount         camtAmount      `xml:"Amt"`
+	CreditDebit    string          `xml:"CdtDbtInd"`
+	Reversal       bool            `xml:"RvslInd"`
+	BookingDate    string          `xml:"BookgDt>Dt"`
+	BookingTime    string          `xml:"BookgDt>DtTm"`
+	AcctSvcrRef    string          `xml:"AcctSvcrRef"`
+	Details        []camtTxDetails `xml:"NtryDtls>TxDtls"`
+	AdditionalInfo string          `xml:"AddtlNtryInf"`
+}
+
+type camtDocument struct {
+	Statements []struct {
+		Entries []camtEntry `xml:"Ntry"`
+	} `xml:"BkToCstmrStmt>Stmt"`
+}
+
+// ParseCamt053 reads the entries (Ntry) of an ISO 20022 camt.053 bank to
+// customer statement. The counterparty becomes the vendor: the creditor for
+// debits and the debtor for credits. Remittance information becomes the
+// description. Amounts are read in the currency; entries in another one are
+// reported on their rows.
+func ParseCamt053(r io.Reader, currency string) ([]*Row, error) {
+	doc := &camtDocument{}
+
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		raw, err := io.ReadAll(input)
+
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := Decode(raw, charset)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return strings.NewReader(string(data)), nil
+	}
+
+	if err := decoder.Decode(doc); err != nil {
+		return nil, fmt.Errorf("file is not a camt.053 statement: %w", err)
+	}
+
+	rows := []*Row{}
+
+	for _, statement := range doc.Statements {
+		for _, entry := range statement.Entries {
+			rows = append(rows, camtRow(len(rows)+1, entry, currency))
+		}
+	}
+
+	if len(rows) == 0 && len(doc.Statements) == 0 {
+		return nil, fmt.Errorf("file is not a camt.053 statement")
+	}
+
+	return rows, nil
+}
+
+func camtRow(line int, entry camtEntry, currency string) *Row {
+	row := &Row{
+		Line:        line,
+		ExternalID:  firstNonEmpty(entry.AcctSvcrRef, entry.EntryRef),
+		Description: strings.TrimSpace(entry.AdditionalInfo),
+	}
+
+	debit := entry.CreditDebit == "DBIT"
+
+	if len(entry.Details) > 0 {
+		details := entry.Details[0]
+
+		if debit {
+			row.Vendor = details.Creditor.name()
+		} else {
+			row.Vendor = details.Debtor.name()
+		}
+
+		remittance := strings.TrimSpace(strings.Join(details.Unstructured, " "))
+
+		if remittance == "" {
+			remittance = details.CreditorRef
+		}
+
+		if remittance != "" {
+			row.Description = remittance
+		}
+
+		if row.ExternalID == "" && details.EndToEndID != "NOTPROVIDED" {
+			row.ExternalID = firstNonEmpty(details.AcctSvcrRef, details.EndToEndID)
+		}
+	}
+
+	if row.Vendor == "" {
+		row.Vendor = row.Description
+	}
+
+	date := entry.BookingDate
+	if date == "" && len(entry.BookingTime) >= 10 {
+		date = entry.BookingTime[:10]
+	}
+
+	var err error
+
+	row.Date, err = time.Parse("2006-01-02", date)
+
+	if err != nil {
+		row.Err = fmt.Errorf("invalid booking date %q", date)
+		return row
+	}
+
+	if entry.Amount.Currency != "" && entry.Amount.Currency != currency {
+		row.Err = fmt.Errorf("amount is in %s, not %s", entry.Amount.Currency, currency)
+		return row
+	}
+
+	row.Amount, err = models.ParseMoney(entry.Amount.Value, currency, ".")
+
+	if err != nil {
+		row.Err = err
+		return row
+	}
+
+	switch entry.CreditDebit {
+	case "DBIT":
+		row.Amount = outgoing(row.Amount)
+	case "CRDT":
+		row.Amount = abs(row.Amount)
+	default:
+		row.Err = fmt.Errorf("invalid credit/debit indicator %q", entry.CreditDebit)
+		return row
+	}
+
+	row.Type = typeForAmount(row.Amount)
+
+	// A reversed debit gives back money that was paid out.
+	if entry.Reversal && row.Amount.Sign() > 0 {
+		row.Type = "refund"
+	}
+
+	return row
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,12 @@
+package importer
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseCamt053Golden(t *testing.T) {
+	checkGolden(t, "camt053/*.xml", func(f *os.File) ([]*Row, error) {
+		return ParseCamt053(f, "EUR")
+	})
+}
@@ -0,0 +1,272 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexgaudon/budgie/models"
+)
+
+// dateTokens maps the date format tokens profiles are written in to Go
+// layout elements, longest first.
+var dateTokens = []struct {
+	token  string
+	layout string
+}{
+	{"YYYY", "2006"},
+	{"MMMM", "January"},
+	{"MMM", "Jan"},
+	{"YY", "06"},
+	{"MM", "01"},
+	{"DD", "02"},
+	{"M", "1"},
+	{"D", "2"},
+}
+
+// DateLayout turns a date format such as "DD/MM/YYYY" or "MMM D, YYYY" into a
+// Go time layout.
+func DateLayout(format string) string {
+	var layout strings.Builder
+
+	for i := 0; i < len(format); {
+		matched := false
+
+		for _, t := range dateTokens {
+			if strings.HasPrefix(format[i:], t.token) {
+				layout.WriteString(t.layout)
+				i += len(t.token)
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			layout.WriteByte(format[i])
+			i++
+		}
+	}
+
+	return layout.String()
+}
+
+// csvColumns resolves the columns named in a profile to record indexes.
+// With a header, columns are matched by name (case-insensitively); without
+// one they are 1-based column numbers.
+type csvColumns struct {
+	header map[string]int
+}
+
+func (c *csvColumns) index(column string) (int, error) {
+	if c.header != nil {
+		i, ok := c.header[strings.ToLower(strings.TrimSpace(column))]
+
+		if !ok {
+			return 0, fmt.Errorf("column %q not found in header", column)
+		}
+
+		return i, nil
+	}
+
+	i, err := strconv.Atoi(column)
+
+	if err != nil || i < 1 {
+		return 0, fmt.Errorf("column %q must be a column number when the file has no header", column)
+	}
+
+	return i - 1, nil
+}
+
+func (c *csvColumns) value(record []string, column models.OptionalString) (string, error) {
+	if !column.Valid || column.String == "" {
+		return "", nil
+	}
+
+	i, err := c.index(column.String)
+
+	if err != nil {
+		return "", err
+	}
+
+	if i >= len(record) {
+		return "", nil
+	}
+
+	return strings.TrimSpace(record[i]), nil
+}
+
+// ParseCSV reads a CSV statement in the currency using the column mapping in
+// the profile. The returned error is only set when the file as a whole can't
+// be read; problems with individual records are reported on the rows.
+func ParseCSV(r io.Reader, profile *models.ImportProfile, currency string) ([]*Row, error) {
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+
+	raw, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := Decode(raw, profile.Encoding)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = []rune(profile.Delimiter)[0]
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	columns := &csvColumns{}
+	layout := DateLayout(profile.DateFormat)
+	rows := []*Row{}
+	line := 0
+
+	for {
+		record, err := reader.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		line++
+
+		if err != nil {
+			rows = append(rows, &Row{Line: line, Err: err})
+			continue
+		}
+
+		if profile.HasHeader && columns.header == nil {
+			columns.header = map[string]int{}
+			for i, name := range record {
+				columns.header[strings.ToLower(strings.TrimSpace(name))] = i
+			}
+			continue
+		}
+
+		if isBlankRecord(record) {
+			continue
+		}
+
+		row := &Row{Line: line, Amount: models.NewMoney(0, currency)}
+		row.Err = parseCSVRecord(row, record, columns, profile, layout)
+		row.Type = typeForAmount(row.Amount)
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func parseCSVRecord(row *Row, record []string, columns *csvColumns, profile *models.ImportProfile, layout string) error {
+	column := func(name string) models.OptionalString {
+		return models.OptionalString{String: name, Valid: name != ""}
+	}
+
+	dateValue, err := columns.value(record, column(profile.DateColumn))
+
+	if err != nil {
+		return err
+	}
+
+	row.Date, err = time.Parse(layout, dateValue)
+
+	if err != nil {
+		return fmt.Errorf("date %q does not match format %s", dateValue, profile.DateFormat)
+	}
+
+	if row.Vendor, err = columns.value(record, column(profile.VendorColumn)); err != nil {
+		return err
+	}
+
+	if row.Description, err = columns.value(record, profile.DescriptionColumn); err != nil {
+		return err
+	}
+
+	if row.Category, err = columns.value(record, profile.CategoryColumn); err != nil {
+		return err
+	}
+
+	if row.Vendor == "" {
+		row.Vendor = row.Description
+	}
+
+	if profile.AmountColumn.Valid {
+		value, err := columns.value(record, profile.AmountColumn)
+
+		if err != nil {
+			return err
+		}
+
+		amount, err := models.ParseMoney(value, row.Amount.Currency, profile.DecimalSeparator)
+
+		if err != nil {
+			return err
+		}
+
+		if profile.SignConvention == models.SignPositiveIsExpense {
+			amount.Minor = -amount.Minor
+		}
+
+		row.Amount = amount
+
+		return nil
+	}
+
+	debit, err := columns.value(record, profile.DebitColumn)
+
+	if err != nil {
+		return err
+	}
+
+	credit, err := columns.value(record, profile.CreditColumn)
+
+	if err != nil {
+		return err
+	}
+
+	if debit == "" && credit == "" {
+		return fmt.Errorf("row has neither a debit nor a credit amount")
+	}
+
+	if debit != "" {
+		amount, err := models.ParseMoney(debit, row.Amount.Currency, profile.DecimalSeparator)
+
+		if err == nil {
+			row.Amount, err = row.Amount.Sub(abs(amount))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if credit != "" {
+		amount, err := models.ParseMoney(credit, row.Amount.Currency, profile.DecimalSeparator)
+
+		if err == nil {
+			row.Amount, err = row.Amount.Add(abs(amount))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isBlankRecord(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ECBBase is the currency every European Central Bank reference rate is
+// quoted against.
+const ECBBase = "EUR"
+
+// Rate is one reference rate: one euro was worth Rate units of Currency on
+// Date. Rate is kept as the decimal string from the file so no precision is
+// lost.
+type Rate struct {
+	Date     time.Time `json:"date"`
+	Currency string    `json:"currency"`
+	Rate     string    `json:"rate"`
+}
+
+type ecbDocument struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+// ParseECB reads European Central Bank euro reference rates, either the XML
+// feed (eurofxref-daily.xml, eurofxref-hist.xml) or the CSV files from the
+// zipped downloads (eurofxref.csv, eurofxref-hist.csv). The format is
+// detected from the content.
+func ParseECB(r io.Reader) ([]*Rate, error) {
+	br := bufio.NewReader(r)
+
+	head, err := br.Peek(64)
+
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))), []byte("<")) {
+		return parseECBXML(br)
+	}
+
+	return parseECBCSV(br)
+}
+
+func parseECBXML(r io.Reader) ([]*Rate, error) {
+	doc := &ecbDocument{}
+
+	if err := xml.NewDecoder(r).Decode(doc); err != nil {
+		return nil, fmt.Errorf("invalid ECB XML: %w", err)
+	}
+
+	rates := []*Rate{}
+
+	for _, day := range doc.Days {
+		date, err := time.Parse("2006-01-02", day.Time)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q", day.Time)
+		}
+
+		for _, rate := range day.Rates {
+			rates = append(rates, &Rate{
+				Date:     date,
+				Currency: strings.ToUpper(rate.Currency),
+				Rate:     rate.Rate,
+			})
+		}
+	}
+
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("no rates found")
+	}
+
+	return rates, nil
+}
+
+// parseECBCSV reads a header of currency codes after a Date column, then a
+// row per day. The daily file writes dates as "05 January 2024" and the
+// history as "2024-01-05". Currencies without a rate that day are "N/A" in
+// the history, and both files end every line with a comma.
+func parseECBCSV(r io.Reader) ([]*Rate, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid ECB CSV: %w", err)
+	}
+
+	if len(records) == 0 || !strings.EqualFold(strings.TrimPrefix(strings.TrimSpace(records[0][0]), "\ufeff"), "Date") {
+		return nil, fmt.Errorf("not an ECB reference rate file, the first column must be Date")
+	}
+
+	header := records[0]
+	rates := []*Rate{}
+
+	for i, record := range records[1:] {
+		line := i + 2
+		value := strings.TrimSpace(record[0])
+
+		if value == "" {
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02", value)
+
+		if err != nil {
+			date, err = time.Parse("02 January 2006", value)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q", line, value)
+		}
+
+		for col := 1; col < len(record) && col < len(header); col++ {
+			currency := strings.ToUpper(strings.TrimSpace(header[col]))
+			rate := strings.TrimSpace(record[col])
+
+			if currency == "" || rate == "" || rate == "N/A" {
+				continue
+			}
+
+			rates = append(rates, &Rate{
+				Date:     date,
+				Currency: currency,
+				Rate:     rate,
+			})
+		}
+	}
+
+	if len(rates) == 0 {
+		return nil, fmt.Errorf("no rates found")
+	}
+
+	return rates, nil
+}
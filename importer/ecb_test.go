@@ -0,0 +1,15 @@
+package importer
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseECBGolden(t *testing.T) {
+	parse := func(f *os.File) (any, error) {
+		return ParseECB(f)
+	}
+
+	checkGoldenJSON(t, "ecb/*.xml", parse)
+	checkGoldenJSON(t, "ecb/*.csv", parse)
+}
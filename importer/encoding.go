@@ -0,0 +1,50 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// windows1252 maps the bytes 0x80-0x9F, where Windows-1252 differs from
+// ISO-8859-1. Unassigned bytes map to the replacement character.
+var windows1252 = [32]rune{
+	'€', '�', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '�', 'Ž', '�',
+	'�', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '�', 'ž', 'Ÿ',
+}
+
+// Decode converts data in the given encoding to UTF-8, dropping any UTF-8 byte
+// order mark.
+func Decode(data []byte, encoding string) ([]byte, error) {
+	switch strings.ToLower(encoding) {
+	case "", "utf-8", "utf8":
+		data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+		if !utf8.Valid(data) {
+			return nil, fmt.Errorf("file is not valid utf-8, try another encoding")
+		}
+
+		return data, nil
+	case "iso-8859-1", "latin1":
+		return decodeSingleByte(data, false), nil
+	case "windows-1252", "cp1252":
+		return decodeSingleByte(data, true), nil
+	}
+
+	return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+}
+
+func decodeSingleByte(data []byte, cp1252 bool) []byte {
+	var buf bytes.Buffer
+
+	for _, b := range data {
+		if cp1252 && b >= 0x80 && b <= 0x9f {
+			buf.WriteRune(windows1252[b-0x80])
+			continue
+		}
+		buf.WriteRune(rune(b))
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,50 @@
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/alexgaudon/budgie/models"
+)
+
+// Fingerprint sets the source fingerprint of every row, which identifies the
+// entry across imports of overlapping statements. Rows with a bank identifier
+// are fingerprinted by it. Others are fingerprinted by date, amount and
+// normalized vendor, along with how many identical entries precede them in the
+// file, so two equal purchases on the same day are both kept.
+func Fingerprint(rows []*Row) {
+	seen := map[string]int{}
+
+	for _, row := range rows {
+		if row.Err != nil {
+			continue
+		}
+
+		if row.ExternalID != "" {
+			row.Fingerprint = hash("ext:" + row.ExternalID)
+			continue
+		}
+
+		key := fmt.Sprintf("row:%s|%d|%s", row.Date.Format("2006-01-02"), fingerprintAmount(row.Amount), models.NormalizeVendor(row.Vendor))
+		seen[key]++
+
+		row.Fingerprint = hash(fmt.Sprintf("%s|%d", key, seen[key]))
+	}
+}
+
+// fingerprintAmount gives amounts in hundredths, as rows held them before they
+// carried Money, so entries imported back then are still recognized.
+// Currencies with finer minor units, which couldn't be imported then, use
+// those.
+func fingerprintAmount(amount models.Money) int64 {
+	if models.CurrencyExponent(amount.Currency) > 2 {
+		return amount.Minor
+	}
+	return amount.Cents()
+}
+
+func hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,138 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// update rewrites the golden files from the parsers' current output:
+//
+//	go test ./importer -update
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// goldenRow is a parsed row as recorded in a golden file, with its error.
+type goldenRow struct {
+	*Row
+	Error string `json:"error,omitempty"`
+}
+
+// checkGolden parses every file matching pattern under testdata and compares
+// the rows with the JSON in the file of the same name plus ".golden".
+func checkGolden(t *testing.T, pattern string, parse func(*os.File) ([]*Row, error)) {
+	t.Helper()
+
+	checkGoldenJSON(t, pattern, func(f *os.File) (any, error) {
+		rows, err := parse(f)
+
+		if err != nil {
+			return nil, err
+		}
+
+		golden := []goldenRow{}
+
+		for _, row := range rows {
+			g := goldenRow{Row: row}
+
+			if row.Err != nil {
+				g.Error = row.Err.Error()
+			}
+
+			golden = append(golden, g)
+		}
+
+		return golden, nil
+	})
+}
+
+// checkGoldenJSON parses every file matching pattern under testdata and
+// compares what parse returns, as indented JSON, with the file of the same
+// name plus ".golden".
+func checkGoldenJSON(t *testing.T, pattern string, parse func(*os.File) (any, error)) {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join("testdata", pattern))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) == 0 {
+		t.Fatalf("no files match testdata/%s", pattern)
+	}
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			f, err := os.Open(file)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer f.Close()
+
+			parsed, err := parse(f)
+
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			got, err := json.MarshalIndent(parsed, "", "  ")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got = append(got, '\n')
+			path := file + ".golden"
+
+			if *update {
+				if err := os.WriteFile(path, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(path)
+
+			if err != nil {
+				t.Fatalf("%v (run with -update to create it)", err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("output differs from %s:\n%s", path, diffLines(string(want), string(got)))
+			}
+		})
+	}
+}
+
+// diffLines lists the lines that differ between two texts, position by
+// position; enough to spot a changed field.
+func diffLines(want, got string) string {
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+	var b strings.Builder
+
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+
+		if w != g {
+			fmt.Fprintf(&b, "line %d:\n  want %s\n  got  %s\n", i+1, w, g)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,70 @@
+// Package importer parses bank statement files into rows that the server can
+// turn into transactions, and writes rows back out for export. It only deals
+// with files; it never touches the database.
+package importer
+
+import (
+	"time"
+
+	"github.com/alexgaudon/budgie/models"
+)
+
+// Row is a single statement entry read from an import file. Line is the
+// 1-based position of the entry in the source, so failures can be reported
+// back to the user. Amount is in the currency the file is read in and
+// negative when money leaves the account. Type is the transaction type, from the source format when it
+// has one and from the sign of the amount otherwise; credits that reverse or
+// refund a payment are typed "refund". Category, ExternalID and
+// Splits are only set when the source format carries them. Fingerprint is set
+// by Fingerprint. Err is set when the entry could not be read.
+type Row struct {
+	Line        int          `json:"line"`
+	Date        time.Time    `json:"date"`
+	Amount      models.Money `json:"amount"`
+	Type        string       `json:"type"`
+	Vendor      string       `json:"vendor"`
+	Description string       `json:"description"`
+	Category    string       `json:"category"`
+	ExternalID  string       `json:"external_id"`
+	Fingerprint string       `json:"fingerprint"`
+	Splits      []*RowSplit  `json:"splits"`
+	Err         error        `json:"-"`
+}
+
+// RowSplit is one category line of a split entry. Amount carries the same
+// sign as the entry it belongs to.
+type RowSplit struct {
+	Category string       `json:"category"`
+	Amount   models.Money `json:"amount"`
+	Memo     string       `json:"memo"`
+}
+
+// typeForAmount returns the transaction type implied by the sign of an
+// amount.
+func typeForAmount(amount models.Money) string {
+	if amount.Sign() < 0 {
+		return "expense"
+	}
+	return "income"
+}
+
+// AbsAmount returns the unsigned amount, as stored on transactions.
+func (row *Row) AbsAmount() models.Money {
+	return abs(row.Amount)
+}
+
+// abs returns the amount without its sign. Parsed amounts can't be
+// math.MinInt64, so this can't overflow.
+func abs(amount models.Money) models.Money {
+	if amount.Minor < 0 {
+		amount.Minor = -amount.Minor
+	}
+	return amount
+}
+
+// outgoing returns the amount as money leaving the account: negative.
+func outgoing(amount models.Money) models.Money {
+	amount = abs(amount)
+	amount.Minor = -amount.Minor
+	return amount
+}
@@ -0,0 +1,264 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/alexgaudon/budgie/models"
+)
+
+// mt940StatementLine matches the :61: field: value date (YYMMDD), optional
+// entry date (MMDD), debit/credit mark, optional funds code, amount,
+// transaction type, customer reference and optional bank reference.
+var mt940StatementLine = regexp.MustCompile(`^(\d{6})(\d{4})?(RC|RD|C|D)([A-Z])?(\d+,\d*)([NSF][A-Z0-9]{3})([^/]*)(?://(.*))?`)
+
+// mt940Field is one tagged field with its continuation lines.
+type mt940Field struct {
+	tag   string
+	lines []string
+	line  int
+}
+
+// ParseMT940 reads the statement lines (:61:) of a SWIFT MT940 statement
+// along with the information to account owner (:86:) that follows each one.
+// The entry date is used when present, the value date otherwise. Amounts are
+// read in the currency.
+func ParseMT940(r io.Reader, currency string) ([]*Row, error) {
+	raw, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := Decode(raw, "utf-8")
+
+	if err != nil {
+		data = decodeSingleByte(raw, true)
+	}
+
+	fields := []*mt940Field{}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r ")
+
+		if tag, value, ok := mt940Tag(text); ok {
+			fields = append(fields, &mt940Field{tag: tag, lines: []string{value}, line: line})
+			continue
+		}
+
+		// "-" ends a statement; the {1:...} block headers carry nothing we use.
+		if text == "-" || text == "-}" || strings.HasPrefix(text, "{") {
+			continue
+		}
+
+		if len(fields) > 0 && text != "" {
+			last := fields[len(fields)-1]
+			last.lines = append(last.lines, text)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	rows := []*Row{}
+	var row *Row
+	statements := 0
+
+	for _, field := range fields {
+		switch field.tag {
+		case "20":
+			statements++
+		case "61":
+			row = mt940Row(field, currency)
+			rows = append(rows, row)
+		case "86":
+			if row != nil {
+				mt940Information(row, field.lines)
+			}
+			row = nil
+		default:
+			row = nil
+		}
+	}
+
+	if statements == 0 {
+		return nil, fmt.Errorf("file is not an MT940 statement")
+	}
+
+	return rows, nil
+}
+
+// mt940Tag splits a ":TAG:value" line. Tags are two digits with an optional
+// letter.
+func mt940Tag(text string) (string, string, bool) {
+	if len(text) < 4 || text[0] != ':' {
+		return "", "", false
+	}
+
+	end := strings.IndexByte(text[1:], ':')
+
+	if end < 2 || end > 3 {
+		return "", "", false
+	}
+
+	tag := text[1 : end+1]
+
+	if tag[0] < '0' || tag[0] > '9' || tag[1] < '0' || tag[1] > '9' {
+		return "", "", false
+	}
+
+	return tag, text[end+2:], true
+}
+
+func mt940Row(field *mt940Field, currency string) *Row {
+	row := &Row{Line: field.line}
+
+	match := mt940StatementLine.FindStringSubmatch(field.lines[0])
+
+	if match == nil {
+		row.Err = fmt.Errorf("invalid statement line %q", field.lines[0])
+		return row
+	}
+
+	valueDate, err := time.Parse("060102", match[1])
+
+	if err != nil {
+		row.Err = fmt.Errorf("invalid value date %q", match[1])
+		return row
+	}
+
+	row.Date = valueDate
+
+	if match[2] != "" {
+		entryDate, err := time.Parse("0102", match[2])
+
+		if err != nil {
+			row.Err = fmt.Errorf("invalid entry date %q", match[2])
+			return row
+		}
+
+		// The entry date has no year; pick the one closest to the value date,
+		// since bookings can straddle the new year either way.
+		row.Date = time.Date(valueDate.Year(), entryDate.Month(), entryDate.Day(), 0, 0, 0, 0, time.UTC)
+
+		if row.Date.Sub(valueDate) > 180*24*time.Hour {
+			row.Date = row.Date.AddDate(-1, 0, 0)
+		} else if valueDate.Sub(row.Date) > 180*24*time.Hour {
+			row.Date = row.Date.AddDate(1, 0, 0)
+		}
+	}
+
+	row.Amount, err = models.ParseMoney(match[5], currency, ",")
+
+	if err != nil {
+		row.Err = err
+		return row
+	}
+
+	// RC and RD reverse an earlier credit or debit.
+	switch match[3] {
+	case "C", "RD":
+		row.Amount = abs(row.Amount)
+	case "D", "RC":
+		row.Amount = outgoing(row.Amount)
+	}
+
+	row.Type = typeForAmount(row.Amount)
+
+	if match[3] == "RD" {
+		row.Type = "refund"
+	}
+
+	row.ExternalID = strings.TrimSpace(match[8])
+	if customer := strings.TrimSpace(match[7]); row.ExternalID == "" && customer != "NONREF" {
+		row.ExternalID = customer
+	}
+
+	// The optional second line is supplementary details.
+	if len(field.lines) > 1 {
+		row.Description = strings.TrimSpace(strings.Join(field.lines[1:], " "))
+	}
+
+	return row
+}
+
+// mt940Information fills the vendor and description from a :86: field. The
+// German structured form (?20-?29 remittance, ?32-?33 counterparty name) and
+// SWIFT codes (/NAME/, /REMI/) are recognized; anything else is taken as
+// free text.
+func mt940Information(row *Row, lines []string) {
+	joined := strings.Join(lines, "")
+
+	switch {
+	case strings.Contains(joined, "?20") || strings.Contains(joined, "?32"):
+		var remittance, name []string
+
+		for _, part := range strings.Split(joined, "?")[1:] {
+			if len(part) < 2 {
+				continue
+			}
+
+			code, value := part[:2], part[2:]
+
+			switch {
+			case code >= "20" && code <= "29", code >= "60" && code <= "63":
+				remittance = append(remittance, value)
+			case code == "32" || code == "33":
+				name = append(name, value)
+			}
+		}
+
+		row.Vendor = strings.TrimSpace(strings.Join(name, ""))
+		row.Description = strings.TrimSpace(strings.Join(remittance, ""))
+	case strings.Contains(joined, "/NAME/") || strings.Contains(joined, "/REMI/"):
+		codes := mt940Codes(joined)
+		row.Vendor = codes["NAME"]
+		row.Description = codes["REMI"]
+	default:
+		row.Description = strings.TrimSpace(strings.Join(lines, " "))
+	}
+
+	if row.Vendor == "" {
+		row.Vendor = row.Description
+	}
+
+	if row.Description == row.Vendor {
+		row.Description = ""
+	}
+}
+
+// mt940Codes reads "/CODE/value" pairs, where a value runs up to the next
+// known code.
+func mt940Codes(s string) map[string]string {
+	codes := map[string]string{}
+	known := []string{"/ORDP/", "/BENM/", "/NAME/", "/REMI/", "/EREF/", "/ADDR/", "/IBAN/", "/BIC/", "/TRCD/", "/CSID/", "/MARF/", "/PURP/", "/ULTC/", "/ULTD/"}
+
+	for _, code := range known {
+		start := strings.Index(s, code)
+
+		if start < 0 {
+			continue
+		}
+
+		value := s[start+len(code):]
+		end := len(value)
+
+		for _, other := range known {
+			if i := strings.Index(value, other); i >= 0 && i < end {
+				end = i
+			}
+		}
+
+		codes[strings.Trim(code, "/")] = strings.Trim(strings.TrimSpace(value[:end]), "/")
+	}
+
+	return codes
+}
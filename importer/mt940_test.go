@@ -0,0 +1,12 @@
+package importer
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseMT940Golden(t *testing.T) {
+	checkGolden(t, "mt940/*.sta", func(f *os.File) ([]*Row, error) {
+		return ParseMT940(f, "EUR")
+	})
+}
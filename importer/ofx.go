@@ -0,0 +1,215 @@
+package importer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alexgaudon/budgie/models"
+)
+
+// ofxIncomeTypes are the OFX TRNTYPE values that always mean money in.
+// XFER and OTHER go by the sign of the amount; everything else is money out.
+var ofxIncomeTypes = map[string]bool{
+	"CREDIT":    true,
+	"DEP":       true,
+	"INT":       true,
+	"DIV":       true,
+	"DIRECTDEP": true,
+}
+
+var ofxEntities = strings.NewReplacer(
+	"&lt;", "<",
+	"&gt;", ">",
+	"&quot;", `"`,
+	"&apos;", "'",
+	"&nbsp;", " ",
+	"&amp;", "&",
+)
+
+// ParseOFX reads the STMTTRN entries of an OFX or QFX statement. Both OFX 1.x
+// (SGML, where leaf elements are not closed) and OFX 2.x (XML) are
+// supported: leaf values are read up to the next tag either way. Amounts are
+// read in the currency. Money coming in on a credit card statement
+// (CCSTMTRS) is typed as a refund.
+func ParseOFX(r io.Reader, currency string) ([]*Row, error) {
+	data, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data = decodeOFX(data)
+
+	start := bytes.Index(bytes.ToUpper(data), []byte("<OFX>"))
+
+	if start < 0 {
+		return nil, fmt.Errorf("file is not an OFX statement")
+	}
+
+	rows := []*Row{}
+	var entry map[string]string
+	var parents []string
+	body := string(data[start:])
+
+	for i := 0; i < len(body); {
+		open := strings.IndexByte(body[i:], '<')
+
+		if open < 0 {
+			break
+		}
+
+		open += i
+		end := strings.IndexByte(body[open:], '>')
+
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated tag in OFX statement")
+		}
+
+		end += open
+		tag := strings.ToUpper(strings.TrimSpace(body[open+1 : end]))
+
+		next := strings.IndexByte(body[end+1:], '<')
+		if next < 0 {
+			next = len(body)
+		} else {
+			next += end + 1
+		}
+
+		value := strings.TrimSpace(ofxEntities.Replace(body[end+1 : next]))
+		i = next
+
+		switch {
+		case strings.HasPrefix(tag, "?"), strings.HasPrefix(tag, "!"):
+		case tag == "STMTTRN":
+			entry = map[string]string{}
+		case tag == "/STMTTRN":
+			if entry != nil {
+				rows = append(rows, ofxRow(len(rows)+1, entry, currency, ofxCardStatement(parents)))
+			}
+			entry = nil
+		case strings.HasPrefix(tag, "/"):
+			// XML closes leaf elements too; only aggregates are on the stack.
+			if len(parents) > 0 && parents[len(parents)-1] == tag[1:] {
+				parents = parents[:len(parents)-1]
+			}
+		case value == "":
+			parents = append(parents, tag)
+		case entry != nil:
+			// PAYEE aggregates carry their own NAME; keep the STMTTRN one.
+			if tag == "NAME" && len(parents) > 0 && parents[len(parents)-1] == "PAYEE" {
+				tag = "PAYEE.NAME"
+			}
+			entry[tag] = value
+		}
+	}
+
+	return rows, nil
+}
+
+// ofxCardStatement reports whether the aggregates open around an entry
+// include a credit card statement.
+func ofxCardStatement(parents []string) bool {
+	for _, parent := range parents {
+		if parent == "CCSTMTRS" {
+			return true
+		}
+	}
+	return false
+}
+
+func ofxRow(line int, entry map[string]string, currency string, card bool) *Row {
+	row := &Row{
+		Line:        line,
+		ExternalID:  entry["FITID"],
+		Vendor:      entry["NAME"],
+		Description: entry["MEMO"],
+	}
+
+	if row.Vendor == "" {
+		row.Vendor = entry["PAYEE.NAME"]
+	}
+
+	if row.Vendor == "" {
+		row.Vendor = row.Description
+	}
+
+	if row.Description == row.Vendor {
+		row.Description = ""
+	}
+
+	date, err := ParseOFXDate(entry["DTPOSTED"])
+
+	if err != nil {
+		row.Err = err
+		return row
+	}
+
+	row.Date = date
+
+	decimalSeparator := "."
+	if strings.Contains(entry["TRNAMT"], ",") && !strings.Contains(entry["TRNAMT"], ".") {
+		decimalSeparator = ","
+	}
+
+	row.Amount, err = models.ParseMoney(entry["TRNAMT"], currency, decimalSeparator)
+
+	if err != nil {
+		row.Err = err
+		return row
+	}
+
+	trnType := strings.ToUpper(entry["TRNTYPE"])
+
+	switch {
+	case ofxIncomeTypes[trnType]:
+		row.Type = "income"
+	case trnType == "XFER", trnType == "OTHER", trnType == "":
+		row.Type = typeForAmount(row.Amount)
+	default:
+		row.Type = "expense"
+	}
+
+	if card && row.Type == "income" {
+		row.Type = "refund"
+	}
+
+	return row
+}
+
+// ParseOFXDate reads an OFX date, YYYYMMDD optionally followed by HHMMSS,
+// fractional seconds and a [offset:TZ] suffix. Only the date is kept.
+func ParseOFXDate(s string) (time.Time, error) {
+	if len(s) < 8 {
+		return time.Time{}, fmt.Errorf("invalid OFX date %q", s)
+	}
+
+	date, err := time.Parse("20060102", s[:8])
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid OFX date %q", s)
+	}
+
+	return date, nil
+}
+
+// decodeOFX converts Windows-1252 statements, which OFX 1.x headers announce
+// with CHARSET:1252 and which some banks send without saying so, to UTF-8.
+func decodeOFX(data []byte) []byte {
+	header := data
+
+	if len(header) > 512 {
+		header = header[:512]
+	}
+
+	upper := strings.ToUpper(string(header))
+
+	if strings.Contains(upper, "CHARSET:1252") || strings.Contains(upper, "CHARSET:ISO-8859-1") || !utf8.Valid(data) {
+		return decodeSingleByte(data, true)
+	}
+
+	return bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+}
@@ -0,0 +1,46 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOFXTypes(t *testing.T) {
+	const entries = `<BANKTRANLIST>
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20240105<TRNAMT>-40.00<FITID>1<NAME>Store</STMTTRN>
+<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20240108<TRNAMT>15.00<FITID>2<NAME>Store</STMTTRN>
+</BANKTRANLIST>`
+
+	tests := []struct {
+		name      string
+		statement string
+		want      []string
+	}{
+		{"bank", "<OFX><BANKMSGSRSV1><STMTTRNRS><STMTRS>" + entries + "</STMTRS></STMTTRNRS></BANKMSGSRSV1></OFX>", []string{"expense", "income"}},
+		{"card", "<OFX><CREDITCARDMSGSRSV1><CCSTMTTRNRS><CCSTMTRS>" + entries + "</CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1></OFX>", []string{"expense", "refund"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := ParseOFX(strings.NewReader(tt.statement), "USD")
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(rows) != len(tt.want) {
+				t.Fatalf("got %d rows, want %d", len(rows), len(tt.want))
+			}
+
+			for i, row := range rows {
+				if row.Err != nil {
+					t.Fatalf("row %d: %v", i+1, row.Err)
+				}
+
+				if row.Type != tt.want[i] {
+					t.Errorf("row %d type = %q, want %q", i+1, row.Type, tt.want[i])
+				}
+			}
+		})
+	}
+}
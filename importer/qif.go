@@ -0,0 +1,307 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexgaudon/budgie/models"
+)
+
+// QIFAccount is an account section of a QIF file. Kind is the QIF account
+// type (Bank, CCard, Cash, ...). Rows of an account with no name are written
+// without an !Account header.
+type QIFAccount struct {
+	Name string
+	Kind string
+	Rows []*Row
+}
+
+// qifSections are the transaction list headers the reader understands.
+var qifSections = map[string]bool{
+	"!TYPE:BANK":  true,
+	"!TYPE:CCARD": true,
+	"!TYPE:CASH":  true,
+	"!TYPE:OTH A": true,
+	"!TYPE:OTH L": true,
+}
+
+// ParseQIF reads the bank, credit card and cash sections of a QIF file, along
+// with the category list, reading amounts in the currency. dayFirst selects
+// D/M/Y dates over the default M/D/Y.
+// Category names keep their Parent:Child form; transfers (L[Account]) have no
+// category. A batch of rows goes into one account, so a file with
+// transactions under more than one !Account header, as WriteQIF writes for
+// several accounts, is refused.
+func ParseQIF(r io.Reader, dayFirst bool, currency string) ([]*Row, []string, error) {
+	raw, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := Decode(raw, "utf-8")
+
+	if err != nil {
+		data = decodeSingleByte(raw, true)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+
+	rows := []*Row{}
+	categories := []string{}
+	section := ""
+	line := 0
+	record := map[byte]string{}
+	var splits []*RowSplit
+	var split *RowSplit
+	var splitErr error
+	recordLine := 0
+	account := ""
+	rowsAccount := ""
+	hasRows := false
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+
+		if text == "" {
+			continue
+		}
+
+		if text[0] == '!' {
+			header := strings.ToUpper(strings.TrimSpace(text))
+
+			if strings.HasPrefix(header, "!TYPE:") || header == "!ACCOUNT" {
+				section = header
+			}
+
+			record = map[byte]string{}
+			splits = nil
+			split = nil
+			splitErr = nil
+			recordLine = 0
+			continue
+		}
+
+		if recordLine == 0 {
+			recordLine = line
+		}
+
+		code, value := text[0], strings.TrimSpace(text[1:])
+
+		switch {
+		case code == '^':
+			switch {
+			case section == "!TYPE:CAT" && record['N'] != "":
+				categories = append(categories, record['N'])
+			case section == "!ACCOUNT":
+				account = record['N']
+			case qifSections[section]:
+				if hasRows && account != rowsAccount {
+					return nil, nil, fmt.Errorf("line %d: the file has transactions for accounts %q and %q; import each account separately",
+						recordLine, rowsAccount, account)
+				}
+
+				hasRows, rowsAccount = true, account
+
+				row := qifRow(recordLine, record, splits, dayFirst, currency)
+				if row.Err == nil {
+					row.Err = splitErr
+				}
+				rows = append(rows, row)
+			}
+
+			record = map[byte]string{}
+			splits = nil
+			split = nil
+			splitErr = nil
+			recordLine = 0
+		case code == 'S':
+			split = &RowSplit{Category: qifCategory(value)}
+			splits = append(splits, split)
+		case code == 'E' && split != nil:
+			split.Memo = value
+		case code == '$' && split != nil:
+			amount, err := models.ParseMoney(value, currency, ".")
+
+			// The row reports the first bad split; later ones don't hide it.
+			if err != nil && splitErr == nil {
+				splitErr = err
+			}
+
+			split.Amount = amount
+		default:
+			record[code] = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return rows, categories, nil
+}
+
+func qifRow(line int, record map[byte]string, splits []*RowSplit, dayFirst bool, currency string) *Row {
+	row := &Row{
+		Line:        line,
+		Vendor:      record['P'],
+		Description: record['M'],
+		Category:    qifCategory(record['L']),
+		Splits:      splits,
+	}
+
+	if row.Vendor == "" {
+		row.Vendor = row.Description
+	}
+
+	date, err := parseQIFDate(record['D'], dayFirst)
+
+	if err != nil {
+		row.Err = err
+		return row
+	}
+
+	row.Date = date
+
+	amount := record['T']
+	if amount == "" {
+		amount = record['U']
+	}
+
+	row.Amount, err = models.ParseMoney(amount, currency, ".")
+
+	if err != nil {
+		row.Err = err
+		return row
+	}
+
+	row.Type = typeForAmount(row.Amount)
+
+	return row
+}
+
+// qifCategory strips the class (after "/") from a category and drops
+// transfer targets, which QIF writes as [Account].
+func qifCategory(value string) string {
+	if strings.HasPrefix(value, "[") {
+		return ""
+	}
+
+	category, _, _ := strings.Cut(value, "/")
+
+	return strings.TrimSpace(category)
+}
+
+// parseQIFDate reads the many date styles found in QIF files: 5/12/2023,
+// 05/12'23, 5-12-23, 2023-05-12. Two digit years are taken as 19xx from 70 on
+// and 20xx otherwise.
+func parseQIFDate(s string, dayFirst bool) (time.Time, error) {
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '/' || r == '\'' || r == '-' || r == '.' || r == ' '
+	})
+
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+
+	numbers := [3]int{}
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %q", s)
+		}
+
+		numbers[i] = n
+	}
+
+	var year, month, day int
+
+	switch {
+	case len(parts[0]) == 4:
+		year, month, day = numbers[0], numbers[1], numbers[2]
+	case dayFirst:
+		day, month, year = numbers[0], numbers[1], numbers[2]
+	default:
+		month, day, year = numbers[0], numbers[1], numbers[2]
+	}
+
+	if len(parts[2]) <= 2 && len(parts[0]) != 4 {
+		year += 2000
+		if year >= 2070 {
+			year -= 100
+		}
+	}
+
+	date := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+
+	if date.Month() != time.Month(month) || date.Day() != day {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+
+	return date, nil
+}
+
+// WriteQIF writes the categories and accounts as a QIF file that desktop
+// finance tools can import. Dates are written as MM/DD/YYYY.
+func WriteQIF(w io.Writer, categories []string, accounts []*QIFAccount) error {
+	out := bufio.NewWriter(w)
+
+	if len(categories) > 0 {
+		fmt.Fprintln(out, "!Type:Cat")
+
+		for _, category := range categories {
+			fmt.Fprintf(out, "N%s\nE\n^\n", qifValue(category))
+		}
+	}
+
+	for _, account := range accounts {
+		if account.Name != "" {
+			fmt.Fprintf(out, "!Option:AutoSwitch\n!Account\nN%s\nT%s\n^\n!Clear:AutoSwitch\n", qifValue(account.Name), account.Kind)
+		}
+
+		fmt.Fprintf(out, "!Type:%s\n", account.Kind)
+
+		for _, row := range account.Rows {
+			fmt.Fprintf(out, "D%s\n", row.Date.Format("01/02/2006"))
+			fmt.Fprintf(out, "T%s\n", row.Amount)
+
+			if row.Vendor != "" {
+				fmt.Fprintf(out, "P%s\n", qifValue(row.Vendor))
+			}
+
+			if row.Description != "" {
+				fmt.Fprintf(out, "M%s\n", qifValue(row.Description))
+			}
+
+			if row.Category != "" {
+				fmt.Fprintf(out, "L%s\n", qifValue(row.Category))
+			}
+
+			for _, split := range row.Splits {
+				fmt.Fprintf(out, "S%s\n", qifValue(split.Category))
+
+				if split.Memo != "" {
+					fmt.Fprintf(out, "E%s\n", qifValue(split.Memo))
+				}
+
+				fmt.Fprintf(out, "$%s\n", split.Amount)
+			}
+
+			fmt.Fprintln(out, "^")
+		}
+	}
+
+	return out.Flush()
+}
+
+// qifValue keeps a value on a single line, since QIF fields are line based.
+func qifValue(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
@@ -0,0 +1,118 @@
+package importer
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexgaudon/budgie/models"
+)
+
+func TestParseQIFKeepsFirstSplitError(t *testing.T) {
+	data := `!Type:Bank
+D01/05/2024
+T-30.00
+PGrocer
+SFood
+$-x
+SHousehold
+$-10.00
+^
+`
+
+	rows, _, err := ParseQIF(strings.NewReader(data), false, "CAD")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 1 {
+		t.Fatalf("got %d rows, want 1", len(rows))
+	}
+
+	if rows[0].Err == nil {
+		t.Errorf("row with a bad split amount has no error")
+	}
+}
+
+func TestParseQIFRejectsSeveralAccounts(t *testing.T) {
+	var buf bytes.Buffer
+	date := time.Date(2024, time.January, 5, 0, 0, 0, 0, time.UTC)
+
+	err := WriteQIF(&buf, nil, []*QIFAccount{
+		{Name: "Chequing", Kind: "Bank", Rows: []*Row{{Date: date, Amount: cad(-1250), Vendor: "Cafe"}}},
+		{Name: "Visa", Kind: "CCard", Rows: []*Row{{Date: date, Amount: cad(-4000), Vendor: "Store"}}},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := ParseQIF(bytes.NewReader(buf.Bytes()), false, "CAD"); err == nil {
+		t.Errorf("ParseQIF of a two-account file succeeded")
+	}
+}
+
+func TestParseQIFOneAccount(t *testing.T) {
+	var buf bytes.Buffer
+	date := time.Date(2024, time.January, 5, 0, 0, 0, 0, time.UTC)
+
+	err := WriteQIF(&buf, nil, []*QIFAccount{
+		{Name: "Chequing", Kind: "Bank", Rows: []*Row{
+			{Date: date, Amount: cad(-1250), Vendor: "Cafe"},
+			{Date: date, Amount: cad(200000), Vendor: "Employer"},
+		}},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rows, _, err := ParseQIF(bytes.NewReader(buf.Bytes()), false, "CAD")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 2 || rows[0].Amount != cad(-1250) || rows[1].Amount != cad(200000) {
+		t.Errorf("rows = %+v, %+v", rows[0], rows[1])
+	}
+}
+
+func TestParseQIFThreeDecimals(t *testing.T) {
+	data := `!Type:Bank
+D01/05/2024
+T-1,250.125
+PGrocer
+SFood
+$-1,000.100
+SHousehold
+$-250.025
+^
+`
+
+	rows, _, err := ParseQIF(strings.NewReader(data), false, "KWD")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row := rows[0]
+
+	if row.Err != nil {
+		t.Fatal(row.Err)
+	}
+
+	if want := models.NewMoney(-1250125, "KWD"); row.Amount != want {
+		t.Errorf("amount = %s %s, want %s %s", row.Amount, row.Amount.Currency, want, want.Currency)
+	}
+
+	if row.Splits[0].Amount != models.NewMoney(-1000100, "KWD") || row.Splits[1].Amount != models.NewMoney(-250025, "KWD") {
+		t.Errorf("splits = %s, %s", row.Splits[0].Amount, row.Splits[1].Amount)
+	}
+}
+
+func cad(minor int64) models.Money {
+	return models.NewMoney(minor, "CAD")
+}
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS ledgers (
+    id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    userid UUID REFERENCES users(id) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    archived BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    deleted_at TIMESTAMP
+);
+
+INSERT INTO ledgers (userid, name) SELECT id, 'Default' FROM users;
+
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS ledger UUID REFERENCES ledgers(id);
+ALTER TABLE budgets ADD COLUMN IF NOT EXISTS ledger UUID REFERENCES ledgers(id);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS ledger UUID REFERENCES ledgers(id);
+
+UPDATE categories SET ledger = ledgers.id FROM ledgers WHERE ledgers.userid = categories.userid;
+UPDATE budgets SET ledger = ledgers.id FROM ledgers WHERE ledgers.userid = budgets.userid;
+UPDATE transactions SET ledger = ledgers.id FROM ledgers WHERE ledgers.userid = transactions.userid;
+
+ALTER TABLE categories ALTER COLUMN ledger SET NOT NULL;
+ALTER TABLE budgets ALTER COLUMN ledger SET NOT NULL;
+ALTER TABLE transactions ALTER COLUMN ledger SET NOT NULL;
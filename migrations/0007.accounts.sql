@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS accounts (
+    id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    userid UUID REFERENCES users(id) NOT NULL,
+    ledger UUID REFERENCES ledgers(id) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    kind VARCHAR(32) NOT NULL,
+    opening_balance INTEGER NOT NULL DEFAULT 0,
+    currency VARCHAR(3) NOT NULL DEFAULT 'CAD',
+    archived BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    deleted_at TIMESTAMP
+);
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS account UUID REFERENCES accounts(id);
@@ -0,0 +1,4 @@
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS transfer UUID;
+ALTER TABLE transactions ALTER COLUMN category DROP NOT NULL;
+
+CREATE INDEX IF NOT EXISTS transactions_transfer_idx ON transactions (transfer);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS transaction_splits (
+    id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    transaction UUID REFERENCES transactions(id) NOT NULL,
+    category UUID REFERENCES categories(id) NOT NULL,
+    amount INTEGER NOT NULL,
+    memo VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
+);
+
+CREATE INDEX IF NOT EXISTS transaction_splits_transaction_idx ON transaction_splits (transaction);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS recurring (
+    id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    userid UUID REFERENCES users(id) NOT NULL,
+    ledger UUID REFERENCES ledgers(id) NOT NULL,
+    account UUID REFERENCES accounts(id),
+    category UUID REFERENCES categories(id) NOT NULL,
+    amount INTEGER NOT NULL,
+    description VARCHAR(255),
+    vendor VARCHAR(255) NOT NULL,
+    type VARCHAR(255) NOT NULL,
+    rule VARCHAR(255) NOT NULL,
+    start_date TIMESTAMP NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    deleted_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS recurring_occurrences (
+    recurring UUID REFERENCES recurring(id) NOT NULL,
+    occurrence TIMESTAMP NOT NULL,
+    transaction UUID REFERENCES transactions(id),
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    PRIMARY KEY (recurring, occurrence)
+);
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS import_profiles (
+    id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    userid UUID REFERENCES users(id) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    has_header BOOLEAN NOT NULL DEFAULT TRUE,
+    delimiter VARCHAR(1) NOT NULL DEFAULT ',',
+    encoding VARCHAR(32) NOT NULL DEFAULT 'utf-8',
+    date_column VARCHAR(255) NOT NULL,
+    date_format VARCHAR(64) NOT NULL DEFAULT 'YYYY-MM-DD',
+    amount_column VARCHAR(255),
+    debit_column VARCHAR(255),
+    credit_column VARCHAR(255),
+    sign_convention VARCHAR(32) NOT NULL DEFAULT 'negative_is_expense',
+    decimal_separator VARCHAR(1) NOT NULL DEFAULT '.',
+    vendor_column VARCHAR(255) NOT NULL,
+    description_column VARCHAR(255),
+    category_column VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    deleted_at TIMESTAMP
+);
@@ -0,0 +1,3 @@
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS external_id VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS transactions_external_id_idx ON transactions (ledger, external_id) WHERE external_id IS NOT NULL;
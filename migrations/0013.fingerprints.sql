@@ -0,0 +1,9 @@
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS fingerprint VARCHAR(64);
+
+-- Entries imported with a bank identifier get the same fingerprint the
+-- importer computes for them: the hex SHA-256 of 'ext:' and the identifier.
+UPDATE transactions
+SET fingerprint = encode(sha256(convert_to('ext:' || external_id, 'UTF8')), 'hex')
+WHERE external_id IS NOT NULL AND fingerprint IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS transactions_fingerprint_idx ON transactions (ledger, COALESCE(account::text, ''), fingerprint) WHERE fingerprint IS NOT NULL AND deleted_at IS NULL;
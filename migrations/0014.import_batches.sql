@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS import_batches (
+    id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    userid UUID REFERENCES users(id) NOT NULL,
+    ledger UUID REFERENCES ledgers(id) NOT NULL,
+    account UUID REFERENCES accounts(id),
+    format VARCHAR(32) NOT NULL,
+    filename VARCHAR(255) NOT NULL DEFAULT '',
+    status VARCHAR(32) NOT NULL DEFAULT 'staged',
+    committed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    deleted_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS import_rows (
+    id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    batch UUID REFERENCES import_batches(id) ON DELETE CASCADE NOT NULL,
+    line INTEGER NOT NULL,
+    date DATE NOT NULL,
+    amount INTEGER NOT NULL DEFAULT 0,
+    type VARCHAR(32) NOT NULL DEFAULT '',
+    vendor VARCHAR(255) NOT NULL DEFAULT '',
+    description VARCHAR(255),
+    category UUID REFERENCES categories(id),
+    external_id VARCHAR(255),
+    fingerprint VARCHAR(64),
+    splits JSONB NOT NULL DEFAULT '[]',
+    error TEXT,
+    excluded BOOLEAN NOT NULL DEFAULT FALSE,
+    duplicate BOOLEAN NOT NULL DEFAULT FALSE,
+    possible_duplicates UUID[] NOT NULL DEFAULT '{}',
+    transaction UUID REFERENCES transactions(id),
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC')
+);
+
+CREATE INDEX IF NOT EXISTS import_rows_batch_idx ON import_rows (batch, line);
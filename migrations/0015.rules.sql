@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS rules (
+    id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    userid UUID REFERENCES users(id) NOT NULL,
+    ledger UUID REFERENCES ledgers(id) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    priority INTEGER NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    vendor_match VARCHAR(32) NOT NULL DEFAULT '',
+    vendor_pattern VARCHAR(255) NOT NULL DEFAULT '',
+    description_match VARCHAR(32) NOT NULL DEFAULT '',
+    description_pattern VARCHAR(255) NOT NULL DEFAULT '',
+    amount_min INTEGER,
+    amount_max INTEGER,
+    account UUID REFERENCES accounts(id),
+    type VARCHAR(255),
+    set_category UUID REFERENCES categories(id),
+    rename_vendor VARCHAR(255),
+    add_tags TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    deleted_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS rules_ledger_idx ON rules (ledger, priority) WHERE deleted_at IS NULL;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS payees (
+    id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    userid UUID REFERENCES users(id) NOT NULL,
+    ledger UUID REFERENCES ledgers(id) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    aliases TEXT[] NOT NULL DEFAULT '{}',
+    patterns TEXT[] NOT NULL DEFAULT '{}',
+    default_category UUID REFERENCES categories(id),
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    deleted_at TIMESTAMP
+);
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS payee UUID REFERENCES payees(id);
+ALTER TABLE import_rows ADD COLUMN IF NOT EXISTS payee UUID REFERENCES payees(id);
+
+CREATE INDEX IF NOT EXISTS transactions_payee_idx ON transactions (payee) WHERE payee IS NOT NULL;
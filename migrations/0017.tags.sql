@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS tags (
+    id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    userid UUID REFERENCES users(id) NOT NULL,
+    ledger UUID REFERENCES ledgers(id) NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    deleted_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS tags_ledger_name_idx ON tags (ledger, lower(name)) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS transaction_tags (
+    transaction UUID REFERENCES transactions(id) ON DELETE CASCADE NOT NULL,
+    tag UUID REFERENCES tags(id) ON DELETE CASCADE NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    PRIMARY KEY (transaction, tag)
+);
+
+CREATE INDEX IF NOT EXISTS transaction_tags_tag_idx ON transaction_tags (tag);
+
+ALTER TABLE import_rows ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,41 @@
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS search TSVECTOR;
+
+-- Vendors weigh most in search ranking, then descriptions, then category
+-- names.
+CREATE OR REPLACE FUNCTION transaction_search_vector(vendor TEXT, description TEXT, category UUID) RETURNS TSVECTOR AS $$
+    SELECT setweight(to_tsvector('english', COALESCE($1, '')), 'A') ||
+        setweight(to_tsvector('english', COALESCE($2, '')), 'B') ||
+        setweight(to_tsvector('english', COALESCE((SELECT name FROM categories WHERE id = $3), '')), 'C');
+$$ LANGUAGE SQL STABLE;
+
+CREATE OR REPLACE FUNCTION transactions_search_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search := transaction_search_vector(NEW.vendor, NEW.description, NEW.category);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS transactions_search_update ON transactions;
+CREATE TRIGGER transactions_search_update
+    BEFORE INSERT OR UPDATE OF vendor, description, category ON transactions
+    FOR EACH ROW EXECUTE FUNCTION transactions_search_trigger();
+
+-- Renaming a category changes what its transactions are found by.
+CREATE OR REPLACE FUNCTION categories_search_trigger() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE transactions
+    SET search = transaction_search_vector(vendor, description, category)
+    WHERE category = NEW.id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS categories_search_update ON categories;
+CREATE TRIGGER categories_search_update
+    AFTER UPDATE OF name ON categories
+    FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name)
+    EXECUTE FUNCTION categories_search_trigger();
+
+UPDATE transactions SET search = transaction_search_vector(vendor, description, category);
+
+CREATE INDEX IF NOT EXISTS transactions_search_idx ON transactions USING GIN (search);
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS reconciliations (
+    id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    userid UUID REFERENCES users(id) NOT NULL,
+    ledger UUID REFERENCES ledgers(id) NOT NULL,
+    account UUID REFERENCES accounts(id) NOT NULL,
+    statement_date TIMESTAMP NOT NULL,
+    ending_balance INTEGER NOT NULL,
+    status VARCHAR(32) NOT NULL DEFAULT 'open',
+    finalized_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    deleted_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS reconciliations_open_idx ON reconciliations (account) WHERE status = 'open' AND deleted_at IS NULL;
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'pending'
+    CHECK (status IN ('pending', 'cleared', 'reconciled'));
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reconciliation UUID REFERENCES reconciliations(id);
+
+CREATE INDEX IF NOT EXISTS transactions_account_status_idx ON transactions (account, status) WHERE deleted_at IS NULL;
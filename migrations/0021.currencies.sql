@@ -0,0 +1,69 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS base_currency VARCHAR(3) NOT NULL DEFAULT 'CAD';
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'CAD';
+
+-- Existing transactions are in the currency of their account, or the base
+-- currency of their owner when they have none.
+UPDATE transactions t
+SET currency = COALESCE(
+    (SELECT a.currency FROM accounts a WHERE a.id = t.account),
+    (SELECT u.base_currency FROM users u WHERE u.id = t.userid)
+);
+
+-- One unit of base is worth rate units of quote on the date. ECB reference
+-- rates are all quoted against the euro.
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    userid UUID REFERENCES users(id) NOT NULL,
+    base VARCHAR(3) NOT NULL,
+    quote VARCHAR(3) NOT NULL,
+    date DATE NOT NULL,
+    rate NUMERIC(20, 10) NOT NULL CHECK (rate > 0),
+    source VARCHAR(16) NOT NULL DEFAULT 'manual',
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    UNIQUE (userid, base, quote, date)
+);
+
+CREATE INDEX IF NOT EXISTS exchange_rates_quote_idx ON exchange_rates (userid, quote, date DESC);
+
+-- exchange_rate returns how many units of the second currency one unit of the
+-- first is worth on the date, using the latest of the user's rates on or
+-- before it. A direct rate or its inverse is preferred; failing that, a cross
+-- rate through a currency both are quoted against, such as the euro.
+CREATE OR REPLACE FUNCTION exchange_rate(UUID, VARCHAR, VARCHAR, TIMESTAMP) RETURNS NUMERIC AS $$
+DECLARE
+    result NUMERIC;
+BEGIN
+    IF $2 = $3 THEN
+        RETURN 1;
+    END IF;
+
+    SELECT CASE WHEN r.base = $2 THEN r.rate ELSE 1 / r.rate END INTO result
+    FROM exchange_rates r
+    WHERE r.userid = $1
+    AND ((r.base = $2 AND r.quote = $3) OR (r.base = $3 AND r.quote = $2))
+    AND r.date <= $4
+    ORDER BY r.date DESC
+    LIMIT 1;
+
+    IF result IS NULL THEN
+        SELECT t.rate / f.rate INTO result
+        FROM exchange_rates f
+        JOIN exchange_rates t
+        ON t.userid = f.userid AND t.base = f.base AND t.date = f.date
+        WHERE f.userid = $1
+        AND f.quote = $2
+        AND t.quote = $3
+        AND f.date <= $4
+        ORDER BY f.date DESC
+        LIMIT 1;
+    END IF;
+
+    IF result IS NULL THEN
+        RAISE EXCEPTION 'no exchange rate from % to % on or before %', $2, $3, $4::date;
+    END IF;
+
+    RETURN result;
+END;
+$$ LANGUAGE plpgsql STABLE STRICT;
@@ -0,0 +1,94 @@
+-- currency_exponent returns how many decimals the currency's minor unit has,
+-- matching models.CurrencyExponent.
+CREATE OR REPLACE FUNCTION currency_exponent(VARCHAR) RETURNS INTEGER AS $$
+    SELECT CASE
+        WHEN $1 IN ('BIF', 'CLP', 'DJF', 'GNF', 'ISK', 'JPY', 'KMF', 'KRW', 'PYG',
+            'RWF', 'UGX', 'UYI', 'VND', 'VUV', 'XAF', 'XOF', 'XPF') THEN 0
+        WHEN $1 IN ('BHD', 'IQD', 'JOD', 'KWD', 'LYD', 'OMR', 'TND') THEN 3
+        ELSE 2
+    END
+$$ LANGUAGE sql IMMUTABLE STRICT;
+
+-- convert_amount converts minor units of one currency into minor units of
+-- another at the rate on the date, rounding half away from zero.
+CREATE OR REPLACE FUNCTION convert_amount(UUID, BIGINT, VARCHAR, VARCHAR, TIMESTAMP) RETURNS BIGINT AS $$
+    SELECT CASE WHEN $3 = $4 THEN $2 ELSE ROUND(
+        $2 * exchange_rate($1, $3, $4, $5) * power(10::numeric, currency_exponent($4) - currency_exponent($3))
+    )::bigint END
+$$ LANGUAGE sql STABLE STRICT;
+
+ALTER TABLE transactions ALTER COLUMN amount TYPE BIGINT;
+ALTER TABLE transaction_splits ALTER COLUMN amount TYPE BIGINT;
+ALTER TABLE accounts ALTER COLUMN opening_balance TYPE BIGINT;
+ALTER TABLE budgets ALTER COLUMN amount TYPE BIGINT;
+ALTER TABLE recurring ALTER COLUMN amount TYPE BIGINT;
+ALTER TABLE import_rows ALTER COLUMN amount TYPE BIGINT;
+ALTER TABLE reconciliations ALTER COLUMN ending_balance TYPE BIGINT;
+ALTER TABLE rules ALTER COLUMN amount_min TYPE BIGINT;
+ALTER TABLE rules ALTER COLUMN amount_max TYPE BIGINT;
+
+-- Budgets are in the owner's base currency and recurring transactions and
+-- import batches in their account's, as transactions are.
+ALTER TABLE budgets ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'CAD';
+ALTER TABLE recurring ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'CAD';
+ALTER TABLE import_batches ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'CAD';
+
+UPDATE budgets b
+SET currency = u.base_currency
+FROM users u
+WHERE u.id = b.userid;
+
+UPDATE recurring r
+SET currency = COALESCE(
+    (SELECT a.currency FROM accounts a WHERE a.id = r.account),
+    (SELECT u.base_currency FROM users u WHERE u.id = r.userid)
+);
+
+UPDATE import_batches b
+SET currency = COALESCE(
+    (SELECT a.currency FROM accounts a WHERE a.id = b.account),
+    (SELECT u.base_currency FROM users u WHERE u.id = b.userid)
+);
+
+-- Amounts used to be hundredths whatever the currency. Those in currencies
+-- whose minor unit isn't a hundredth are rescaled, rounding away any cents a
+-- zero-decimal currency can't hold.
+UPDATE transaction_splits s
+SET amount = ROUND(s.amount * power(10::numeric, currency_exponent(t.currency) - 2))
+FROM transactions t
+WHERE t.id = s.transaction
+AND currency_exponent(t.currency) <> 2;
+
+UPDATE transactions
+SET amount = ROUND(amount * power(10::numeric, currency_exponent(currency) - 2))
+WHERE currency_exponent(currency) <> 2;
+
+UPDATE accounts
+SET opening_balance = ROUND(opening_balance * power(10::numeric, currency_exponent(currency) - 2))
+WHERE currency_exponent(currency) <> 2;
+
+UPDATE reconciliations r
+SET ending_balance = ROUND(r.ending_balance * power(10::numeric, currency_exponent(a.currency) - 2))
+FROM accounts a
+WHERE a.id = r.account
+AND currency_exponent(a.currency) <> 2;
+
+UPDATE budgets
+SET amount = ROUND(amount * power(10::numeric, currency_exponent(currency) - 2))
+WHERE currency_exponent(currency) <> 2;
+
+UPDATE recurring
+SET amount = ROUND(amount * power(10::numeric, currency_exponent(currency) - 2))
+WHERE currency_exponent(currency) <> 2;
+
+UPDATE import_rows r
+SET amount = ROUND(r.amount * power(10::numeric, currency_exponent(b.currency) - 2)),
+    splits = COALESCE((
+        SELECT jsonb_agg(jsonb_set(s.split, '{amount}', to_jsonb(
+            ROUND((s.split->>'amount')::numeric * power(10::numeric, currency_exponent(b.currency) - 2))::bigint
+        )) ORDER BY s.position)
+        FROM jsonb_array_elements(r.splits) WITH ORDINALITY AS s (split, position)
+    ), '[]')
+FROM import_batches b
+WHERE b.id = r.batch
+AND currency_exponent(b.currency) <> 2;
@@ -0,0 +1,49 @@
+-- Types used to be free text. Balances counted anything that wasn't income or
+-- a transfer as an expense, so unknown types become expenses.
+UPDATE transactions SET type = lower(trim(type)) WHERE type <> lower(trim(type));
+UPDATE transactions SET type = 'expense'
+WHERE type NOT IN ('expense', 'income', 'refund', 'transfer', 'adjustment');
+
+UPDATE recurring SET type = lower(trim(type)) WHERE type <> lower(trim(type));
+UPDATE recurring SET type = 'expense'
+WHERE type NOT IN ('expense', 'income', 'refund', 'adjustment');
+
+UPDATE rules SET type = lower(trim(type)) WHERE type <> lower(trim(type));
+UPDATE rules SET type = 'expense'
+WHERE type NOT IN ('expense', 'income', 'refund', 'adjustment');
+
+UPDATE import_rows SET type = 'expense'
+WHERE type NOT IN ('', 'expense', 'income', 'refund', 'adjustment');
+
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_type_check;
+ALTER TABLE transactions ADD CONSTRAINT transactions_type_check
+    CHECK (type IN ('expense', 'income', 'refund', 'transfer', 'adjustment'));
+
+ALTER TABLE recurring DROP CONSTRAINT IF EXISTS recurring_type_check;
+ALTER TABLE recurring ADD CONSTRAINT recurring_type_check
+    CHECK (type IN ('expense', 'income', 'refund', 'adjustment'));
+
+ALTER TABLE rules DROP CONSTRAINT IF EXISTS rules_type_check;
+ALTER TABLE rules ADD CONSTRAINT rules_type_check
+    CHECK (type IN ('expense', 'income', 'refund', 'adjustment'));
+
+-- Rows that failed to parse have no type.
+ALTER TABLE import_rows DROP CONSTRAINT IF EXISTS import_rows_type_check;
+ALTER TABLE import_rows ADD CONSTRAINT import_rows_type_check
+    CHECK (type IN ('', 'expense', 'income', 'refund', 'adjustment'));
+
+-- Only transfer legs and adjustments carry a sign. A negative expense was
+-- money coming back, which is now a refund, and negative income was money
+-- going out; flipping them keeps balances as they were.
+UPDATE transaction_splits s
+SET amount = -s.amount
+FROM transactions t
+WHERE t.id = s.transaction
+AND t.amount < 0
+AND t.type IN ('expense', 'income');
+
+UPDATE transactions
+SET type = CASE WHEN type = 'expense' THEN 'refund' ELSE 'expense' END,
+    amount = -amount
+WHERE amount < 0
+AND type IN ('expense', 'income');
@@ -0,0 +1,21 @@
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS reimbursable BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- A link settles part or all of an expense, the original, with a later
+-- transaction: a refund for refund_of and income for reimbursed_by. amount is
+-- in minor units of the original's currency.
+CREATE TABLE IF NOT EXISTS transaction_links (
+    id UUID PRIMARY KEY DEFAULT public.uuid_generate_v4(),
+    userid UUID REFERENCES users(id) NOT NULL,
+    ledger UUID REFERENCES ledgers(id) NOT NULL,
+    kind VARCHAR(32) NOT NULL CHECK (kind IN ('refund_of', 'reimbursed_by')),
+    original UUID REFERENCES transactions(id) NOT NULL,
+    settlement UUID REFERENCES transactions(id) NOT NULL,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    updated_at TIMESTAMP NOT NULL DEFAULT (NOW() AT TIME ZONE 'UTC'),
+    deleted_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS transaction_links_original_idx ON transaction_links (original) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS transaction_links_settlement_idx ON transaction_links (settlement) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS transactions_reimbursable_idx ON transactions (ledger) WHERE reimbursable AND deleted_at IS NULL;
@@ -0,0 +1,10 @@
+-- Rule amount bounds are in the minor units of the rule's currency: its
+-- account's, or the owner's base currency for rules without an account.
+-- They only match transactions in that currency.
+ALTER TABLE rules ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'CAD';
+
+UPDATE rules r
+SET currency = COALESCE(
+    (SELECT a.currency FROM accounts a WHERE a.id = r.account),
+    (SELECT u.base_currency FROM users u WHERE u.id = r.userid)
+);
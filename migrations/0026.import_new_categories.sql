@@ -0,0 +1,4 @@
+-- Categories an import file names that the ledger doesn't have are created
+-- when the batch is committed. Until then their rows have no category and
+-- keep the name here.
+ALTER TABLE import_rows ADD COLUMN IF NOT EXISTS new_category VARCHAR(255) NOT NULL DEFAULT '';
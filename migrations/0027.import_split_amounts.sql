@@ -0,0 +1,17 @@
+-- Amounts in JSON are decimal strings. Staged import splits still hold
+-- whole minor units from before, which are rewritten as decimals in their
+-- batch's currency.
+UPDATE import_rows r
+SET splits = (
+    SELECT jsonb_agg(CASE WHEN jsonb_typeof(s.split->'amount') = 'number'
+        THEN jsonb_set(s.split, '{amount}', to_jsonb(ROUND(
+            (s.split->>'amount')::numeric / power(10::numeric, currency_exponent(b.currency)),
+            currency_exponent(b.currency)
+        )::text))
+        ELSE s.split
+    END ORDER BY s.position)
+    FROM jsonb_array_elements(r.splits) WITH ORDINALITY AS s (split, position)
+)
+FROM import_batches b
+WHERE b.id = r.batch
+AND jsonb_array_length(r.splits) > 0;
@@ -0,0 +1,212 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+var AccountKinds = map[string]bool{
+	"checking": true,
+	"savings":  true,
+	"credit":   true,
+	"cash":     true,
+}
+
+type AccountsRepo struct {
+	DB *sql.DB
+}
+
+func (r *AccountsRepo) Find(userId, ledgerId string) ([]*Account, error) {
+	query := `SELECT id, userid, ledger, name, kind, opening_balance, currency, archived, created_at, updated_at, deleted_at
+FROM accounts
+WHERE userid = $1 AND ledger = $2 AND deleted_at IS NULL
+ORDER BY name ASC`
+
+	rows, err := r.DB.Query(query, userId, ledgerId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []*Account{}
+
+	for rows.Next() {
+		account, err := scanIntoAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	rows.Close()
+
+	return accounts, nil
+}
+
+func (r *AccountsRepo) FindOne(a *Account) (*Account, error) {
+	query := `SELECT id, userid, ledger, name, kind, opening_balance, currency, archived, created_at, updated_at, deleted_at
+FROM accounts
+WHERE id = $1 AND deleted_at IS NULL`
+
+	if a.ID == "" {
+		return nil, fmt.Errorf("you must provide an id")
+	}
+
+	row := r.DB.QueryRow(query, a.ID)
+
+	account := &Account{}
+
+	err := row.Scan(
+		&account.ID,
+		&account.UserID,
+		&account.LedgerID,
+		&account.Name,
+		&account.Kind,
+		&account.OpeningBalance,
+		&account.Currency,
+		&account.Archived,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+		&account.DeletedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("account with id not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	account.OpeningBalance.Currency = account.Currency
+
+	return account, nil
+}
+
+func (r *AccountsRepo) Exists(a *Account) bool {
+	f, err := r.FindOne(a)
+
+	if err != nil {
+		return false
+	}
+
+	return f != nil && f.ID != ""
+}
+
+func (r *AccountsRepo) Save(a *Account) (*Account, error) {
+	if !AccountKinds[a.Kind] {
+		return nil, fmt.Errorf("invalid account kind: %s", a.Kind)
+	}
+
+	if a.ID != "" && r.Exists(a) {
+		return r.update(a)
+	}
+	return r.create(a)
+}
+
+func (r *AccountsRepo) Delete(id string) error {
+	query := `UPDATE accounts SET deleted_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $1`
+
+	_, err := r.DB.Exec(query, id)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Balance returns the opening balance of the account plus every income and
+// refund and minus every expense dated on or before asOf, in the account's
+// currency. Transfer legs and adjustments carry their sign in the amount, so
+// they are added as is.
+// Transactions in another currency are converted at the rate on their date.
+func (r *AccountsRepo) Balance(id string, asOf time.Time) (Money, error) {
+	query := `SELECT a.currency, a.opening_balance + COALESCE(SUM(convert_amount(
+	a.userid,
+	` + signedAmount + `,
+	t.currency,
+	a.currency,
+	t.date
+)), 0)::bigint
+FROM accounts a
+LEFT JOIN transactions t
+ON t.account = a.id
+AND t.deleted_at IS NULL
+AND t.date <= $2
+WHERE a.id = $1
+GROUP BY a.currency, a.opening_balance`
+
+	var balance Money
+
+	err := r.DB.QueryRow(query, id, asOf).Scan(&balance.Currency, &balance.Minor)
+
+	if err == sql.ErrNoRows {
+		return Money{}, fmt.Errorf("account with id not found")
+	}
+
+	if err != nil {
+		return Money{}, err
+	}
+
+	return balance, nil
+}
+
+func (r *AccountsRepo) create(a *Account) (*Account, error) {
+	query := `INSERT INTO accounts (userid, ledger, name, kind, opening_balance, currency, archived)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at, updated_at, deleted_at`
+
+	row := r.DB.QueryRow(query, a.UserID, a.LedgerID, a.Name, a.Kind, a.OpeningBalance, a.Currency, a.Archived)
+
+	err := row.Scan(&a.ID, &a.CreatedAt, &a.UpdatedAt, &a.DeletedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (r *AccountsRepo) update(a *Account) (*Account, error) {
+	query := `UPDATE accounts SET
+	name = $1,
+	kind = $2,
+	opening_balance = $3,
+	currency = $4,
+	archived = $5,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = $6`
+
+	_, err := r.DB.Exec(query, a.Name, a.Kind, a.OpeningBalance, a.Currency, a.Archived, a.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	a.UpdatedAt = time.Now().UTC()
+
+	return a, nil
+}
+
+func scanIntoAccount(rows *sql.Rows) (*Account, error) {
+	a := &Account{}
+
+	err := rows.Scan(
+		&a.ID,
+		&a.UserID,
+		&a.LedgerID,
+		&a.Name,
+		&a.Kind,
+		&a.OpeningBalance,
+		&a.Currency,
+		&a.Archived,
+		&a.CreatedAt,
+		&a.UpdatedAt,
+		&a.DeletedAt,
+	)
+
+	a.OpeningBalance.Currency = a.Currency
+
+	return a, err
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+type AttachmentsRepo struct {
+	DB *sql.DB
+}
+
+const attachmentSelect = `
+SELECT id,
+	userid,
+	ledger,
+	transaction,
+	filename,
+	content_type,
+	size,
+	sha256,
+	storage_key,
+	thumbnail_key,
+	created_at,
+	updated_at,
+	deleted_at
+FROM attachments`
+
+// Find returns the attachments of a transaction, oldest first.
+func (r *AttachmentsRepo) Find(transactionId string) ([]*Attachment, error) {
+	query := attachmentSelect + `
+WHERE deleted_at IS NULL
+AND transaction = $1
+ORDER BY created_at ASC`
+
+	rows, err := r.DB.Query(query, transactionId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	attachments := []*Attachment{}
+
+	for rows.Next() {
+		attachment, err := scanIntoAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	rows.Close()
+
+	return attachments, nil
+}
+
+// FindOne returns an attachment, unless it or its transaction was deleted.
+func (r *AttachmentsRepo) FindOne(a *Attachment) (*Attachment, error) {
+	query := attachmentSelect + `
+WHERE deleted_at IS NULL
+AND id = $1
+AND EXISTS (SELECT 1 FROM transactions t WHERE t.id = attachments.transaction AND t.deleted_at IS NULL)`
+
+	if a.ID == "" {
+		return nil, fmt.Errorf("you must provide an id")
+	}
+
+	attachment, err := scanIntoAttachment(r.DB.QueryRow(query, a.ID))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("attachment with id not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+// Create records an attachment whose content is already in the blob store.
+func (r *AttachmentsRepo) Create(a *Attachment) (*Attachment, error) {
+	query := `INSERT INTO attachments (userid, ledger, transaction, filename, content_type, size, sha256, storage_key, thumbnail_key)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at, updated_at, deleted_at`
+
+	err := r.DB.QueryRow(query, a.UserID, a.LedgerID, a.TransactionID, a.Filename, a.ContentType, a.Size, a.SHA256,
+		a.StorageKey, a.ThumbnailKey).Scan(&a.ID, &a.CreatedAt, &a.UpdatedAt, &a.DeletedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	a.HasThumbnail = a.ThumbnailKey.Valid
+
+	return a, nil
+}
+
+func (r *AttachmentsRepo) Delete(id string) error {
+	query := `UPDATE attachments SET deleted_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $1`
+
+	_, err := r.DB.Exec(query, id)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func scanIntoAttachment(row scanner) (*Attachment, error) {
+	a := &Attachment{}
+	err := row.Scan(
+		&a.ID,
+		&a.UserID,
+		&a.LedgerID,
+		&a.TransactionID,
+		&a.Filename,
+		&a.ContentType,
+		&a.Size,
+		&a.SHA256,
+		&a.StorageKey,
+		&a.ThumbnailKey,
+		&a.CreatedAt,
+		&a.UpdatedAt,
+		&a.DeletedAt,
+	)
+
+	a.HasThumbnail = a.ThumbnailKey.Valid
+
+	return a, err
+}
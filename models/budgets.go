@@ -23,13 +23,15 @@ func (r *BudgetsRepo) Filter(budgets []*Budget, pred budgetPredicateFunction) []
 	return filtered
 }
 
-func (r *BudgetsRepo) Find(userId string) ([]*Budget, error) {
+func (r *BudgetsRepo) Find(userId, ledgerId string) ([]*Budget, error) {
 	query := `SELECT
 	budgets.id,
 	budgets.userid,
+	budgets.ledger,
 	categories.name as category_name,
 	budgets.category,
 	budgets.amount,
+	budgets.currency,
 	budgets.period,
 	budgets.created_at,
 	budgets.updated_at,
@@ -37,9 +39,10 @@ func (r *BudgetsRepo) Find(userId string) ([]*Budget, error) {
 FROM budgets 
 JOIN categories ON categories.id = budgets.category 
 WHERE budgets.deleted_at IS NULL
-AND budgets.userid = $1`
+AND budgets.userid = $1
+AND budgets.ledger = $2`
 
-	rows, err := r.DB.Query(query, userId)
+	rows, err := r.DB.Query(query, userId, ledgerId)
 
 	if err != nil {
 		return nil, err
@@ -64,9 +67,11 @@ func (r *BudgetsRepo) FindOne(b *Budget) (*Budget, error) {
 	query := `SELECT
 	budgets.id,
 	budgets.userid,
+	budgets.ledger,
 	categories.name as category_name,
 	budgets.category,
 	budgets.amount,
+	budgets.currency,
 	budgets.period,
 	budgets.created_at,
 	budgets.updated_at,
@@ -87,9 +92,11 @@ AND budgets.id = $1`
 	err := row.Scan(
 		&budget.ID,
 		&budget.UserID,
+		&budget.LedgerID,
 		&budget.Category,
 		&budget.CategoryID,
 		&budget.Amount,
+		&budget.Currency,
 		&budget.Period,
 		&budget.CreatedAt,
 		&budget.UpdatedAt,
@@ -104,6 +111,8 @@ AND budgets.id = $1`
 		return nil, err
 	}
 
+	budget.Amount.Currency = budget.Currency
+
 	return budget, nil
 }
 
@@ -137,10 +146,14 @@ func (r *BudgetsRepo) Delete(id string) error {
 }
 
 func (r *BudgetsRepo) create(b *Budget) (*Budget, error) {
-	query := `INSERT INTO budgets (userid, category, amount, period)
-	VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at, deleted_at`
+	if err := b.checkCurrency(); err != nil {
+		return nil, err
+	}
 
-	row := r.DB.QueryRow(query, b.UserID, b.Category, b.Amount, b.Period)
+	query := `INSERT INTO budgets (userid, ledger, category, amount, currency, period)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at, deleted_at`
+
+	row := r.DB.QueryRow(query, b.UserID, b.LedgerID, b.Category, b.Amount, b.Currency, b.Period)
 
 	fmt.Println("values, ", b.UserID, b.Category, b.Amount, b.Period)
 
@@ -153,8 +166,28 @@ func (r *BudgetsRepo) create(b *Budget) (*Budget, error) {
 	return b, nil
 }
 
+// update saves the budget's category (by CategoryID), amount and period and
+// reloads it so the category name is current.
 func (r *BudgetsRepo) update(b *Budget) (*Budget, error) {
-	panic("NOT IMPLEMENTED")
+	if err := b.checkCurrency(); err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE budgets SET
+	category = $1,
+	amount = $2,
+	currency = $3,
+	period = $4,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = $5`
+
+	_, err := r.DB.Exec(query, b.CategoryID, b.Amount, b.Currency, b.Period, b.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.FindOne(b)
 }
 
 func scanIntoBudget(rows *sql.Rows) (*Budget, error) {
@@ -162,14 +195,30 @@ func scanIntoBudget(rows *sql.Rows) (*Budget, error) {
 	err := rows.Scan(
 		&b.ID,
 		&b.UserID,
+		&b.LedgerID,
 		&b.Category,
 		&b.CategoryID,
 		&b.Amount,
+		&b.Currency,
 		&b.Period,
 		&b.CreatedAt,
 		&b.UpdatedAt,
 		&b.DeletedAt,
 	)
 
+	b.Amount.Currency = b.Currency
+
 	return b, err
 }
+
+// checkCurrency makes sure the budget's amount is in a currency, and sets the
+// budget's currency to it.
+func (b *Budget) checkCurrency() error {
+	if b.Amount.Currency == "" {
+		return fmt.Errorf("budget amount has no currency")
+	}
+
+	b.Currency = b.Amount.Currency
+
+	return nil
+}
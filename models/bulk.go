@@ -0,0 +1,156 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// The operations a bulk request can apply.
+const (
+	BulkRecategorize = "recategorize"
+	BulkSetType      = "set_type"
+	BulkAddTags      = "add_tags"
+	BulkRemoveTags   = "remove_tags"
+	BulkSetDate      = "set_date"
+	BulkSetStatus    = "set_status"
+	BulkDelete       = "delete"
+)
+
+// Bulk applies the operations, in order, to the ledger's transactions with
+// the given ids in a single database transaction. Transfers, reconciled
+// transactions and transactions that aren't the user's are left alone, as
+// are split transactions when recategorizing since their categories live on
+// the splits. Setting the type skips transactions the new type can't hold,
+// as Transaction.checkType would refuse them: negative amounts for types that
+// take their direction from the type, and reimbursable ones for types other
+// than expense.
+func (r *TransactionsRepo) Bulk(userId, ledgerId string, ids []string, operations []*BulkOperation) (*BulkResult, error) {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	ids, err = lockTransactions(tx, userId, ledgerId, ids)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BulkResult{
+		Matched:    len(ids),
+		Operations: []*BulkOperationResult{},
+	}
+
+	for _, op := range operations {
+		var affected, skipped int64
+
+		switch op.Op {
+		case BulkRecategorize:
+			affected, err = rowsAffected(tx.Exec(`UPDATE transactions SET
+	category = $2,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = ANY($1::uuid[])
+	AND NOT EXISTS (SELECT 1 FROM transaction_splits s WHERE s.transaction = transactions.id)`,
+				pq.Array(ids), op.CategoryID))
+		case BulkSetType:
+			signed := (&Transaction{Type: op.Type}).Signed()
+			affected, err = rowsAffected(tx.Exec(`UPDATE transactions SET
+	type = $2,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = ANY($1::uuid[])
+	AND ($3 OR amount >= 0)
+	AND ($2 = 'expense' OR NOT reimbursable)`, pq.Array(ids), op.Type, signed))
+			skipped = int64(len(ids)) - affected
+		case BulkSetDate:
+			affected, err = rowsAffected(tx.Exec(`UPDATE transactions SET
+	date = $2,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = ANY($1::uuid[])`, pq.Array(ids), op.Date))
+		case BulkSetStatus:
+			affected, err = rowsAffected(tx.Exec(`UPDATE transactions SET
+	status = $2,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = ANY($1::uuid[])
+	AND status <> $2`, pq.Array(ids), op.Status))
+		case BulkAddTags:
+			affected, err = addTags(tx, userId, ledgerId, ids, op.Tags)
+		case BulkRemoveTags:
+			affected, err = removeTags(tx, ledgerId, ids, op.Tags)
+		case BulkDelete:
+			affected, err = rowsAffected(tx.Exec(`UPDATE transactions SET
+	deleted_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = ANY($1::uuid[])`, pq.Array(ids)))
+		default:
+			err = fmt.Errorf("unknown bulk operation %q", op.Op)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		result.Operations = append(result.Operations, &BulkOperationResult{
+			Op:       op.Op,
+			Affected: int(affected),
+			Skipped:  int(skipped),
+		})
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// lockTransactions narrows ids to the user's live transactions in the ledger
+// that are neither transfers nor reconciled, and locks them for the rest of
+// the database transaction.
+func lockTransactions(db dbtx, userId, ledgerId string, ids []string) ([]string, error) {
+	locked := []string{}
+
+	if len(ids) == 0 {
+		return locked, nil
+	}
+
+	rows, err := db.Query(`SELECT id FROM transactions
+	WHERE deleted_at IS NULL
+	AND userid = $1
+	AND ledger = $2
+	AND transfer IS NULL
+	AND status <> $4
+	AND id = ANY($3::uuid[])
+	FOR UPDATE`, userId, ledgerId, pq.Array(ids), StatusReconciled)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		locked = append(locked, id)
+	}
+
+	return locked, rows.Err()
+}
+
+// rowsAffected returns the number of rows changed by a statement, passing on
+// the statement's error.
+func rowsAffected(result sql.Result, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -9,10 +9,10 @@ type CategoriesRepo struct {
 	DB *sql.DB
 }
 
-func (r *CategoriesRepo) Find(userId string) ([]*Category, error) {
-	query := `SELECT id, userid, name, created_at, updated_at, deleted_at FROM categories WHERE userid = $1 AND deleted_at IS NULL`
+func (r *CategoriesRepo) Find(userId, ledgerId string) ([]*Category, error) {
+	query := `SELECT id, userid, ledger, name, created_at, updated_at, deleted_at FROM categories WHERE userid = $1 AND ledger = $2 AND deleted_at IS NULL`
 
-	rows, err := r.DB.Query(query, userId)
+	rows, err := r.DB.Query(query, userId, ledgerId)
 
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("no categories found")
@@ -37,8 +37,48 @@ func (r *CategoriesRepo) Find(userId string) ([]*Category, error) {
 	return categories, nil
 }
 
+// SpendingCategories returns the IDs of the ledger's categories that money is
+// spent from: those with a budget, and those whose transactions are mostly
+// expenses and refunds rather than income.
+func (r *CategoriesRepo) SpendingCategories(ledgerId string) (map[string]bool, error) {
+	query := `SELECT c.id FROM categories c
+WHERE c.ledger = $1
+AND c.deleted_at IS NULL
+AND (
+	EXISTS (SELECT 1 FROM budgets b WHERE b.category = c.id AND b.deleted_at IS NULL)
+	OR (
+		SELECT count(*) FILTER (WHERE t.type IN ('expense', 'refund')) > count(*) FILTER (WHERE t.type = 'income')
+		FROM transactions t
+		WHERE t.category = c.id
+		AND t.deleted_at IS NULL
+	)
+)`
+
+	rows, err := r.DB.Query(query, ledgerId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	spending := map[string]bool{}
+
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		spending[id] = true
+	}
+
+	return spending, rows.Err()
+}
+
 func (r *CategoriesRepo) FindOne(c *Category) (*Category, error) {
-	query := `SELECT id, userid, name, created_at, updated_at, deleted_at FROM categories WHERE id = $1 AND deleted_at IS NULL`
+	query := `SELECT id, userid, ledger, name, created_at, updated_at, deleted_at FROM categories WHERE id = $1 AND deleted_at IS NULL`
 
 	row := r.DB.QueryRow(query, c.ID)
 
@@ -47,6 +87,7 @@ func (r *CategoriesRepo) FindOne(c *Category) (*Category, error) {
 	err := row.Scan(
 		&category.ID,
 		&category.UserID,
+		&category.LedgerID,
 		&category.Name,
 		&category.CreatedAt,
 		&category.UpdatedAt,
@@ -75,6 +116,9 @@ func (r *CategoriesRepo) Exists(c *Category) bool {
 }
 
 func (r *CategoriesRepo) Save(c *Category) (*Category, error) {
+	if c.ID != "" && r.Exists(c) {
+		return r.update(c)
+	}
 	return r.create(c)
 }
 
@@ -91,10 +135,10 @@ func (r *CategoriesRepo) Delete(id string) error {
 }
 
 func (r *CategoriesRepo) create(c *Category) (*Category, error) {
-	query := `INSERT INTO categories (userid, name)
-	VALUES ($1, $2) RETURNING id, created_at, updated_at, deleted_at`
+	query := `INSERT INTO categories (userid, ledger, name)
+	VALUES ($1, $2, $3) RETURNING id, created_at, updated_at, deleted_at`
 
-	row := r.DB.QueryRow(query, c.UserID, c.Name)
+	row := r.DB.QueryRow(query, c.UserID, c.LedgerID, c.Name)
 
 	err := row.Scan(&c.ID, &c.CreatedAt, &c.UpdatedAt, &c.DeletedAt)
 
@@ -109,12 +153,28 @@ func (r *CategoriesRepo) create(c *Category) (*Category, error) {
 	return c, nil
 }
 
+func (r *CategoriesRepo) update(c *Category) (*Category, error) {
+	query := `UPDATE categories SET
+	name = $1,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = $2 RETURNING updated_at`
+
+	err := r.DB.QueryRow(query, c.Name, c.ID).Scan(&c.UpdatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
 func scanIntoCategory(rows *sql.Rows) (*Category, error) {
 	category := &Category{}
 
 	err := rows.Scan(
 		&category.ID,
 		&category.UserID,
+		&category.LedgerID,
 		&category.Name,
 		&category.CreatedAt,
 		&category.UpdatedAt,
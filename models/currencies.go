@@ -0,0 +1,279 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrNoExchangeRate is returned when the user has no rate that converts
+// between two currencies on a date.
+var ErrNoExchangeRate = errors.New("no exchange rate")
+
+// Sources of exchange rates.
+const (
+	RateManual = "manual"
+	RateECB    = "ecb"
+)
+
+// maxRates caps how many rates Find returns; a full ECB history has tens of
+// thousands.
+const maxRates = 1000
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// NormalizeCurrency upper-cases a currency code and checks that it looks like
+// an ISO 4217 code.
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	if !currencyPattern.MatchString(code) {
+		return "", fmt.Errorf("currency %q must be a three-letter code such as CAD", code)
+	}
+
+	return code, nil
+}
+
+// ParseRate reads a positive decimal exchange rate.
+func ParseRate(s string) (*big.Rat, error) {
+	rate, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+
+	if !ok || rate.Sign() <= 0 {
+		return nil, fmt.Errorf("rate %q must be a positive number", s)
+	}
+
+	return rate, nil
+}
+
+type ExchangeRatesRepo struct {
+	DB *sql.DB
+}
+
+const exchangeRateSelect = `
+SELECT id,
+	userid,
+	base,
+	quote,
+	date,
+	rate::text,
+	source,
+	created_at,
+	updated_at
+FROM exchange_rates`
+
+// Find returns the user's rates, latest first, optionally narrowed to one
+// base or quote currency and to dates between from and to (inclusive).
+func (r *ExchangeRatesRepo) Find(userId, base, quote string, from, to sql.NullTime) ([]*ExchangeRate, error) {
+	query := exchangeRateSelect + `
+WHERE userid = $1
+AND ($2 = '' OR base = $2)
+AND ($3 = '' OR quote = $3)
+AND ($4::date IS NULL OR date >= $4::date)
+AND ($5::date IS NULL OR date <= $5::date)
+ORDER BY date DESC, base ASC, quote ASC
+LIMIT $6`
+
+	rows, err := r.DB.Query(query, userId, base, quote, from, to, maxRates)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	rates := []*ExchangeRate{}
+
+	for rows.Next() {
+		rate, err := scanIntoExchangeRate(rows)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
+
+func (r *ExchangeRatesRepo) FindOne(rate *ExchangeRate) (*ExchangeRate, error) {
+	if rate.ID == "" {
+		return nil, fmt.Errorf("you must provide an id")
+	}
+
+	found, err := scanIntoExchangeRate(r.DB.QueryRow(exchangeRateSelect+`
+WHERE id = $1`, rate.ID))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("exchange rate with id not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+// Save stores a rate, replacing the user's rate for the same currencies and
+// date if there is one.
+func (r *ExchangeRatesRepo) Save(rate *ExchangeRate) (*ExchangeRate, error) {
+	query := `INSERT INTO exchange_rates (userid, base, quote, date, rate, source)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (userid, base, quote, date) DO UPDATE SET
+	rate = EXCLUDED.rate,
+	source = EXCLUDED.source,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	RETURNING id, rate::text, created_at, updated_at`
+
+	err := r.DB.QueryRow(query, rate.UserID, rate.Base, rate.Quote, rate.Date, rate.Rate, rate.Source).
+		Scan(&rate.ID, &rate.Rate, &rate.CreatedAt, &rate.UpdatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rate, nil
+}
+
+// SaveAll stores many rates for the user in a single statement, replacing
+// existing rates for the same currencies and dates. It returns how many were
+// stored.
+func (r *ExchangeRatesRepo) SaveAll(userId string, rates []*ExchangeRate) (int, error) {
+	if len(rates) == 0 {
+		return 0, nil
+	}
+
+	bases, quotes, dates, values, sources := []string{}, []string{}, []string{}, []string{}, []string{}
+
+	for _, rate := range rates {
+		bases = append(bases, rate.Base)
+		quotes = append(quotes, rate.Quote)
+		dates = append(dates, rate.Date.Format("2006-01-02"))
+		values = append(values, rate.Rate)
+		sources = append(sources, rate.Source)
+	}
+
+	query := `INSERT INTO exchange_rates (userid, base, quote, date, rate, source)
+	SELECT $1, r.base, r.quote, r.date, r.rate, r.source
+	FROM unnest($2::varchar[], $3::varchar[], $4::date[], $5::numeric[], $6::varchar[]) AS r (base, quote, date, rate, source)
+	ON CONFLICT (userid, base, quote, date) DO UPDATE SET
+	rate = EXCLUDED.rate,
+	source = EXCLUDED.source,
+	updated_at = (NOW() AT TIME ZONE 'UTC')`
+
+	saved, err := rowsAffected(r.DB.Exec(query, userId, pq.Array(bases), pq.Array(quotes), pq.Array(dates), pq.Array(values), pq.Array(sources)))
+
+	return int(saved), err
+}
+
+// Delete removes a rate. Rates are reference data rather than records of the
+// user's money, so they aren't kept around once deleted.
+func (r *ExchangeRatesRepo) Delete(id string) error {
+	_, err := r.DB.Exec(`DELETE FROM exchange_rates WHERE id = $1`, id)
+
+	return err
+}
+
+// Rate returns how many units of to one unit of from was worth on the date,
+// following the rules of the exchange_rate database function. The error wraps
+// ErrNoExchangeRate when there's no such rate.
+func (r *ExchangeRatesRepo) Rate(userId, from, to string, date time.Time) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+
+	var value string
+
+	err := r.DB.QueryRow(`SELECT exchange_rate($1, $2, $3, $4)::text`, userId, from, to, date).Scan(&value)
+
+	// exchange_rate raises when it finds no rate.
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "P0001" {
+		return nil, fmt.Errorf("%w from %s to %s on or before %s", ErrNoExchangeRate, from, to, date.Format("2006-01-02"))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	rate, ok := new(big.Rat).SetString(value)
+
+	if !ok {
+		return nil, fmt.Errorf("invalid exchange rate %q", value)
+	}
+
+	return rate, nil
+}
+
+// Converter converts amounts into one currency with a user's exchange rates,
+// remembering the rates it has looked up and those it found missing.
+type Converter struct {
+	Currency string
+
+	repo   *ExchangeRatesRepo
+	userId string
+	rates  map[string]*big.Rat
+}
+
+func (r *ExchangeRatesRepo) Converter(userId, currency string) *Converter {
+	return &Converter{
+		Currency: currency,
+		repo:     r,
+		userId:   userId,
+		rates:    map[string]*big.Rat{},
+	}
+}
+
+// Convert returns the amount in the converter's currency at the rate on the
+// date, rounded half away from zero.
+func (c *Converter) Convert(m Money, date time.Time) (Money, error) {
+	if m.Currency == c.Currency || m.IsZero() {
+		return NewMoney(m.Minor, c.Currency), nil
+	}
+
+	key := m.Currency + date.Format("2006-01-02")
+	rate, ok := c.rates[key]
+
+	if ok && rate == nil {
+		return Money{}, fmt.Errorf("%w from %s to %s on or before %s", ErrNoExchangeRate, m.Currency, c.Currency, date.Format("2006-01-02"))
+	}
+
+	if !ok {
+		var err error
+
+		rate, err = c.repo.Rate(c.userId, m.Currency, c.Currency, date)
+
+		if errors.Is(err, ErrNoExchangeRate) {
+			c.rates[key] = nil
+		}
+
+		if err != nil {
+			return Money{}, err
+		}
+
+		c.rates[key] = rate
+	}
+
+	return m.Convert(c.Currency, rate)
+}
+
+func scanIntoExchangeRate(row scanner) (*ExchangeRate, error) {
+	rate := &ExchangeRate{}
+	err := row.Scan(
+		&rate.ID,
+		&rate.UserID,
+		&rate.Base,
+		&rate.Quote,
+		&rate.Date,
+		&rate.Rate,
+		&rate.Source,
+		&rate.CreatedAt,
+		&rate.UpdatedAt,
+	)
+
+	return rate, err
+}
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alexgaudon/budgie/filter"
+)
+
+// FindFiltered returns the ledger's transactions matching a parsed query, in
+// the same order as Find. A nil query matches every transaction.
+func (r *TransactionsRepo) FindFiltered(userId, ledgerId string, node filter.Node) ([]*Transaction, error) {
+	c := &filterCompiler{args: []any{userId, ledgerId}}
+
+	condition, err := c.compile(node)
+
+	if err != nil {
+		return nil, err
+	}
+
+	query := transactionSelect + `
+WHERE t.deleted_at IS NULL
+AND t.userid = $1
+AND t.ledger = $2
+AND ` + condition + `
+ORDER BY t.created_at DESC`
+
+	return r.find(query, c.args...)
+}
+
+// filterCompiler turns a query into a SQL condition over transactionSelect.
+// Values only ever reach the SQL as parameters. Every condition is TRUE or
+// FALSE, never NULL, so negating one behaves as expected.
+type filterCompiler struct {
+	args []any
+}
+
+// arg adds a parameter and returns its placeholder.
+func (c *filterCompiler) arg(value any) string {
+	c.args = append(c.args, value)
+	return "$" + strconv.Itoa(len(c.args))
+}
+
+func (c *filterCompiler) compile(node filter.Node) (string, error) {
+	switch n := node.(type) {
+	case nil:
+		return "TRUE", nil
+	case *filter.And:
+		return c.join(n.Nodes, " AND ")
+	case *filter.Or:
+		return c.join(n.Nodes, " OR ")
+	case *filter.Not:
+		condition, err := c.compile(n.Node)
+
+		if err != nil {
+			return "", err
+		}
+
+		return "NOT (" + condition + ")", nil
+	case *filter.Text:
+		tsquery := SearchQuery(n.Value)
+
+		if tsquery == "" {
+			return "TRUE", nil
+		}
+
+		return fmt.Sprintf("COALESCE(t.search @@ to_tsquery('english', %s), FALSE)", c.arg(tsquery)), nil
+	case *filter.Match:
+		return c.match(n)
+	case *filter.AmountRange:
+		conditions := []string{}
+
+		// Transfer legs are signed; everything else is stored unsigned. The
+		// bounds are hundredths, so amounts are scaled from the minor units
+		// of their currency.
+		amount := "ABS(t.amount) * power(10::numeric, 2 - currency_exponent(t.currency))"
+
+		if n.Min != nil {
+			conditions = append(conditions, amount+" >= "+c.arg(*n.Min))
+		}
+
+		if n.Max != nil {
+			conditions = append(conditions, amount+" <= "+c.arg(*n.Max))
+		}
+
+		return "(" + strings.Join(conditions, " AND ") + ")", nil
+	case *filter.DateRange:
+		conditions := []string{}
+
+		if n.From != nil {
+			conditions = append(conditions, "t.date >= "+c.arg(*n.From))
+		}
+
+		if n.To != nil {
+			conditions = append(conditions, "t.date < "+c.arg(*n.To))
+		}
+
+		return "(" + strings.Join(conditions, " AND ") + ")", nil
+	}
+
+	return "", fmt.Errorf("unsupported query node %T", node)
+}
+
+func (c *filterCompiler) join(nodes []filter.Node, separator string) (string, error) {
+	conditions := []string{}
+
+	for _, node := range nodes {
+		condition, err := c.compile(node)
+
+		if err != nil {
+			return "", err
+		}
+
+		conditions = append(conditions, condition)
+	}
+
+	return "(" + strings.Join(conditions, separator) + ")", nil
+}
+
+// match compiles a text field comparison. Categories match the parent
+// category or the category of any split.
+func (c *filterCompiler) match(m *filter.Match) (string, error) {
+	var value, placeholder string
+
+	if m.Exact {
+		value = strings.ToLower(m.Value)
+	} else {
+		value = "%" + escapeLike(m.Value) + "%"
+	}
+
+	placeholder = c.arg(value)
+
+	compare := func(column string) string {
+		if m.Exact {
+			return fmt.Sprintf("lower(%s) = %s", column, placeholder)
+		}
+		return fmt.Sprintf("%s ILIKE %s", column, placeholder)
+	}
+
+	switch m.Field {
+	case "vendor":
+		return compare("t.vendor"), nil
+	case "description":
+		return compare("COALESCE(t.description, '')"), nil
+	case "payee":
+		return compare("COALESCE(payees.name, '')"), nil
+	case "category":
+		return fmt.Sprintf(`(%s OR EXISTS (
+	SELECT 1 FROM transaction_splits s
+	JOIN categories sc ON sc.id = s.category
+	WHERE s.transaction = t.id AND %s))`, compare("COALESCE(categories.name, '')"), compare("sc.name")), nil
+	case "account":
+		return fmt.Sprintf(`EXISTS (
+	SELECT 1 FROM accounts a
+	WHERE a.id = t.account AND %s)`, compare("a.name")), nil
+	case "tag":
+		return fmt.Sprintf(`EXISTS (
+	SELECT 1 FROM transaction_tags tt
+	JOIN tags tg ON tg.id = tt.tag
+	WHERE tt.transaction = t.id AND tg.deleted_at IS NULL AND %s)`, compare("tg.name")), nil
+	case "type":
+		return compare("t.type"), nil
+	case "status":
+		return compare("t.status"), nil
+	case "currency":
+		return compare("t.currency"), nil
+	}
+
+	return "", fmt.Errorf("unsupported query field %q", m.Field)
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,648 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	ImportStaged    = "staged"
+	ImportCommitted = "committed"
+	ImportReverted  = "reverted"
+)
+
+// ImportSummary counts what happened to the rows of a batch on commit.
+// Skipped rows turned out to be imported already by the time of the commit.
+type ImportSummary struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+	Excluded int `json:"excluded"`
+	Failed   int `json:"failed"`
+}
+
+type ImportBatchesRepo struct {
+	DB *sql.DB
+}
+
+const importBatchSelect = `
+SELECT id,
+	userid,
+	ledger,
+	account,
+	currency,
+	format,
+	filename,
+	status,
+	committed_at,
+	created_at,
+	updated_at,
+	deleted_at
+FROM import_batches`
+
+const importRowSelect = `
+SELECT r.id,
+	r.batch,
+	r.line,
+	r.date,
+	r.amount,
+	b.currency,
+	r.type,
+	r.vendor,
+	r.payee,
+	r.description,
+	COALESCE(categories.name, r.new_category),
+	COALESCE(r.category::text, ''),
+	r.new_category,
+	r.external_id,
+	r.fingerprint,
+	r.splits,
+	r.tags,
+	r.error,
+	r.excluded,
+	r.duplicate,
+	r.possible_duplicates,
+	r.transaction,
+	r.created_at,
+	r.updated_at
+FROM import_rows r
+JOIN import_batches b
+ON b.id = r.batch
+LEFT JOIN categories
+ON categories.id = r.category`
+
+func (r *ImportBatchesRepo) Find(userId, ledgerId string) ([]*ImportBatch, error) {
+	query := importBatchSelect + `
+WHERE userid = $1 AND ledger = $2 AND deleted_at IS NULL
+ORDER BY created_at DESC`
+
+	rows, err := r.DB.Query(query, userId, ledgerId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	batches := []*ImportBatch{}
+
+	for rows.Next() {
+		batch, err := scanIntoImportBatch(rows)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+
+	rows.Close()
+
+	return batches, nil
+}
+
+// FindOne returns the batch along with its rows, in file order.
+func (r *ImportBatchesRepo) FindOne(b *ImportBatch) (*ImportBatch, error) {
+	query := importBatchSelect + `
+WHERE id = $1 AND deleted_at IS NULL`
+
+	if b.ID == "" {
+		return nil, fmt.Errorf("you must provide an id")
+	}
+
+	batch, err := scanIntoImportBatch(r.DB.QueryRow(query, b.ID))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("import with id not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	batch.Rows, err = findImportRows(r.DB, batch.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return batch, nil
+}
+
+func findImportRows(db dbtx, batchId string) ([]*ImportRow, error) {
+	rows, err := db.Query(importRowSelect+`
+WHERE r.batch = $1
+ORDER BY r.line ASC`, batchId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	importRows := []*ImportRow{}
+
+	for rows.Next() {
+		row, err := scanIntoImportRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		importRows = append(importRows, row)
+	}
+
+	return importRows, rows.Err()
+}
+
+func (r *ImportBatchesRepo) FindRow(id string) (*ImportRow, error) {
+	row, err := scanIntoImportRow(r.DB.QueryRow(importRowSelect+`
+WHERE r.id = $1`, id))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("import row with id not found")
+	}
+
+	return row, err
+}
+
+// Create stores a new staged batch with all of its rows.
+func (r *ImportBatchesRepo) Create(b *ImportBatch) (*ImportBatch, error) {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	query := `INSERT INTO import_batches (userid, ledger, account, currency, format, filename, status)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at, updated_at, deleted_at`
+
+	b.Status = ImportStaged
+
+	err = tx.QueryRow(query, b.UserID, b.LedgerID, b.AccountID, b.Currency, b.Format, b.Filename, b.Status).
+		Scan(&b.ID, &b.CreatedAt, &b.UpdatedAt, &b.DeletedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range b.Rows {
+		row.BatchID = b.ID
+
+		if err := insertImportRow(tx, row); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// SaveRow stores the user's edits to a row, failing once its batch is no
+// longer staged.
+func (r *ImportBatchesRepo) SaveRow(row *ImportRow) (*ImportRow, error) {
+	splits, err := json.Marshal(row.Splits)
+
+	if err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE import_rows SET
+	date = $1,
+	amount = $2,
+	type = $3,
+	vendor = $4,
+	payee = $5,
+	description = $6,
+	category = NULLIF($7, '')::uuid,
+	new_category = $8,
+	splits = $9,
+	tags = $10,
+	excluded = $11,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = $12
+	AND batch IN (SELECT id FROM import_batches WHERE status = $13 AND deleted_at IS NULL)`
+
+	if row.Tags == nil {
+		row.Tags = []string{}
+	}
+
+	updated, err := rowsAffected(r.DB.Exec(query, row.Date, row.Amount, row.Type, row.Vendor, row.PayeeID, row.Description,
+		row.CategoryID, row.NewCategory, splits, pq.Array(row.Tags), row.Excluded, row.ID, ImportStaged))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if updated == 0 {
+		return nil, fmt.Errorf("only rows of staged imports can be changed")
+	}
+
+	row.UpdatedAt = time.Now().UTC()
+
+	return row, nil
+}
+
+// Delete discards a staged or reverted batch. Committed batches have to be
+// reverted first.
+func (r *ImportBatchesRepo) Delete(id string) error {
+	query := `UPDATE import_batches SET deleted_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $1 AND status <> $2`
+
+	deleted, err := rowsAffected(r.DB.Exec(query, id, ImportCommitted))
+
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return fmt.Errorf("committed imports have to be undone before they're deleted")
+	}
+
+	return nil
+}
+
+// Commit writes the batch's rows to the ledger in a single database
+// transaction. Excluded rows and rows that failed to parse are left out, as
+// are rows whose fingerprint has been imported since the batch was staged.
+// Categories the rows name but the ledger doesn't have yet are created along
+// with the transactions. Any other failure aborts the whole commit.
+func (r *ImportBatchesRepo) Commit(b *ImportBatch) (*ImportSummary, error) {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	// The batch stays locked until the commit is done, so a concurrent commit
+	// waits and then finds it committed rather than inserting its rows again.
+	if b.Status, err = lockBatch(tx, b.ID); err != nil {
+		return nil, err
+	}
+
+	if b.Status != ImportStaged {
+		return nil, fmt.Errorf("import is already %s", b.Status)
+	}
+
+	// Rows are read again under the lock so edits made since b was loaded
+	// are committed as saved.
+	if b.Rows, err = findImportRows(tx, b.ID); err != nil {
+		return nil, err
+	}
+
+	summary := &ImportSummary{}
+	categoryIds := map[string]string{}
+
+	for _, row := range b.Rows {
+		switch {
+		case row.Error.Valid:
+			summary.Failed++
+			continue
+		case row.Excluded:
+			summary.Excluded++
+			continue
+		}
+
+		if row.Fingerprint.Valid {
+			exists, err := fingerprintExists(tx, b.LedgerID, b.AccountID, row.Fingerprint.String)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if exists {
+				_, err := tx.Exec(`UPDATE import_rows SET duplicate = TRUE, excluded = TRUE WHERE id = $1`, row.ID)
+
+				if err != nil {
+					return nil, err
+				}
+
+				row.Duplicate, row.Excluded = true, true
+				summary.Skipped++
+				continue
+			}
+		}
+
+		if err := row.createCategories(tx, b, categoryIds); err != nil {
+			return nil, fmt.Errorf("line %d: %w", row.Line, err)
+		}
+
+		t := row.transaction(b)
+
+		if err := t.ValidateSplits(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", row.Line, err)
+		}
+
+		if _, err := insertTransaction(tx, t); err != nil {
+			return nil, fmt.Errorf("line %d: %w", row.Line, err)
+		}
+
+		if err := replaceSplits(tx, t); err != nil {
+			return nil, fmt.Errorf("line %d: %w", row.Line, err)
+		}
+
+		if err := replaceTags(tx, t); err != nil {
+			return nil, fmt.Errorf("line %d: %w", row.Line, err)
+		}
+
+		splits, err := json.Marshal(row.Splits)
+
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = tx.Exec(`UPDATE import_rows SET
+	transaction = $1,
+	category = $2,
+	new_category = '',
+	splits = $3
+	WHERE id = $4`, t.ID, row.CategoryID, splits, row.ID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		row.TransactionID = OptionalString{t.ID, true}
+		summary.Imported++
+	}
+
+	err = tx.QueryRow(`UPDATE import_batches SET
+	status = $1,
+	committed_at = (NOW() AT TIME ZONE 'UTC'),
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = $2 RETURNING committed_at`, ImportCommitted, b.ID).Scan(&b.CommittedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	b.Status = ImportCommitted
+
+	return summary, nil
+}
+
+// Revert removes every transaction a committed batch created. Batches with
+// reconciled transactions can't be reverted.
+func (r *ImportBatchesRepo) Revert(b *ImportBatch) error {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if b.Status, err = lockBatch(tx, b.ID); err != nil {
+		return err
+	}
+
+	if b.Status != ImportCommitted {
+		return fmt.Errorf("only committed imports can be undone")
+	}
+
+	var reconciled bool
+
+	err = tx.QueryRow(`SELECT EXISTS (
+	SELECT 1 FROM transactions
+	WHERE deleted_at IS NULL
+	AND status = $2
+	AND id IN (SELECT transaction FROM import_rows WHERE batch = $1 AND transaction IS NOT NULL)
+)`, b.ID, StatusReconciled).Scan(&reconciled)
+
+	if err != nil {
+		return err
+	}
+
+	if reconciled {
+		return fmt.Errorf("the import has reconciled transactions, unlock them first")
+	}
+
+	_, err = tx.Exec(`UPDATE transactions SET deleted_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE deleted_at IS NULL
+	AND id IN (SELECT transaction FROM import_rows WHERE batch = $1 AND transaction IS NOT NULL)`, b.ID)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE import_batches SET status = $1, updated_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $2`, ImportReverted, b.ID)
+
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	b.Status = ImportReverted
+
+	return nil
+}
+
+// lockBatch locks the batch's row for the rest of the transaction and returns
+// its current status.
+func lockBatch(tx *sql.Tx, id string) (string, error) {
+	var status string
+
+	err := tx.QueryRow(`SELECT status FROM import_batches WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&status)
+
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("import batch with id not found")
+	}
+
+	return status, err
+}
+
+// createCategories gives the row and its splits the ids of the categories
+// they only have a name for, creating the categories that the batch's ledger
+// doesn't have. categoryIds holds the ids found so far, by lowercase name.
+func (row *ImportRow) createCategories(tx *sql.Tx, b *ImportBatch, categoryIds map[string]string) error {
+	var err error
+
+	if row.CategoryID == "" && row.NewCategory != "" {
+		if row.CategoryID, err = importCategory(tx, b, row.NewCategory, categoryIds); err != nil {
+			return err
+		}
+
+		row.Category, row.NewCategory = row.NewCategory, ""
+	}
+
+	for _, split := range row.Splits {
+		if split.CategoryID == "" && split.Category != "" {
+			if split.CategoryID, err = importCategory(tx, b, split.Category, categoryIds); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// importCategory returns the id of the ledger's category with the name,
+// matched regardless of case, and creates the category if there's none.
+func importCategory(tx *sql.Tx, b *ImportBatch, name string, categoryIds map[string]string) (string, error) {
+	key := strings.ToLower(name)
+
+	if id, ok := categoryIds[key]; ok {
+		return id, nil
+	}
+
+	var id string
+
+	err := tx.QueryRow(`SELECT id FROM categories
+	WHERE ledger = $1 AND LOWER(name) = $2 AND deleted_at IS NULL
+	ORDER BY created_at ASC LIMIT 1`, b.LedgerID, key).Scan(&id)
+
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(`INSERT INTO categories (userid, ledger, name) VALUES ($1, $2, $3) RETURNING id`,
+			b.UserID, b.LedgerID, name).Scan(&id)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	categoryIds[key] = id
+
+	return id, nil
+}
+
+// transaction builds the ledger transaction for a staged row.
+func (row *ImportRow) transaction(b *ImportBatch) *Transaction {
+	t := &Transaction{
+		UserID:      b.UserID,
+		LedgerID:    b.LedgerID,
+		AccountID:   b.AccountID,
+		PayeeID:     row.PayeeID,
+		ExternalID:  row.ExternalID,
+		Fingerprint: row.Fingerprint,
+		Amount:      row.Amount,
+		CategoryID:  row.CategoryID,
+		Description: row.Description,
+		Vendor:      row.Vendor,
+		Date:        row.Date,
+		Type:        row.Type,
+		Tags:        row.Tags,
+	}
+
+	for _, split := range row.Splits {
+		t.Splits = append(t.Splits, &Split{
+			CategoryID: split.CategoryID,
+			Amount:     split.Amount,
+			Memo:       split.Memo,
+		})
+	}
+
+	return t
+}
+
+func insertImportRow(db dbtx, row *ImportRow) error {
+	if row.Splits == nil {
+		row.Splits = []*Split{}
+	}
+
+	if row.PossibleDuplicates == nil {
+		row.PossibleDuplicates = []string{}
+	}
+
+	if row.Tags == nil {
+		row.Tags = []string{}
+	}
+
+	splits, err := json.Marshal(row.Splits)
+
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO import_rows (batch, line, date, amount, type, vendor, payee, description, category, new_category,
+	external_id, fingerprint, splits, tags, error, excluded, duplicate, possible_duplicates)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')::uuid, $10, $11, $12, $13, $14, $15, $16, $17, $18::uuid[])
+	RETURNING id, created_at, updated_at`
+
+	return db.QueryRow(query, row.BatchID, row.Line, row.Date, row.Amount, row.Type, row.Vendor, row.PayeeID,
+		row.Description, row.CategoryID, row.NewCategory, row.ExternalID, row.Fingerprint, splits, pq.Array(row.Tags), row.Error,
+		row.Excluded, row.Duplicate, pq.Array(row.PossibleDuplicates)).Scan(&row.ID, &row.CreatedAt, &row.UpdatedAt)
+}
+
+func scanIntoImportBatch(row scanner) (*ImportBatch, error) {
+	b := &ImportBatch{}
+	err := row.Scan(
+		&b.ID,
+		&b.UserID,
+		&b.LedgerID,
+		&b.AccountID,
+		&b.Currency,
+		&b.Format,
+		&b.Filename,
+		&b.Status,
+		&b.CommittedAt,
+		&b.CreatedAt,
+		&b.UpdatedAt,
+		&b.DeletedAt,
+	)
+
+	return b, err
+}
+
+// scanIntoImportRow reads a row along with its batch's currency, which its
+// amount and split amounts are in.
+func scanIntoImportRow(row scanner) (*ImportRow, error) {
+	r := &ImportRow{}
+	var currency string
+	var splits []byte
+
+	err := row.Scan(
+		&r.ID,
+		&r.BatchID,
+		&r.Line,
+		&r.Date,
+		&r.Amount,
+		&currency,
+		&r.Type,
+		&r.Vendor,
+		&r.PayeeID,
+		&r.Description,
+		&r.Category,
+		&r.CategoryID,
+		&r.NewCategory,
+		&r.ExternalID,
+		&r.Fingerprint,
+		&splits,
+		pq.Array(&r.Tags),
+		&r.Error,
+		&r.Excluded,
+		&r.Duplicate,
+		pq.Array(&r.PossibleDuplicates),
+		&r.TransactionID,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(splits, &r.Splits); err != nil {
+		return nil, err
+	}
+
+	r.Amount.Currency = currency
+
+	for _, split := range r.Splits {
+		if split.Amount, err = split.Amount.In(currency); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
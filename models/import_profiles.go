@@ -0,0 +1,232 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	SignNegativeIsExpense = "negative_is_expense"
+	SignPositiveIsExpense = "positive_is_expense"
+)
+
+var ImportEncodings = map[string]bool{
+	"utf-8":        true,
+	"iso-8859-1":   true,
+	"windows-1252": true,
+}
+
+type ImportProfilesRepo struct {
+	DB *sql.DB
+}
+
+const importProfileSelect = `
+SELECT id,
+	userid,
+	name,
+	has_header,
+	delimiter,
+	encoding,
+	date_column,
+	date_format,
+	amount_column,
+	debit_column,
+	credit_column,
+	sign_convention,
+	decimal_separator,
+	vendor_column,
+	description_column,
+	category_column,
+	created_at,
+	updated_at,
+	deleted_at
+FROM import_profiles`
+
+// Validate checks that the profile describes a file the CSV importer can
+// read.
+func (p *ImportProfile) Validate() error {
+	if len([]rune(p.Delimiter)) != 1 {
+		return fmt.Errorf("delimiter must be a single character")
+	}
+
+	if !ImportEncodings[p.Encoding] {
+		return fmt.Errorf("unsupported encoding: %s", p.Encoding)
+	}
+
+	if p.DecimalSeparator != "." && p.DecimalSeparator != "," {
+		return fmt.Errorf("decimal separator must be . or ,")
+	}
+
+	if p.SignConvention != SignNegativeIsExpense && p.SignConvention != SignPositiveIsExpense {
+		return fmt.Errorf("unsupported sign convention: %s", p.SignConvention)
+	}
+
+	if p.DateColumn == "" || p.DateFormat == "" || p.VendorColumn == "" {
+		return fmt.Errorf("date column, date format and vendor column are required")
+	}
+
+	if !p.AmountColumn.Valid && !p.DebitColumn.Valid && !p.CreditColumn.Valid {
+		return fmt.Errorf("either an amount column or debit/credit columns are required")
+	}
+
+	return nil
+}
+
+func (r *ImportProfilesRepo) Find(userId string) ([]*ImportProfile, error) {
+	query := importProfileSelect + `
+WHERE userid = $1 AND deleted_at IS NULL
+ORDER BY name ASC`
+
+	rows, err := r.DB.Query(query, userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := []*ImportProfile{}
+
+	for rows.Next() {
+		profile, err := scanIntoImportProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+
+	rows.Close()
+
+	return profiles, nil
+}
+
+func (r *ImportProfilesRepo) FindOne(p *ImportProfile) (*ImportProfile, error) {
+	query := importProfileSelect + `
+WHERE id = $1 AND deleted_at IS NULL`
+
+	if p.ID == "" {
+		return nil, fmt.Errorf("you must provide an id")
+	}
+
+	profile, err := scanIntoImportProfile(r.DB.QueryRow(query, p.ID))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("import profile with id not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
+
+func (r *ImportProfilesRepo) Exists(p *ImportProfile) bool {
+	f, err := r.FindOne(p)
+
+	if err != nil {
+		return false
+	}
+
+	return f != nil && f.ID != ""
+}
+
+func (r *ImportProfilesRepo) Save(p *ImportProfile) (*ImportProfile, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	if p.ID != "" && r.Exists(p) {
+		return r.update(p)
+	}
+	return r.create(p)
+}
+
+func (r *ImportProfilesRepo) Delete(id string) error {
+	query := `UPDATE import_profiles SET deleted_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $1`
+
+	_, err := r.DB.Exec(query, id)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *ImportProfilesRepo) create(p *ImportProfile) (*ImportProfile, error) {
+	query := `INSERT INTO import_profiles (userid, name, has_header, delimiter, encoding, date_column, date_format,
+	amount_column, debit_column, credit_column, sign_convention, decimal_separator, vendor_column, description_column, category_column)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) RETURNING id, created_at, updated_at, deleted_at`
+
+	row := r.DB.QueryRow(query, p.UserID, p.Name, p.HasHeader, p.Delimiter, p.Encoding, p.DateColumn, p.DateFormat,
+		p.AmountColumn, p.DebitColumn, p.CreditColumn, p.SignConvention, p.DecimalSeparator, p.VendorColumn,
+		p.DescriptionColumn, p.CategoryColumn)
+
+	err := row.Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (r *ImportProfilesRepo) update(p *ImportProfile) (*ImportProfile, error) {
+	query := `UPDATE import_profiles SET
+	name = $1,
+	has_header = $2,
+	delimiter = $3,
+	encoding = $4,
+	date_column = $5,
+	date_format = $6,
+	amount_column = $7,
+	debit_column = $8,
+	credit_column = $9,
+	sign_convention = $10,
+	decimal_separator = $11,
+	vendor_column = $12,
+	description_column = $13,
+	category_column = $14,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = $15`
+
+	_, err := r.DB.Exec(query, p.Name, p.HasHeader, p.Delimiter, p.Encoding, p.DateColumn, p.DateFormat,
+		p.AmountColumn, p.DebitColumn, p.CreditColumn, p.SignConvention, p.DecimalSeparator, p.VendorColumn,
+		p.DescriptionColumn, p.CategoryColumn, p.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.UpdatedAt = time.Now().UTC()
+
+	return p, nil
+}
+
+func scanIntoImportProfile(row scanner) (*ImportProfile, error) {
+	p := &ImportProfile{}
+
+	err := row.Scan(
+		&p.ID,
+		&p.UserID,
+		&p.Name,
+		&p.HasHeader,
+		&p.Delimiter,
+		&p.Encoding,
+		&p.DateColumn,
+		&p.DateFormat,
+		&p.AmountColumn,
+		&p.DebitColumn,
+		&p.CreditColumn,
+		&p.SignConvention,
+		&p.DecimalSeparator,
+		&p.VendorColumn,
+		&p.DescriptionColumn,
+		&p.CategoryColumn,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+		&p.DeletedAt,
+	)
+
+	return p, err
+}
@@ -0,0 +1,282 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type LedgersRepo struct {
+	DB *sql.DB
+}
+
+func (r *LedgersRepo) Find(userId string) ([]*Ledger, error) {
+	query := `SELECT id, userid, name, archived, created_at, updated_at, deleted_at
+FROM ledgers
+WHERE userid = $1 AND deleted_at IS NULL
+ORDER BY created_at ASC`
+
+	rows, err := r.DB.Query(query, userId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ledgers := []*Ledger{}
+
+	for rows.Next() {
+		ledger, err := scanIntoLedger(rows)
+		if err != nil {
+			return nil, err
+		}
+		ledgers = append(ledgers, ledger)
+	}
+
+	rows.Close()
+
+	return ledgers, nil
+}
+
+func (r *LedgersRepo) FindOne(l *Ledger) (*Ledger, error) {
+	query := `SELECT id, userid, name, archived, created_at, updated_at, deleted_at FROM ledgers WHERE id = $1 AND deleted_at IS NULL`
+
+	if l.ID == "" {
+		return nil, fmt.Errorf("you must provide an id")
+	}
+
+	row := r.DB.QueryRow(query, l.ID)
+
+	ledger := &Ledger{}
+
+	err := row.Scan(
+		&ledger.ID,
+		&ledger.UserID,
+		&ledger.Name,
+		&ledger.Archived,
+		&ledger.CreatedAt,
+		&ledger.UpdatedAt,
+		&ledger.DeletedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("ledger with id not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ledger, nil
+}
+
+// Default returns the oldest active ledger for the user, creating one when
+// the user has none yet. Requests that don't name a ledger are scoped to it.
+func (r *LedgersRepo) Default(userId string) (*Ledger, error) {
+	query := `SELECT id, userid, name, archived, created_at, updated_at, deleted_at
+FROM ledgers
+WHERE userid = $1 AND archived = FALSE AND deleted_at IS NULL
+ORDER BY created_at ASC
+LIMIT 1`
+
+	ledger := &Ledger{}
+
+	err := r.DB.QueryRow(query, userId).Scan(
+		&ledger.ID,
+		&ledger.UserID,
+		&ledger.Name,
+		&ledger.Archived,
+		&ledger.CreatedAt,
+		&ledger.UpdatedAt,
+		&ledger.DeletedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return r.create(&Ledger{
+			UserID: userId,
+			Name:   "Default",
+		})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ledger, nil
+}
+
+func (r *LedgersRepo) Exists(l *Ledger) bool {
+	f, err := r.FindOne(l)
+
+	if err != nil {
+		return false
+	}
+
+	return f != nil && f.ID != ""
+}
+
+func (r *LedgersRepo) Save(l *Ledger) (*Ledger, error) {
+	if l.ID != "" && r.Exists(l) {
+		return r.update(l)
+	}
+	return r.create(l)
+}
+
+// Clone copies the categories and budgets of the ledger into a new ledger
+// with the given name. Transactions are not copied.
+func (r *LedgersRepo) Clone(l *Ledger, name string) (*Ledger, error) {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	clone := &Ledger{
+		UserID: l.UserID,
+		Name:   name,
+	}
+
+	err = tx.QueryRow(`INSERT INTO ledgers (userid, name) VALUES ($1, $2) RETURNING id, archived, created_at, updated_at, deleted_at`,
+		clone.UserID, clone.Name).Scan(&clone.ID, &clone.Archived, &clone.CreatedAt, &clone.UpdatedAt, &clone.DeletedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT id, name FROM categories WHERE ledger = $1 AND deleted_at IS NULL`, l.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	categoryNames := map[string]string{}
+
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		categoryNames[id] = name
+	}
+
+	rows.Close()
+
+	categoryIds := map[string]string{}
+
+	for id, name := range categoryNames {
+		var newId string
+		err := tx.QueryRow(`INSERT INTO categories (userid, ledger, name) VALUES ($1, $2, $3) RETURNING id`,
+			clone.UserID, clone.ID, name).Scan(&newId)
+
+		if err != nil {
+			return nil, err
+		}
+
+		categoryIds[id] = newId
+	}
+
+	// Budgets of deleted categories stay behind; their categories weren't
+	// copied.
+	rows, err = tx.Query(`SELECT b.category, b.amount, b.currency, b.period FROM budgets b
+	JOIN categories c ON c.id = b.category AND c.deleted_at IS NULL
+	WHERE b.ledger = $1 AND b.deleted_at IS NULL`, l.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	budgets := []*Budget{}
+
+	for rows.Next() {
+		b := &Budget{}
+		if err := rows.Scan(&b.CategoryID, &b.Amount, &b.Currency, &b.Period); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		budgets = append(budgets, b)
+	}
+
+	rows.Close()
+
+	for _, b := range budgets {
+		_, err := tx.Exec(`INSERT INTO budgets (userid, ledger, category, amount, currency, period) VALUES ($1, $2, $3, $4, $5, $6)`,
+			clone.UserID, clone.ID, categoryIds[b.CategoryID], b.Amount, b.Currency, b.Period)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return clone, nil
+}
+
+func (r *LedgersRepo) Delete(id string) error {
+	query := `UPDATE ledgers SET deleted_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $1`
+
+	_, err := r.DB.Exec(query, id)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *LedgersRepo) create(l *Ledger) (*Ledger, error) {
+	query := `INSERT INTO ledgers (userid, name, archived)
+	VALUES ($1, $2, $3) RETURNING id, created_at, updated_at, deleted_at`
+
+	row := r.DB.QueryRow(query, l.UserID, l.Name, l.Archived)
+
+	err := row.Scan(&l.ID, &l.CreatedAt, &l.UpdatedAt, &l.DeletedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if l.ID == "" {
+		return nil, fmt.Errorf("error creating ledger")
+	}
+
+	return l, nil
+}
+
+func (r *LedgersRepo) update(l *Ledger) (*Ledger, error) {
+	query := `UPDATE ledgers SET
+	name = $1,
+	archived = $2,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = $3`
+
+	_, err := r.DB.Exec(query, l.Name, l.Archived, l.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l.UpdatedAt = time.Now().UTC()
+
+	return l, nil
+}
+
+func scanIntoLedger(rows *sql.Rows) (*Ledger, error) {
+	l := &Ledger{}
+
+	err := rows.Scan(
+		&l.ID,
+		&l.UserID,
+		&l.Name,
+		&l.Archived,
+		&l.CreatedAt,
+		&l.UpdatedAt,
+		&l.DeletedAt,
+	)
+
+	return l, err
+}
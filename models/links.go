@@ -0,0 +1,532 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	LinkRefundOf     = "refund_of"
+	LinkReimbursedBy = "reimbursed_by"
+)
+
+// maxLinkSuggestions caps how many suggestions SuggestLinks returns.
+const maxLinkSuggestions = 10
+
+type TransactionLinksRepo struct {
+	DB *sql.DB
+}
+
+const linkSelect = `
+SELECT l.id,
+	l.userid,
+	l.ledger,
+	l.kind,
+	l.original,
+	l.settlement,
+	l.amount,
+	o.currency,
+	l.created_at,
+	l.updated_at,
+	l.deleted_at
+FROM transaction_links l
+JOIN transactions o
+ON o.id = l.original`
+
+// linkedAmount sums the links settling the expense t, leaving out those whose
+// settlement was deleted.
+const linkedAmount = `COALESCE((SELECT SUM(l.amount) FROM transaction_links l
+	JOIN transactions s ON s.id = l.settlement AND s.deleted_at IS NULL
+	WHERE l.deleted_at IS NULL AND l.original = t.id), 0)`
+
+// settledAmount sums the links t settles, leaving out those whose original was
+// deleted. Links to originals in another currency can't be taken off t's
+// amount and are left out too.
+const settledAmount = `COALESCE((SELECT SUM(l.amount) FROM transaction_links l
+	JOIN transactions o ON o.id = l.original AND o.deleted_at IS NULL AND o.currency = t.currency
+	WHERE l.deleted_at IS NULL AND l.settlement = t.id), 0)`
+
+// Find returns the links of a transaction, whichever side of them it's on,
+// oldest first. Links whose other side was deleted are left out, as they are
+// from linkedAmount and settledAmount.
+func (r *TransactionLinksRepo) Find(transactionId string) ([]*TransactionLink, error) {
+	query := linkSelect + `
+JOIN transactions s
+ON s.id = l.settlement
+WHERE l.deleted_at IS NULL
+AND o.deleted_at IS NULL
+AND s.deleted_at IS NULL
+AND (l.original = $1 OR l.settlement = $1)
+ORDER BY l.created_at ASC`
+
+	rows, err := r.DB.Query(query, transactionId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	links := []*TransactionLink{}
+
+	for rows.Next() {
+		link, err := scanIntoLink(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		links = append(links, link)
+	}
+
+	rows.Close()
+
+	return links, nil
+}
+
+func (r *TransactionLinksRepo) FindOne(l *TransactionLink) (*TransactionLink, error) {
+	query := linkSelect + `
+WHERE l.deleted_at IS NULL
+AND l.id = $1`
+
+	if l.ID == "" {
+		return nil, fmt.Errorf("you must provide an id")
+	}
+
+	link, err := scanIntoLink(r.DB.QueryRow(query, l.ID))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("transaction link with id not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return link, nil
+}
+
+// Create links two transactions of the link's ledger. The original must be an
+// expense, and the settlement a refund for LinkRefundOf or income for
+// LinkReimbursedBy. The amount can't be more than what's left of the original
+// to settle, nor, when both are in the same currency, more than what's left of
+// the settlement. A zero amount links as much as both have left, and is only
+// allowed when they're in the same currency. Linking a reimbursement marks the
+// original reimbursable.
+func (r *TransactionLinksRepo) Create(l *TransactionLink) (*TransactionLink, error) {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	// Both rows are locked, in id order so concurrent links can't deadlock,
+	// before the amounts already linked are summed.
+	rows, err := tx.Query(transactionSelect+`
+WHERE t.deleted_at IS NULL
+AND t.id = ANY($1::uuid[])
+ORDER BY t.id
+FOR UPDATE OF t`, pq.Array([]string{l.OriginalID, l.SettlementID}))
+
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]*Transaction{}
+
+	for rows.Next() {
+		t, err := scanIntoTransaction(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		found[t.ID] = t
+	}
+
+	rows.Close()
+
+	original, settlement := found[l.OriginalID], found[l.SettlementID]
+
+	if original == nil || settlement == nil || original.LedgerID != l.LedgerID || settlement.LedgerID != l.LedgerID {
+		return nil, fmt.Errorf("transaction not found")
+	}
+
+	if original.ID == settlement.ID {
+		return nil, fmt.Errorf("a transaction can't be linked to itself")
+	}
+
+	if err := checkLinkTypes(l.Kind, original, settlement); err != nil {
+		return nil, err
+	}
+
+	if l.Amount.Currency != original.Currency {
+		return nil, fmt.Errorf("link amount must be in %s like the expense", original.Currency)
+	}
+
+	if l.Amount.Sign() < 0 {
+		return nil, fmt.Errorf("link amount can't be negative")
+	}
+
+	if l.Amount.IsZero() && settlement.Currency != original.Currency {
+		return nil, fmt.Errorf("amount is required to link transactions in different currencies")
+	}
+
+	linked, err := linkTotals(tx, linkedAmount, []string{original.ID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	left := original.Amount.Minor - linked[original.ID]
+
+	if left <= 0 {
+		return nil, fmt.Errorf("the expense is already settled")
+	}
+
+	if settlement.Currency == original.Currency {
+		settled, err := linkTotals(tx, settledAmount, []string{settlement.ID})
+
+		if err != nil {
+			return nil, err
+		}
+
+		settlementLeft := settlement.Amount.Minor - settled[settlement.ID]
+
+		if settlementLeft <= 0 {
+			return nil, fmt.Errorf("the %s is already fully linked", settlement.Type)
+		}
+
+		if l.Amount.IsZero() {
+			l.Amount.Minor = left
+			if settlementLeft < left {
+				l.Amount.Minor = settlementLeft
+			}
+		}
+
+		if l.Amount.Minor > settlementLeft {
+			return nil, fmt.Errorf("only %s of the %s is left to link", NewMoney(settlementLeft, settlement.Currency), settlement.Type)
+		}
+	}
+
+	if l.Amount.Minor > left {
+		return nil, fmt.Errorf("only %s of the expense is left to settle", NewMoney(left, original.Currency))
+	}
+
+	query := `INSERT INTO transaction_links (userid, ledger, kind, original, settlement, amount)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at, deleted_at`
+
+	err = tx.QueryRow(query, l.UserID, l.LedgerID, l.Kind, l.OriginalID, l.SettlementID, l.Amount).
+		Scan(&l.ID, &l.CreatedAt, &l.UpdatedAt, &l.DeletedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if l.Kind == LinkReimbursedBy && !original.Reimbursable {
+		_, err := tx.Exec(`UPDATE transactions SET
+	reimbursable = TRUE,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = $1`, original.ID)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Delete removes a link. A reimbursable expense stays reimbursable, and owed
+// again.
+func (r *TransactionLinksRepo) Delete(id string) error {
+	query := `UPDATE transaction_links SET deleted_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $1`
+
+	_, err := r.DB.Exec(query, id)
+
+	return err
+}
+
+// FindReimbursables returns the ledger's reimbursable expenses that haven't
+// been fully settled, oldest first.
+func (r *TransactionsRepo) FindReimbursables(userId, ledgerId string) ([]*Reimbursable, error) {
+	query := transactionSelect + `
+WHERE t.deleted_at IS NULL
+AND t.userid = $1
+AND t.ledger = $2
+AND t.type = 'expense'
+AND t.reimbursable
+AND t.amount > ` + linkedAmount + `
+ORDER BY t.date ASC, t.created_at ASC`
+
+	transactions, err := r.find(query, userId, ledgerId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	linked, err := linkTotals(r.DB, linkedAmount, transactionIds(transactions))
+
+	if err != nil {
+		return nil, err
+	}
+
+	reimbursables := []*Reimbursable{}
+
+	for _, t := range transactions {
+		settled := NewMoney(linked[t.ID], t.Currency)
+		outstanding, err := t.Amount.Sub(settled)
+
+		if err != nil {
+			return nil, err
+		}
+
+		reimbursables = append(reimbursables, &Reimbursable{
+			Transaction: t,
+			Settled:     settled,
+			Outstanding: outstanding,
+		})
+	}
+
+	return reimbursables, nil
+}
+
+// SuggestLinks returns transactions in the same currency that t could be
+// linked with, within the given number of days of it: the earlier expenses a
+// refund or reimbursement could settle, or the later refunds and, for a
+// reimbursable expense, income that could settle an expense. Only those with
+// something left to link whose remaining amount or vendor matches t's are
+// suggested, best matches first.
+func (r *TransactionsRepo) SuggestLinks(t *Transaction, days int) ([]*LinkSuggestion, error) {
+	var (
+		transactions []*Transaction
+		err          error
+	)
+
+	switch t.Type {
+	case TypeRefund, TypeIncome:
+		transactions, err = r.find(transactionSelect+`
+WHERE t.deleted_at IS NULL
+AND t.ledger = $1
+AND t.currency = $2
+AND t.type = 'expense'
+AND ($3 OR t.reimbursable)
+AND t.date >= $4::date - $5::int
+AND t.date < $4::date + 1
+AND t.id <> $6
+ORDER BY t.date DESC`, t.LedgerID, t.Currency, t.Type == TypeRefund, t.Date, days, t.ID)
+	case TypeExpense:
+		transactions, err = r.find(transactionSelect+`
+WHERE t.deleted_at IS NULL
+AND t.ledger = $1
+AND t.currency = $2
+AND (t.type = 'refund' OR ($3 AND t.type = 'income'))
+AND t.date >= $4::date
+AND t.date < $4::date + $5::int + 1
+AND t.id <> $6
+ORDER BY t.date ASC`, t.LedgerID, t.Currency, t.Reimbursable, t.Date, days, t.ID)
+	default:
+		return []*LinkSuggestion{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var expenses, settlements []string
+
+	if t.Type == TypeExpense {
+		expenses, settlements = []string{t.ID}, transactionIds(transactions)
+	} else {
+		expenses, settlements = transactionIds(transactions), []string{t.ID}
+	}
+
+	linked, err := linkTotals(r.DB, linkedAmount, expenses)
+
+	if err != nil {
+		return nil, err
+	}
+
+	settled, err := linkTotals(r.DB, settledAmount, settlements)
+
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := func(other *Transaction) int64 {
+		if other.Type == TypeExpense {
+			return other.Amount.Minor - linked[other.ID]
+		}
+		return other.Amount.Minor - settled[other.ID]
+	}
+
+	left := remaining(t)
+	suggestions := []*LinkSuggestion{}
+
+	if left <= 0 {
+		return suggestions, nil
+	}
+
+	for _, other := range transactions {
+		otherLeft := remaining(other)
+
+		if otherLeft <= 0 {
+			continue
+		}
+
+		suggestion := &LinkSuggestion{
+			Kind:        LinkRefundOf,
+			Transaction: other,
+			Remaining:   NewMoney(otherLeft, other.Currency),
+			AmountMatch: otherLeft == left,
+			VendorMatch: sameVendor(t, other),
+		}
+
+		if t.Type == TypeIncome || other.Type == TypeIncome {
+			suggestion.Kind = LinkReimbursedBy
+		}
+
+		if suggestion.AmountMatch || suggestion.VendorMatch {
+			suggestions = append(suggestions, suggestion)
+		}
+	}
+
+	score := func(s *LinkSuggestion) int {
+		n := 0
+		if s.AmountMatch {
+			n += 2
+		}
+		if s.VendorMatch {
+			n++
+		}
+		return n
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		a, b := suggestions[i], suggestions[j]
+
+		if score(a) != score(b) {
+			return score(a) > score(b)
+		}
+
+		return absDuration(a.Transaction.Date.Sub(t.Date)) < absDuration(b.Transaction.Date.Sub(t.Date))
+	})
+
+	if len(suggestions) > maxLinkSuggestions {
+		suggestions = suggestions[:maxLinkSuggestions]
+	}
+
+	return suggestions, nil
+}
+
+// checkLinkTypes makes sure the transactions' types fit the kind of link.
+func checkLinkTypes(kind string, original, settlement *Transaction) error {
+	if original.Type != TypeExpense {
+		return fmt.Errorf("only expenses can be refunded or reimbursed")
+	}
+
+	switch kind {
+	case LinkRefundOf:
+		if settlement.Type != TypeRefund {
+			return fmt.Errorf("an expense can only be refunded by a refund")
+		}
+	case LinkReimbursedBy:
+		if settlement.Type != TypeIncome {
+			return fmt.Errorf("an expense can only be reimbursed by income")
+		}
+	default:
+		return fmt.Errorf("kind must be %s or %s", LinkRefundOf, LinkReimbursedBy)
+	}
+
+	return nil
+}
+
+// linkTotals evaluates amount, linkedAmount or settledAmount, for each of the
+// transactions, in minor units of its currency.
+func linkTotals(db dbtx, amount string, ids []string) (map[string]int64, error) {
+	totals := map[string]int64{}
+
+	if len(ids) == 0 {
+		return totals, nil
+	}
+
+	rows, err := db.Query(`SELECT t.id, `+amount+` FROM transactions t WHERE t.id = ANY($1::uuid[])`, pq.Array(ids))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var total int64
+
+		if err := rows.Scan(&id, &total); err != nil {
+			return nil, err
+		}
+
+		totals[id] = total
+	}
+
+	return totals, rows.Err()
+}
+
+// sameVendor reports whether two transactions have the same payee, or failing
+// that the same vendor.
+func sameVendor(a, b *Transaction) bool {
+	if a.PayeeID.Valid && b.PayeeID.Valid {
+		return a.PayeeID.String == b.PayeeID.String
+	}
+
+	vendor := strings.TrimSpace(a.Vendor)
+
+	return vendor != "" && strings.EqualFold(vendor, strings.TrimSpace(b.Vendor))
+}
+
+func transactionIds(transactions []*Transaction) []string {
+	ids := make([]string, len(transactions))
+
+	for i, t := range transactions {
+		ids[i] = t.ID
+	}
+
+	return ids
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+
+	return d
+}
+
+func scanIntoLink(row scanner) (*TransactionLink, error) {
+	l := &TransactionLink{}
+	var currency string
+	err := row.Scan(
+		&l.ID,
+		&l.UserID,
+		&l.LedgerID,
+		&l.Kind,
+		&l.OriginalID,
+		&l.SettlementID,
+		&l.Amount,
+		&currency,
+		&l.CreatedAt,
+		&l.UpdatedAt,
+		&l.DeletedAt,
+	)
+
+	l.Amount.Currency = currency
+
+	return l, err
+}
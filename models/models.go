@@ -2,11 +2,26 @@ package models
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"reflect"
+	"regexp"
 	"time"
 )
 
+// dbtx is satisfied by both *sql.DB and *sql.Tx, so repo helpers can run
+// inside or outside of a database transaction.
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
 type BaseModel struct {
 	ID        string       `json:"id"`
 	CreatedAt time.Time    `json:"created_at"`
@@ -21,6 +36,32 @@ type User struct {
 	DeletedAt    sql.NullTime `json:"deleted_at"`
 	Username     string       `json:"username"`
 	PasswordHash string       `json:"-"`
+	BaseCurrency string       `json:"base_currency"`
+}
+
+type Ledger struct {
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	DeletedAt sql.NullTime `json:"-"`
+	UserID    string       `json:"user"`
+	Name      string       `json:"name"`
+	Archived  bool         `json:"archived"`
+}
+
+type Account struct {
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	DeletedAt sql.NullTime `json:"-"`
+
+	UserID         string `json:"user"`
+	LedgerID       string `json:"ledger"`
+	Name           string `json:"name"`
+	Kind           string `json:"kind"`
+	OpeningBalance Money  `json:"opening_balance"`
+	Currency       string `json:"currency"`
+	Archived       bool   `json:"archived"`
 }
 
 type Category struct {
@@ -29,6 +70,7 @@ type Category struct {
 	UpdatedAt time.Time    `json:"updated_at"`
 	DeletedAt sql.NullTime `json:"-"`
 	UserID    string       `json:"user"`
+	LedgerID  string       `json:"ledger"`
 	Name      string       `json:"name"`
 }
 
@@ -39,9 +81,11 @@ type Budget struct {
 	DeletedAt sql.NullTime `json:"-"`
 
 	UserID     string    `json:"user"`
+	LedgerID   string    `json:"ledger"`
 	Category   string    `json:"category"`
 	CategoryID string    `json:"category_id"`
-	Amount     int       `json:"amount"`
+	Amount     Money     `json:"amount"`
+	Currency   string    `json:"currency"`
 	Period     time.Time `json:"period"`
 }
 
@@ -52,13 +96,339 @@ type Transaction struct {
 	DeletedAt sql.NullTime `json:"deleted_at"`
 
 	UserID      string         `json:"user"`
-	Amount      int            `json:"amount"`
+	LedgerID    string         `json:"ledger"`
+	AccountID   OptionalString `json:"account_id"`
+	TransferID  OptionalString `json:"transfer_id"`
+	PayeeID     OptionalString `json:"payee_id"`
+	Payee       string         `json:"payee"`
+	ExternalID  OptionalString `json:"external_id"`
+	Fingerprint OptionalString `json:"fingerprint"`
+	Amount      Money          `json:"amount"`
+	Currency    string         `json:"currency"`
 	Category    string         `json:"category"`
 	CategoryID  string         `json:"category_id"`
 	Description OptionalString `json:"description"`
 	Vendor      string         `json:"vendor"`
 	Date        time.Time      `json:"date"`
 	Type        string         `json:"type"`
+	Splits      []*Split       `json:"splits"`
+	Tags        []string       `json:"tags"`
+
+	Status           string         `json:"status"`
+	ReconciliationID OptionalString `json:"reconciliation_id"`
+
+	// Reimbursable marks an expense someone owes back, until links to
+	// reimbursements settle it.
+	Reimbursable bool `json:"reimbursable"`
+}
+
+type Recurring struct {
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	DeletedAt sql.NullTime `json:"-"`
+
+	UserID      string         `json:"user"`
+	LedgerID    string         `json:"ledger"`
+	AccountID   OptionalString `json:"account_id"`
+	Category    string         `json:"category"`
+	CategoryID  string         `json:"category_id"`
+	Amount      Money          `json:"amount"`
+	Currency    string         `json:"currency"`
+	Description OptionalString `json:"description"`
+	Vendor      string         `json:"vendor"`
+	Type        string         `json:"type"`
+	Rule        string         `json:"rule"`
+	StartDate   time.Time      `json:"start_date"`
+	Active      bool           `json:"active"`
+}
+
+type ImportProfile struct {
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	DeletedAt sql.NullTime `json:"-"`
+
+	UserID            string         `json:"user"`
+	Name              string         `json:"name"`
+	HasHeader         bool           `json:"has_header"`
+	Delimiter         string         `json:"delimiter"`
+	Encoding          string         `json:"encoding"`
+	DateColumn        string         `json:"date_column"`
+	DateFormat        string         `json:"date_format"`
+	AmountColumn      OptionalString `json:"amount_column"`
+	DebitColumn       OptionalString `json:"debit_column"`
+	CreditColumn      OptionalString `json:"credit_column"`
+	SignConvention    string         `json:"sign_convention"`
+	DecimalSeparator  string         `json:"decimal_separator"`
+	VendorColumn      string         `json:"vendor_column"`
+	DescriptionColumn OptionalString `json:"description_column"`
+	CategoryColumn    OptionalString `json:"category_column"`
+}
+
+// Payee is a merchant or person transactions are made with. Transactions are
+// linked to a payee when their vendor, normalized, equals the payee's name or
+// one of its aliases, or matches one of its patterns (case-insensitive
+// regular expressions).
+type Payee struct {
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	DeletedAt sql.NullTime `json:"-"`
+
+	UserID            string         `json:"user"`
+	LedgerID          string         `json:"ledger"`
+	Name              string         `json:"name"`
+	Aliases           []string       `json:"aliases"`
+	Patterns          []string       `json:"patterns"`
+	DefaultCategory   string         `json:"default_category"`
+	DefaultCategoryID OptionalString `json:"default_category_id"`
+
+	patterns []*regexp.Regexp
+}
+
+// Tag is a free-form label for transactions, cutting across categories. Tag
+// names are unique within a ledger, ignoring case, and transactions refer to
+// their tags by name.
+type Tag struct {
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	DeletedAt sql.NullTime `json:"-"`
+
+	UserID   string `json:"user"`
+	LedgerID string `json:"ledger"`
+	Name     string `json:"name"`
+}
+
+// TagSpending is the total of a tag's transactions by type, in Currency.
+// When some transactions couldn't be converted for want of an exchange rate,
+// the totals leave them out and MissingRate says which rate is missing.
+type TagSpending struct {
+	TagID        string `json:"tag_id"`
+	Tag          string `json:"tag"`
+	Expense      Money  `json:"expense"`
+	Income       Money  `json:"income"`
+	Currency     string `json:"currency"`
+	Transactions int    `json:"transactions"`
+	MissingRate  string `json:"missing_rate,omitempty"`
+}
+
+// Attachment is a file, usually a receipt, attached to a transaction. The
+// content lives in the blob store under StorageKey, with a JPEG preview under
+// ThumbnailKey for images the server can decode.
+type Attachment struct {
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	DeletedAt sql.NullTime `json:"-"`
+
+	UserID        string         `json:"user"`
+	LedgerID      string         `json:"ledger"`
+	TransactionID string         `json:"transaction"`
+	Filename      string         `json:"filename"`
+	ContentType   string         `json:"content_type"`
+	Size          int            `json:"size"`
+	SHA256        string         `json:"sha256"`
+	StorageKey    string         `json:"-"`
+	ThumbnailKey  OptionalString `json:"-"`
+	HasThumbnail  bool           `json:"has_thumbnail"`
+}
+
+// TransactionLink ties an expense, the original, to a transaction settling
+// some or all of it: the refund of a return (LinkRefundOf) or the income that
+// reimbursed it (LinkReimbursedBy). Amount is how much of the original it
+// settles, in the original's currency.
+type TransactionLink struct {
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	DeletedAt sql.NullTime `json:"-"`
+
+	UserID       string `json:"user"`
+	LedgerID     string `json:"ledger"`
+	Kind         string `json:"kind"`
+	OriginalID   string `json:"original_id"`
+	SettlementID string `json:"settlement_id"`
+	Amount       Money  `json:"amount"`
+}
+
+// LinkSuggestion is a transaction that may settle, or be settled by, the one
+// suggestions were asked for. Remaining is what's left of it to link.
+type LinkSuggestion struct {
+	Kind        string       `json:"kind"`
+	Transaction *Transaction `json:"transaction"`
+	Remaining   Money        `json:"remaining"`
+	AmountMatch bool         `json:"amount_match"`
+	VendorMatch bool         `json:"vendor_match"`
+}
+
+// Reimbursable is a reimbursable expense and how much of it is still owed,
+// after refunds and reimbursements linked to it.
+type Reimbursable struct {
+	*Transaction
+	Settled     Money `json:"settled"`
+	Outstanding Money `json:"outstanding"`
+}
+
+// TransactionRule categorizes transactions automatically. A rule matches when
+// all of its conditions hold: vendor and description patterns (MatchContains
+// or MatchRegex, both case-insensitive), an inclusive amount range in minor
+// units of the transaction's currency, the account and the type. Unset
+// conditions always hold. Rules run in ascending priority.
+type TransactionRule struct {
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	DeletedAt sql.NullTime `json:"-"`
+
+	UserID             string         `json:"user"`
+	LedgerID           string         `json:"ledger"`
+	Name               string         `json:"name"`
+	Priority           int            `json:"priority"`
+	Active             bool           `json:"active"`
+	VendorMatch        string         `json:"vendor_match"`
+	VendorPattern      string         `json:"vendor_pattern"`
+	DescriptionMatch   string         `json:"description_match"`
+	DescriptionPattern string         `json:"description_pattern"`
+	AmountMin          *Money         `json:"amount_min"`
+	AmountMax          *Money         `json:"amount_max"`
+	Currency           string         `json:"currency"`
+	AccountID          OptionalString `json:"account_id"`
+	Type               OptionalString `json:"type"`
+	SetCategoryID      OptionalString `json:"set_category_id"`
+	RenameVendor       OptionalString `json:"rename_vendor"`
+	AddTags            []string       `json:"add_tags"`
+
+	vendorRegex      *regexp.Regexp
+	descriptionRegex *regexp.Regexp
+}
+
+// ExchangeRate says one unit of Base was worth Rate units of Quote on Date.
+// Rate is a decimal string so no precision is lost on the way through JSON.
+type ExchangeRate struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	UserID string    `json:"user"`
+	Base   string    `json:"base"`
+	Quote  string    `json:"quote"`
+	Date   time.Time `json:"date"`
+	Rate   string    `json:"rate"`
+	Source string    `json:"source"`
+}
+
+// Reconciliation is a session checking an account's cleared transactions
+// against a statement. ClearedBalance is the opening balance of the account
+// plus every cleared or reconciled transaction dated on or before the
+// statement date; the session can be finalized once it matches
+// EndingBalance, which locks those transactions as reconciled. Balances are in
+// the account's currency.
+type Reconciliation struct {
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	DeletedAt sql.NullTime `json:"-"`
+
+	UserID         string       `json:"user"`
+	LedgerID       string       `json:"ledger"`
+	AccountID      string       `json:"account_id"`
+	StatementDate  time.Time    `json:"statement_date"`
+	EndingBalance  Money        `json:"ending_balance"`
+	Currency       string       `json:"currency"`
+	Status         string       `json:"status"`
+	FinalizedAt    sql.NullTime `json:"finalized_at"`
+	ClearedBalance Money        `json:"cleared_balance"`
+	Difference     Money        `json:"difference"`
+}
+
+// ImportBatch is an uploaded statement waiting in staging for review, or
+// already committed to the ledger. Rows is only loaded by FindOne.
+type ImportBatch struct {
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	DeletedAt sql.NullTime `json:"-"`
+
+	UserID      string         `json:"user"`
+	LedgerID    string         `json:"ledger"`
+	AccountID   OptionalString `json:"account_id"`
+	Currency    string         `json:"currency"`
+	Format      string         `json:"format"`
+	Filename    string         `json:"filename"`
+	Status      string         `json:"status"`
+	CommittedAt sql.NullTime   `json:"committed_at"`
+	Rows        []*ImportRow   `json:"rows,omitempty"`
+}
+
+// ImportRow is a staged statement entry. CategoryID starts out as the
+// suggested category. Duplicate marks rows already imported by an earlier
+// batch, which start out excluded; PossibleDuplicates lists transactions that
+// look like the same entry. TransactionID is set once the row is committed.
+// NewCategory names a category from the file that doesn't exist yet; it's
+// created when the batch is committed. Splits for such categories only have
+// a Category name until then.
+type ImportRow struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	BatchID            string         `json:"batch"`
+	Line               int            `json:"line"`
+	Date               time.Time      `json:"date"`
+	Amount             Money          `json:"amount"`
+	Type               string         `json:"type"`
+	Vendor             string         `json:"vendor"`
+	PayeeID            OptionalString `json:"payee_id"`
+	Description        OptionalString `json:"description"`
+	Category           string         `json:"category"`
+	CategoryID         string         `json:"category_id"`
+	NewCategory        string         `json:"new_category"`
+	ExternalID         OptionalString `json:"external_id"`
+	Fingerprint        OptionalString `json:"fingerprint"`
+	Splits             []*Split       `json:"splits"`
+	Tags               []string       `json:"tags"`
+	Error              OptionalString `json:"error"`
+	Excluded           bool           `json:"excluded"`
+	Duplicate          bool           `json:"duplicate"`
+	PossibleDuplicates []string       `json:"possible_duplicates"`
+	TransactionID      OptionalString `json:"transaction_id"`
+}
+
+type Split struct {
+	ID            string         `json:"id"`
+	TransactionID string         `json:"transaction"`
+	Category      string         `json:"category"`
+	CategoryID    string         `json:"category_id"`
+	Amount        Money          `json:"amount"`
+	Memo          OptionalString `json:"memo"`
+}
+
+// BulkOperation is one change applied to every transaction of a bulk
+// request. Op says which of the other fields is used.
+type BulkOperation struct {
+	Op         string    `json:"op"`
+	CategoryID string    `json:"category_id,omitempty"`
+	Type       string    `json:"type,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	Date       time.Time `json:"date,omitempty"`
+}
+
+// BulkResult summarizes a bulk request: how many transactions it matched and
+// how many rows each operation changed.
+type BulkResult struct {
+	Matched    int                    `json:"matched"`
+	Operations []*BulkOperationResult `json:"operations"`
+}
+
+// BulkOperationResult is what one operation changed. Skipped counts the
+// matched transactions the operation had to leave alone.
+type BulkOperationResult struct {
+	Op       string `json:"op"`
+	Affected int    `json:"affected"`
+	Skipped  int    `json:"skipped"`
 }
 
 type OptionalString sql.NullString
@@ -78,6 +448,14 @@ func (os *OptionalString) Scan(value interface{}) error {
 	return nil
 }
 
+func (os OptionalString) Value() (driver.Value, error) {
+	if !os.Valid {
+		return nil, nil
+	}
+
+	return os.String, nil
+}
+
 func (os *OptionalString) MarshalJSON() ([]byte, error) {
 	if !os.Valid {
 		return []byte("null"), nil
@@ -87,7 +465,28 @@ func (os *OptionalString) MarshalJSON() ([]byte, error) {
 }
 
 func (os *OptionalString) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*os = OptionalString{"", false}
+		return nil
+	}
+
 	err := json.Unmarshal(b, &os.String)
 	os.Valid = (err == nil)
 	return err
 }
+
+// Patch is a field of a JSON merge patch (RFC 7396). Set tells a field left
+// out of the patch apart from one given a value, and Null marks an explicit
+// null. With OptionalString as T, null also clears the value.
+type Patch[T any] struct {
+	Set   bool
+	Null  bool
+	Value T
+}
+
+func (p *Patch[T]) UnmarshalJSON(b []byte) error {
+	p.Set = true
+	p.Null = string(b) == "null"
+
+	return json.Unmarshal(b, &p.Value)
+}
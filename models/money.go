@@ -0,0 +1,473 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Money is an exact amount in the minor units of its currency, e.g. 1234 CAD
+// is $12.34 and 1234 JPY is ¥1234. How many minor units make up a unit is
+// given by CurrencyExponent.
+//
+// In JSON, Money is a decimal string such as "12.34"; JSON numbers are
+// refused, since 1234 could mean either 12.34 or 1234.00. A decimal string
+// can't be turned into minor units until the currency is known, so decoded
+// Money has to go through In before it's used.
+type Money struct {
+	Minor    int64
+	Currency string
+
+	// pending holds an amount decoded from a decimal string until In gives it
+	// a currency.
+	pending *big.Rat
+}
+
+// zeroDecimalCurrencies and threeDecimalCurrencies are the ISO 4217
+// currencies whose minor unit isn't a hundredth.
+var (
+	zeroDecimalCurrencies = map[string]bool{
+		"BIF": true, "CLP": true, "DJF": true, "GNF": true, "ISK": true, "JPY": true,
+		"KMF": true, "KRW": true, "PYG": true, "RWF": true, "UGX": true, "UYI": true,
+		"VND": true, "VUV": true, "XAF": true, "XOF": true, "XPF": true,
+	}
+	threeDecimalCurrencies = map[string]bool{
+		"BHD": true, "IQD": true, "JOD": true, "KWD": true, "LYD": true, "OMR": true,
+		"TND": true,
+	}
+)
+
+// maxMoneyDigits caps the length of decimal strings, well past anything that
+// fits in an int64.
+const maxMoneyDigits = 30
+
+var decimalPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// CurrencyExponent returns the number of decimals of the currency's minor
+// unit. It matches the currency_exponent database function.
+func CurrencyExponent(currency string) int {
+	switch {
+	case zeroDecimalCurrencies[currency]:
+		return 0
+	case threeDecimalCurrencies[currency]:
+		return 3
+	}
+
+	return 2
+}
+
+func NewMoney(minor int64, currency string) Money {
+	return Money{Minor: minor, Currency: currency}
+}
+
+// Cents returns the amount in hundredths of a unit, rounded half away from
+// zero for currencies with three decimals.
+func (m Money) Cents() int64 {
+	cents, _ := scaleMinor(big.NewRat(m.Minor, 1), 2-CurrencyExponent(m.Currency))
+	return cents
+}
+
+// ParseMoney reads a decimal amount such as "1,234.56", "-12.3", "(45.00)" or
+// "1.234,56 €" in the given currency. decimalSeparator is "." or ","; the
+// other character, spaces and apostrophes are accepted as thousands
+// separators, one kind per amount, between groups of three digits. A currency symbol or code may come before or after the number,
+// and a single sign before it, a trailing minus or parentheses mark a
+// negative amount; letters and signs anywhere else are refused. The amount
+// can't have more decimals than the currency's minor unit.
+func ParseMoney(s, currency, decimalSeparator string) (Money, error) {
+	value := strings.TrimSpace(s)
+
+	if value == "" {
+		return Money{}, fmt.Errorf("amount is empty")
+	}
+
+	invalid := fmt.Errorf("invalid amount %q", s)
+	negative := false
+	signs := 0
+
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		negative = true
+		signs++
+		value = strings.TrimSpace(value[1 : len(value)-1])
+	}
+
+	// Strip the sign and currency from either end, in whichever order
+	// they're written: "-$5", "$-5", "5 €", "5-".
+	markers := 0
+
+	for trimmed := true; trimmed; {
+		trimmed = false
+
+		if sign, rest, ok := cutSign(value, true); ok {
+			negative = negative || sign == '-'
+			value, signs, trimmed = rest, signs+1, true
+		} else if rest, ok := cutCurrencyMarker(value, true); ok {
+			value, markers, trimmed = rest, markers+1, true
+		}
+	}
+
+	for trimmed := true; trimmed; {
+		trimmed = false
+
+		if sign, rest, ok := cutSign(value, false); ok && sign == '-' {
+			negative = true
+			value, signs, trimmed = rest, signs+1, true
+		} else if rest, ok := cutCurrencyMarker(value, false); ok {
+			value, markers, trimmed = rest, markers+1, true
+		}
+	}
+
+	if signs > 1 || markers > 1 || value == "" {
+		return Money{}, invalid
+	}
+
+	// A "12,34" read with a "." decimal separator is refused rather than
+	// taken as 1234: the first group has one to three digits, the rest
+	// exactly three.
+	var digits strings.Builder
+	seenSeparator := false
+	decimals := 0
+	var grouping rune
+	groupDigits := 0
+
+	for _, c := range value {
+		switch {
+		case c >= '0' && c <= '9':
+			digits.WriteRune(c)
+			groupDigits++
+			if seenSeparator {
+				decimals++
+			}
+		case string(c) == decimalSeparator:
+			if seenSeparator || grouping != 0 && groupDigits != 3 {
+				return Money{}, invalid
+			}
+			seenSeparator = true
+		case c == '.', c == ',', c == ' ', c == '\'', c == '\u00a0', c == '\u202f':
+			if seenSeparator || groupDigits == 0 || grouping != 0 && (c != grouping || groupDigits != 3) || groupDigits > 3 {
+				return Money{}, invalid
+			}
+			grouping = c
+			groupDigits = 0
+		default:
+			return Money{}, invalid
+		}
+	}
+
+	if digits.Len() == 0 || grouping != 0 && !seenSeparator && groupDigits != 3 {
+		return Money{}, invalid
+	}
+
+	exponent := CurrencyExponent(currency)
+
+	if decimals > exponent {
+		return Money{}, fmt.Errorf("amount %q has more than %d decimals", s, exponent)
+	}
+
+	for ; decimals < exponent; decimals++ {
+		digits.WriteByte('0')
+	}
+
+	minor, err := strconv.ParseInt(digits.String(), 10, 64)
+
+	if err != nil {
+		return Money{}, fmt.Errorf("amount %q is too large", s)
+	}
+
+	if negative {
+		minor = -minor
+	}
+
+	return Money{Minor: minor, Currency: currency}, nil
+}
+
+// cutSign removes a "+" or "-" from the start or end of s, and the spaces
+// next to it.
+func cutSign(s string, prefix bool) (rune, string, bool) {
+	if s == "" {
+		return 0, s, false
+	}
+
+	i := 0
+	if !prefix {
+		i = len(s) - 1
+	}
+
+	sign := rune(s[i])
+
+	if sign != '-' && sign != '+' {
+		return 0, s, false
+	}
+
+	if prefix {
+		return sign, strings.TrimSpace(s[1:]), true
+	}
+
+	return sign, strings.TrimSpace(s[:i]), true
+}
+
+// cutCurrencyMarker removes a currency symbol or code, such as "$", "US$",
+// "CHF" or "kr", from the start or end of s, and the spaces next to it. Runs
+// of more than three letters are words, not currencies, and are left alone.
+func cutCurrencyMarker(s string, prefix bool) (string, bool) {
+	isMarker := func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.Is(unicode.Sc, r)
+	}
+
+	var marker string
+
+	if prefix {
+		marker = s[:len(s)-len(strings.TrimLeftFunc(s, isMarker))]
+	} else {
+		marker = s[len(strings.TrimRightFunc(s, isMarker)):]
+	}
+
+	letters := 0
+
+	for _, r := range marker {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+
+	if marker == "" || letters > 3 {
+		return s, false
+	}
+
+	if prefix {
+		return strings.TrimSpace(s[len(marker):]), true
+	}
+
+	return strings.TrimSpace(s[:len(s)-len(marker)]), true
+}
+
+// In returns the amount in the currency: a decoded decimal string is turned
+// into the currency's minor units, and minor units, such as those read by
+// Scan, are given the currency. Money that already has a different currency is refused; changing
+// currencies takes an exchange rate, see Convert.
+func (m Money) In(currency string) (Money, error) {
+	if m.pending != nil {
+		exponent := CurrencyExponent(currency)
+		scaled := new(big.Rat).Mul(m.pending, exponentRat(exponent))
+
+		if !scaled.IsInt() {
+			return Money{}, fmt.Errorf("amount %s has more than %d decimals", m, exponent)
+		}
+
+		if !scaled.Num().IsInt64() {
+			return Money{}, fmt.Errorf("amount %s is too large", m)
+		}
+
+		return Money{Minor: scaled.Num().Int64(), Currency: currency}, nil
+	}
+
+	if m.Currency != "" && m.Currency != currency {
+		return Money{}, fmt.Errorf("amount is in %s, not %s", m.Currency, currency)
+	}
+
+	return Money{Minor: m.Minor, Currency: currency}, nil
+}
+
+// Rebase returns the same decimal amount in another currency, e.g. 12.00 CAD
+// as 12 JPY. It fails if the other currency's minor unit is too coarse for
+// the amount.
+func (m Money) Rebase(currency string) (Money, error) {
+	units := new(big.Rat).Quo(big.NewRat(m.Minor, 1), exponentRat(CurrencyExponent(m.Currency)))
+	return Money{pending: units}.In(currency)
+}
+
+// Add returns the sum of two amounts in the same currency, failing rather
+// than overflowing.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("can't add %s to %s", o.Currency, m.Currency)
+	}
+
+	if (o.Minor > 0 && m.Minor > math.MaxInt64-o.Minor) || (o.Minor < 0 && m.Minor < math.MinInt64-o.Minor) {
+		return Money{}, fmt.Errorf("amount is too large")
+	}
+
+	return Money{Minor: m.Minor + o.Minor, Currency: m.Currency}, nil
+}
+
+// Sub returns the difference of two amounts in the same currency, failing
+// rather than overflowing.
+func (m Money) Sub(o Money) (Money, error) {
+	negated, err := o.Neg()
+
+	if err != nil {
+		return Money{}, err
+	}
+
+	return m.Add(negated)
+}
+
+func (m Money) Neg() (Money, error) {
+	if m.Minor == math.MinInt64 {
+		return Money{}, fmt.Errorf("amount is too large")
+	}
+
+	return Money{Minor: -m.Minor, Currency: m.Currency}, nil
+}
+
+// Sign returns -1, 0 or +1 as the amount is negative, zero or positive.
+func (m Money) Sign() int {
+	if m.pending != nil {
+		return m.pending.Sign()
+	}
+
+	switch {
+	case m.Minor < 0:
+		return -1
+	case m.Minor > 0:
+		return 1
+	}
+
+	return 0
+}
+
+func (m Money) IsZero() bool {
+	return m.Sign() == 0
+}
+
+// Convert returns the amount in another currency, rate being how many units
+// of to one unit of the amount's currency is worth. The result is rounded half
+// away from zero to the minor unit of to.
+func (m Money) Convert(to string, rate *big.Rat) (Money, error) {
+	units := new(big.Rat).Quo(big.NewRat(m.Minor, 1), exponentRat(CurrencyExponent(m.Currency)))
+	units.Mul(units, rate)
+
+	minor, ok := scaleMinor(units, CurrencyExponent(to))
+
+	if !ok {
+		return Money{}, fmt.Errorf("converted amount is too large")
+	}
+
+	return Money{Minor: minor, Currency: to}, nil
+}
+
+// String writes the amount as a decimal, e.g. "-12.34", with as many decimals
+// as the currency's minor unit.
+func (m Money) String() string {
+	if m.pending != nil {
+		s := m.pending.FloatString(maxMoneyDigits)
+		s = strings.TrimRight(s, "0")
+		return strings.TrimSuffix(s, ".")
+	}
+
+	exponent := CurrencyExponent(m.Currency)
+	digits := strconv.FormatInt(m.Minor, 10)
+	sign := ""
+
+	if m.Minor < 0 {
+		sign = "-"
+		digits = digits[1:]
+	}
+
+	if exponent == 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+func (m *Money) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+
+	var s string
+
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("amount %s must be a decimal string such as \"12.34\"", b)
+	}
+
+	s = strings.TrimSpace(s)
+
+	if len(s) > maxMoneyDigits || !decimalPattern.MatchString(s) {
+		return fmt.Errorf("amount %q must be a decimal such as \"12.34\"", s)
+	}
+
+	pending, _ := new(big.Rat).SetString(s)
+	*m = Money{pending: pending}
+
+	return nil
+}
+
+// Scan reads minor units from an amount column. The currency is set by the
+// repo from the row's currency.
+func (m *Money) Scan(value any) error {
+	switch v := value.(type) {
+	case int64:
+		*m = Money{Minor: v}
+	case []byte:
+		return m.Scan(string(v))
+	case string:
+		minor, err := strconv.ParseInt(v, 10, 64)
+
+		if err != nil {
+			return fmt.Errorf("invalid amount %q", v)
+		}
+
+		*m = Money{Minor: minor}
+	default:
+		return fmt.Errorf("can't scan %T into an amount", value)
+	}
+
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	if m.pending != nil {
+		return nil, fmt.Errorf("amount %s has no currency", m)
+	}
+
+	return m.Minor, nil
+}
+
+// exponentRat returns 10^exponent.
+func exponentRat(exponent int) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil))
+}
+
+// scaleMinor multiplies x by 10^exponent, which may be negative, and rounds it
+// half away from zero. ok is false if the result doesn't fit an int64.
+func scaleMinor(x *big.Rat, exponent int) (int64, bool) {
+	scaled := new(big.Rat).Set(x)
+
+	if exponent >= 0 {
+		scaled.Mul(scaled, exponentRat(exponent))
+	} else {
+		scaled.Quo(scaled, exponentRat(-exponent))
+	}
+
+	// Rounding half away from zero is truncating |x| + 1/2.
+	abs := new(big.Rat).Abs(scaled)
+	abs.Add(abs, big.NewRat(1, 2))
+
+	rounded := new(big.Int).Quo(abs.Num(), abs.Denom())
+
+	if scaled.Sign() < 0 {
+		rounded.Neg(rounded)
+	}
+
+	if !rounded.IsInt64() {
+		return 0, false
+	}
+
+	return rounded.Int64(), true
+}
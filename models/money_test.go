@@ -0,0 +1,185 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		s                string
+		currency         string
+		decimalSeparator string
+		want             int64
+	}{
+		{"12.34", "CAD", ".", 1234},
+		{"-12.3", "CAD", ".", -1230},
+		{"+5", "CAD", ".", 500},
+		{"1,234.56", "CAD", ".", 123456},
+		{"1,234,567.89", "CAD", ".", 123456789},
+		{"12,345", "CAD", ".", 1234500},
+		{"1.234,56", "EUR", ",", 123456},
+		{"1 234,56", "EUR", ",", 123456},
+		{"1'234.56", "CHF", ".", 123456},
+		{".5", "CAD", ".", 50},
+		{"(45.00)", "CAD", ".", -4500},
+		{"($45.00)", "CAD", ".", -4500},
+		{"45.00-", "CAD", ".", -4500},
+		{"$12.34", "CAD", ".", 1234},
+		{"-$12.34", "CAD", ".", -1234},
+		{"$-12.34", "CAD", ".", -1234},
+		{"US$ 12.34", "USD", ".", 1234},
+		{"1.234,56 €", "EUR", ",", 123456},
+		{"-1.234,56 €", "EUR", ",", -123456},
+		{"12.34 CAD", "CAD", ".", 1234},
+		{"CHF 12.34", "CHF", ".", 1234},
+		{"99 kr", "SEK", ",", 9900},
+		{"¥1,234", "JPY", ".", 1234},
+		{"1.234", "KWD", ".", 1234},
+		{"-0.125", "BHD", ".", -125},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseMoney(tt.s, tt.currency, tt.decimalSeparator)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Minor != tt.want || got.Currency != tt.currency {
+				t.Errorf("ParseMoney(%q) = %d %s, want %d %s", tt.s, got.Minor, got.Currency, tt.want, tt.currency)
+			}
+		})
+	}
+}
+
+func TestParseMoneyRejects(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"-",
+		"$",
+		"1e5",
+		"12abc34",
+		"--5",
+		"-(5)",
+		"+-5",
+		"-5-",
+		"5+",
+		"$5 CAD",
+		"5 dollars",
+		"1.2.3",
+		",5,",
+		"12.345",
+		"12,34",
+		"1,5",
+		"1 2",
+		"1,23,456.00",
+		"1234,567",
+		"1,234 567",
+		"1,234,56",
+		"1,234.5,6",
+	} {
+		if got, err := ParseMoney(s, "CAD", "."); err == nil {
+			t.Errorf("ParseMoney(%q) = %d, want an error", s, got.Minor)
+		}
+	}
+
+	for _, s := range []string{
+		"1.5",
+		"12.34",
+		"1.234.56",
+		"1.234 567,00",
+	} {
+		if got, err := ParseMoney(s, "EUR", ","); err == nil {
+			t.Errorf("ParseMoney(%q) with a \",\" separator = %d, want an error", s, got.Minor)
+		}
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json     string
+		currency string
+		want     int64
+	}{
+		{`"12.34"`, "CAD", 1234},
+		{`"-12.3"`, "CAD", -1230},
+		{`"5"`, "CAD", 500},
+		{`" 0.5 "`, "CAD", 50},
+		{`"1234"`, "JPY", 1234},
+		{`"1.234"`, "KWD", 1234},
+		{`null`, "CAD", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.json, func(t *testing.T) {
+			var m Money
+
+			if err := json.Unmarshal([]byte(tt.json), &m); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := m.In(tt.currency)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Minor != tt.want || got.Currency != tt.currency {
+				t.Errorf("%s in %s = %d %s, want %d %s", tt.json, tt.currency, got.Minor, got.Currency, tt.want, tt.currency)
+			}
+		})
+	}
+}
+
+func TestMoneyUnmarshalJSONRejects(t *testing.T) {
+	for _, s := range []string{
+		`1234`,
+		`12.34`,
+		`-5`,
+		`true`,
+		`""`,
+		`"12,34"`,
+		`"$12.34"`,
+		`"1e5"`,
+		`{"minor": 1234}`,
+	} {
+		var m Money
+
+		if err := json.Unmarshal([]byte(s), &m); err == nil {
+			t.Errorf("unmarshaling %s = %s, want an error", s, m)
+		}
+	}
+}
+
+func TestMoneyJSONRoundTrip(t *testing.T) {
+	for _, m := range []Money{
+		NewMoney(1234, "CAD"),
+		NewMoney(-5, "CAD"),
+		NewMoney(1234, "JPY"),
+		NewMoney(-1234, "BHD"),
+	} {
+		b, err := json.Marshal(m)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded Money
+
+		if err := json.Unmarshal(b, &decoded); err != nil {
+			t.Fatalf("unmarshaling %s: %v", b, err)
+		}
+
+		got, err := decoded.In(m.Currency)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != m {
+			t.Errorf("%s round-tripped through %s as %d %s", m, b, got.Minor, got.Currency)
+		}
+	}
+}
@@ -0,0 +1,480 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+type PayeesRepo struct {
+	DB *sql.DB
+}
+
+const payeeSelect = `
+SELECT p.id,
+	p.userid,
+	p.ledger,
+	p.name,
+	p.aliases,
+	p.patterns,
+	COALESCE(categories.name, ''),
+	p.default_category,
+	p.created_at,
+	p.updated_at,
+	p.deleted_at
+FROM payees p
+LEFT JOIN categories
+ON categories.id = p.default_category`
+
+// NormalizeVendor lowercases a vendor name and reduces it to words of letters
+// and digits, so that punctuation and spacing differences between statements
+// don't matter.
+func NormalizeVendor(vendor string) string {
+	words := strings.FieldsFunc(strings.ToLower(vendor), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	return strings.Join(words, " ")
+}
+
+// Validate checks that the payee has a name and that its patterns compile.
+func (p *Payee) Validate() error {
+	if strings.TrimSpace(p.Name) == "" {
+		return fmt.Errorf("payee name is required")
+	}
+
+	p.patterns = nil
+
+	return p.compile()
+}
+
+func (p *Payee) compile() error {
+	if p.patterns != nil {
+		return nil
+	}
+
+	patterns := []*regexp.Regexp{}
+
+	for _, pattern := range p.Patterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+
+		if err != nil {
+			return fmt.Errorf("invalid payee pattern %q: %w", pattern, err)
+		}
+
+		patterns = append(patterns, re)
+	}
+
+	p.patterns = patterns
+
+	return nil
+}
+
+// names returns the normalized name and aliases of the payee.
+func (p *Payee) names() []string {
+	names := []string{NormalizeVendor(p.Name)}
+
+	for _, alias := range p.Aliases {
+		names = append(names, NormalizeVendor(alias))
+	}
+
+	return names
+}
+
+// MatchPayee finds the payee for a vendor. A name or alias match wins over a
+// pattern match; among patterns the first payee in the list wins.
+func MatchPayee(payees []*Payee, vendor string) *Payee {
+	normalized := NormalizeVendor(vendor)
+
+	if normalized == "" {
+		return nil
+	}
+
+	for _, p := range payees {
+		for _, name := range p.names() {
+			if name == normalized {
+				return p
+			}
+		}
+	}
+
+	for _, p := range payees {
+		if p.compile() != nil {
+			continue
+		}
+
+		for _, re := range p.patterns {
+			if re.MatchString(vendor) {
+				return p
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *PayeesRepo) Find(userId, ledgerId string) ([]*Payee, error) {
+	query := payeeSelect + `
+WHERE p.deleted_at IS NULL
+AND p.userid = $1
+AND p.ledger = $2
+ORDER BY p.name ASC`
+
+	rows, err := r.DB.Query(query, userId, ledgerId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	payees := []*Payee{}
+
+	for rows.Next() {
+		payee, err := scanIntoPayee(rows)
+		if err != nil {
+			return nil, err
+		}
+		payees = append(payees, payee)
+	}
+
+	rows.Close()
+
+	return payees, nil
+}
+
+func (r *PayeesRepo) FindOne(p *Payee) (*Payee, error) {
+	query := payeeSelect + `
+WHERE p.deleted_at IS NULL
+AND p.id = $1`
+
+	if p.ID == "" {
+		return nil, fmt.Errorf("you must provide an id")
+	}
+
+	payee, err := scanIntoPayee(r.DB.QueryRow(query, p.ID))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("payee with id not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return payee, nil
+}
+
+func (r *PayeesRepo) Exists(p *Payee) bool {
+	f, err := r.FindOne(p)
+
+	if err != nil {
+		return false
+	}
+
+	return f != nil && f.ID != ""
+}
+
+// Save creates or updates the payee and relinks the ledger's transactions, so
+// that history follows changes to names, aliases and patterns.
+func (r *PayeesRepo) Save(p *Payee) (*Payee, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	if p.Aliases == nil {
+		p.Aliases = []string{}
+	}
+
+	if p.Patterns == nil {
+		p.Patterns = []string{}
+	}
+
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	if p.ID != "" && r.Exists(p) {
+		err = updatePayee(tx, p)
+	} else {
+		err = insertPayee(tx, p)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := relinkPayees(tx, p.UserID, p.LedgerID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Delete removes the payee and relinks its transactions to whichever payee
+// now matches them, if any.
+func (r *PayeesRepo) Delete(p *Payee) error {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`UPDATE payees SET deleted_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $1`, p.ID)
+
+	if err != nil {
+		return err
+	}
+
+	if err := relinkPayees(tx, p.UserID, p.LedgerID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Merge folds the other payees into p: their names, aliases and patterns
+// become p's, they are removed, and their transactions move to p.
+func (r *PayeesRepo) Merge(p *Payee, others []*Payee) (*Payee, error) {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	for _, other := range others {
+		p.Aliases = appendUnique(p.Aliases, other.Name)
+		p.Aliases = appendUnique(p.Aliases, other.Aliases...)
+		p.Patterns = appendUnique(p.Patterns, other.Patterns...)
+
+		if !p.DefaultCategoryID.Valid {
+			p.DefaultCategoryID = other.DefaultCategoryID
+		}
+
+		_, err := tx.Exec(`UPDATE payees SET deleted_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $1`, other.ID)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := updatePayee(tx, p); err != nil {
+		return nil, err
+	}
+
+	if err := relinkPayees(tx, p.UserID, p.LedgerID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Split moves some of p's aliases and patterns to a new payee, taking the
+// transactions they match along.
+func (r *PayeesRepo) Split(p *Payee, split *Payee) (*Payee, error) {
+	if err := split.Validate(); err != nil {
+		return nil, err
+	}
+
+	p.Aliases = removeAll(p.Aliases, split.Aliases)
+	p.Patterns = removeAll(p.Patterns, split.Patterns)
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	if err := updatePayee(tx, p); err != nil {
+		return nil, err
+	}
+
+	if err := insertPayee(tx, split); err != nil {
+		return nil, err
+	}
+
+	if err := relinkPayees(tx, p.UserID, p.LedgerID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return split, nil
+}
+
+// relinkPayees points every transaction of the ledger at the payee its vendor
+// matches now. Transfers are never linked.
+func relinkPayees(db dbtx, userId, ledgerId string) error {
+	rows, err := db.Query(payeeSelect+`
+WHERE p.deleted_at IS NULL
+AND p.userid = $1
+AND p.ledger = $2
+ORDER BY p.name ASC`, userId, ledgerId)
+
+	if err != nil {
+		return err
+	}
+
+	payees := []*Payee{}
+
+	for rows.Next() {
+		payee, err := scanIntoPayee(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		payees = append(payees, payee)
+	}
+
+	rows.Close()
+
+	rows, err = db.Query(`SELECT id, vendor, COALESCE(payee::text, '') FROM transactions
+	WHERE deleted_at IS NULL AND transfer IS NULL AND ledger = $1`, ledgerId)
+
+	if err != nil {
+		return err
+	}
+
+	changes := map[string]OptionalString{}
+
+	for rows.Next() {
+		var id, vendor, current string
+
+		if err := rows.Scan(&id, &vendor, &current); err != nil {
+			rows.Close()
+			return err
+		}
+
+		payee := OptionalString{}
+
+		if match := MatchPayee(payees, vendor); match != nil {
+			payee = OptionalString{match.ID, true}
+		}
+
+		if payee.String != current {
+			changes[id] = payee
+		}
+	}
+
+	rows.Close()
+
+	for id, payee := range changes {
+		_, err := db.Exec(`UPDATE transactions SET payee = $1 WHERE id = $2`, payee, id)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func insertPayee(db dbtx, p *Payee) error {
+	query := `INSERT INTO payees (userid, ledger, name, aliases, patterns, default_category)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at, deleted_at`
+
+	return db.QueryRow(query, p.UserID, p.LedgerID, p.Name, pq.Array(p.Aliases), pq.Array(p.Patterns), p.DefaultCategoryID).
+		Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt)
+}
+
+func updatePayee(db dbtx, p *Payee) error {
+	query := `UPDATE payees SET
+	name = $1,
+	aliases = $2,
+	patterns = $3,
+	default_category = $4,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = $5 RETURNING updated_at`
+
+	return db.QueryRow(query, p.Name, pq.Array(p.Aliases), pq.Array(p.Patterns), p.DefaultCategoryID, p.ID).
+		Scan(&p.UpdatedAt)
+}
+
+func appendUnique(values []string, more ...string) []string {
+	for _, value := range more {
+		found := false
+
+		for _, existing := range values {
+			if strings.EqualFold(existing, value) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+func removeAll(values []string, remove []string) []string {
+	kept := []string{}
+
+	for _, value := range values {
+		found := false
+
+		for _, r := range remove {
+			if strings.EqualFold(value, r) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			kept = append(kept, value)
+		}
+	}
+
+	return kept
+}
+
+func scanIntoPayee(row scanner) (*Payee, error) {
+	p := &Payee{}
+	err := row.Scan(
+		&p.ID,
+		&p.UserID,
+		&p.LedgerID,
+		&p.Name,
+		pq.Array(&p.Aliases),
+		pq.Array(&p.Patterns),
+		&p.DefaultCategory,
+		&p.DefaultCategoryID,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+		&p.DeletedAt,
+	)
+
+	return p, err
+}
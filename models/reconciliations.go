@@ -0,0 +1,353 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Transaction statuses. Reconciled transactions are locked against changes
+// until they're unlocked.
+const (
+	StatusPending    = "pending"
+	StatusCleared    = "cleared"
+	StatusReconciled = "reconciled"
+)
+
+const (
+	ReconciliationOpen      = "open"
+	ReconciliationFinalized = "finalized"
+)
+
+type ReconciliationsRepo struct {
+	DB *sql.DB
+}
+
+const reconciliationSelect = `
+SELECT id,
+	userid,
+	ledger,
+	account,
+	statement_date,
+	ending_balance,
+	status,
+	finalized_at,
+	created_at,
+	updated_at,
+	deleted_at
+FROM reconciliations`
+
+// Locked reports whether the transaction was reconciled and so can't be
+// changed.
+func (t *Transaction) Locked() bool {
+	return t.Status == StatusReconciled
+}
+
+// Find returns the ledger's reconciliations, latest statement first,
+// optionally only those of one account.
+func (r *ReconciliationsRepo) Find(userId, ledgerId, accountId string) ([]*Reconciliation, error) {
+	query := reconciliationSelect + `
+WHERE deleted_at IS NULL
+AND userid = $1
+AND ledger = $2
+AND ($3 = '' OR account::text = $3)
+ORDER BY statement_date DESC, created_at DESC`
+
+	rows, err := r.DB.Query(query, userId, ledgerId, accountId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	reconciliations := []*Reconciliation{}
+
+	for rows.Next() {
+		rec, err := scanIntoReconciliation(rows)
+		if err != nil {
+			return nil, err
+		}
+		reconciliations = append(reconciliations, rec)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, rec := range reconciliations {
+		if err := computeDifference(r.DB, rec); err != nil {
+			return nil, err
+		}
+	}
+
+	return reconciliations, nil
+}
+
+func (r *ReconciliationsRepo) FindOne(rec *Reconciliation) (*Reconciliation, error) {
+	query := reconciliationSelect + `
+WHERE deleted_at IS NULL
+AND id = $1`
+
+	if rec.ID == "" {
+		return nil, fmt.Errorf("you must provide an id")
+	}
+
+	found, err := scanIntoReconciliation(r.DB.QueryRow(query, rec.ID))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("reconciliation with id not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := computeDifference(r.DB, found); err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+func (r *ReconciliationsRepo) Create(rec *Reconciliation) (*Reconciliation, error) {
+	query := `INSERT INTO reconciliations (userid, ledger, account, statement_date, ending_balance)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id, status, created_at, updated_at, deleted_at`
+
+	err := r.DB.QueryRow(query, rec.UserID, rec.LedgerID, rec.AccountID, rec.StatementDate, rec.EndingBalance).
+		Scan(&rec.ID, &rec.Status, &rec.CreatedAt, &rec.UpdatedAt, &rec.DeletedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := computeDifference(r.DB, rec); err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+// Delete abandons an open reconciliation. Transactions keep the statuses
+// they were given.
+func (r *ReconciliationsRepo) Delete(id string) error {
+	query := `UPDATE reconciliations SET deleted_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $1`
+
+	_, err := r.DB.Exec(query, id)
+
+	return err
+}
+
+// Finalize marks the account's cleared transactions up to the statement date
+// as reconciled, locking them, and closes the reconciliation. It fails unless
+// the cleared balance matches the statement's ending balance.
+func (r *ReconciliationsRepo) Finalize(rec *Reconciliation) (int, error) {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	var status string
+
+	err = tx.QueryRow(`SELECT status FROM reconciliations WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, rec.ID).Scan(&status)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if status != ReconciliationOpen {
+		return 0, fmt.Errorf("reconciliation is already finalized")
+	}
+
+	if err := computeDifference(tx, rec); err != nil {
+		return 0, err
+	}
+
+	if !rec.Difference.IsZero() {
+		return 0, fmt.Errorf("cleared balance is %s away from the statement's ending balance", rec.Difference)
+	}
+
+	reconciled, err := rowsAffected(tx.Exec(`UPDATE transactions SET
+	status = $1,
+	reconciliation = $2,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE deleted_at IS NULL
+	AND account = $3
+	AND status = $4
+	AND date < $5::timestamp + INTERVAL '1 day'`,
+		StatusReconciled, rec.ID, rec.AccountID, StatusCleared, rec.StatementDate))
+
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.QueryRow(`UPDATE reconciliations SET
+	status = $1,
+	finalized_at = (NOW() AT TIME ZONE 'UTC'),
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = $2 RETURNING status, finalized_at, updated_at`, ReconciliationFinalized, rec.ID).
+		Scan(&rec.Status, &rec.FinalizedAt, &rec.UpdatedAt)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(reconciled), nil
+}
+
+// FindUnreconciled returns the account's transactions dated on or before the
+// given day that haven't been reconciled yet, oldest first.
+func (r *TransactionsRepo) FindUnreconciled(accountId string, through time.Time) ([]*Transaction, error) {
+	query := transactionSelect + `
+WHERE t.deleted_at IS NULL
+AND t.account = $1
+AND t.status <> $2
+AND t.date < $3::timestamp + INTERVAL '1 day'
+ORDER BY t.date ASC, t.created_at ASC`
+
+	return r.find(query, accountId, StatusReconciled, through)
+}
+
+// SetStatus marks the account's transactions as pending or cleared. Every
+// transaction must belong to the account and not be reconciled already.
+func (r *TransactionsRepo) SetStatus(accountId string, ids []string, status string) (int, error) {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	var matched int
+
+	err = tx.QueryRow(`SELECT COUNT(*) FROM (
+	SELECT id FROM transactions
+	WHERE deleted_at IS NULL
+	AND account = $1
+	AND status <> $2
+	AND id = ANY($3::uuid[])
+	FOR UPDATE
+) locked`, accountId, StatusReconciled, pq.Array(ids)).Scan(&matched)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if matched != len(uniqueStrings(ids)) {
+		return 0, fmt.Errorf("transactions must be unreconciled transactions of the account")
+	}
+
+	affected, err := rowsAffected(tx.Exec(`UPDATE transactions SET
+	status = $1,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = ANY($2::uuid[])
+	AND status <> $1`, status, pq.Array(ids)))
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return int(affected), nil
+}
+
+// Unlock takes a reconciled transaction back to cleared so it can be changed
+// again.
+func (r *TransactionsRepo) Unlock(t *Transaction) (*Transaction, error) {
+	query := `UPDATE transactions SET
+	status = $1,
+	reconciliation = NULL,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = $2
+	AND status = $3`
+
+	_, err := r.DB.Exec(query, StatusCleared, t.ID, StatusReconciled)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.FindOne(t)
+}
+
+// computeDifference fills in the cleared balance of the reconciliation and
+// how far it is from the ending balance, in the account's currency as in
+// AccountsRepo.Balance.
+func computeDifference(db dbtx, rec *Reconciliation) error {
+	query := `SELECT a.currency, a.opening_balance + COALESCE(SUM(convert_amount(
+	a.userid,
+	` + signedAmount + `,
+	t.currency,
+	a.currency,
+	t.date
+)), 0)::bigint
+FROM accounts a
+LEFT JOIN transactions t
+ON t.account = a.id
+AND t.deleted_at IS NULL
+AND t.status IN ($2, $3)
+AND t.date < $4::timestamp + INTERVAL '1 day'
+WHERE a.id = $1
+GROUP BY a.currency, a.opening_balance`
+
+	err := db.QueryRow(query, rec.AccountID, StatusCleared, StatusReconciled, rec.StatementDate).
+		Scan(&rec.Currency, &rec.ClearedBalance)
+
+	if err != nil {
+		return err
+	}
+
+	rec.EndingBalance.Currency = rec.Currency
+	rec.ClearedBalance.Currency = rec.Currency
+
+	rec.Difference, err = rec.EndingBalance.Sub(rec.ClearedBalance)
+
+	return err
+}
+
+// uniqueStrings drops repeated values, keeping the first of each.
+func uniqueStrings(values []string) []string {
+	unique := []string{}
+	seen := map[string]bool{}
+
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+
+	return unique
+}
+
+func scanIntoReconciliation(row scanner) (*Reconciliation, error) {
+	rec := &Reconciliation{}
+	err := row.Scan(
+		&rec.ID,
+		&rec.UserID,
+		&rec.LedgerID,
+		&rec.AccountID,
+		&rec.StatementDate,
+		&rec.EndingBalance,
+		&rec.Status,
+		&rec.FinalizedAt,
+		&rec.CreatedAt,
+		&rec.UpdatedAt,
+		&rec.DeletedAt,
+	)
+
+	return rec, err
+}
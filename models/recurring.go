@@ -0,0 +1,284 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type RecurringRepo struct {
+	DB *sql.DB
+}
+
+const recurringSelect = `
+SELECT r.id,
+	r.userid,
+	r.ledger,
+	r.account,
+	categories.name AS category_name,
+	r.category,
+	r.amount,
+	r.currency,
+	r.description,
+	r.vendor,
+	r.type,
+	r.rule,
+	r.start_date,
+	r.active,
+	r.created_at,
+	r.updated_at,
+	r.deleted_at
+FROM recurring r
+JOIN categories
+ON categories.id = r.category`
+
+func (r *RecurringRepo) Find(userId, ledgerId string) ([]*Recurring, error) {
+	query := recurringSelect + `
+WHERE r.deleted_at IS NULL
+AND r.userid = $1
+AND r.ledger = $2
+ORDER BY r.created_at ASC`
+
+	return r.query(query, userId, ledgerId)
+}
+
+// FindActive returns the active schedules of every user, for the scheduler.
+func (r *RecurringRepo) FindActive() ([]*Recurring, error) {
+	query := recurringSelect + `
+WHERE r.deleted_at IS NULL
+AND r.active = TRUE`
+
+	return r.query(query)
+}
+
+func (r *RecurringRepo) FindOne(rec *Recurring) (*Recurring, error) {
+	query := recurringSelect + `
+WHERE r.deleted_at IS NULL
+AND r.id = $1`
+
+	if rec.ID == "" {
+		return nil, fmt.Errorf("you must provide an id")
+	}
+
+	recurring, err := scanIntoRecurring(r.DB.QueryRow(query, rec.ID))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("recurring transaction with id not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return recurring, nil
+}
+
+func (r *RecurringRepo) Exists(rec *Recurring) bool {
+	f, err := r.FindOne(rec)
+
+	if err != nil {
+		return false
+	}
+
+	return f != nil && f.ID != ""
+}
+
+func (r *RecurringRepo) Save(rec *Recurring) (*Recurring, error) {
+	if _, err := ParseRule(rec.Rule); err != nil {
+		return nil, err
+	}
+
+	if rec.ID != "" && r.Exists(rec) {
+		return r.update(rec)
+	}
+	return r.create(rec)
+}
+
+func (r *RecurringRepo) Delete(id string) error {
+	query := `UPDATE recurring SET deleted_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $1`
+
+	_, err := r.DB.Exec(query, id)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Materialized returns the dates (YYYY-MM-DD) of the occurrences of the
+// schedule that have already been turned into transactions, or are being
+// turned into one right now.
+func (r *RecurringRepo) Materialized(id string) (map[string]bool, error) {
+	rows, err := r.DB.Query(`SELECT occurrence FROM recurring_occurrences WHERE recurring = $1`, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	occurrences := map[string]bool{}
+
+	for rows.Next() {
+		var occurrence time.Time
+		if err := rows.Scan(&occurrence); err != nil {
+			return nil, err
+		}
+		occurrences[occurrence.Format("2006-01-02")] = true
+	}
+
+	return occurrences, rows.Err()
+}
+
+// Claim reserves an occurrence of the schedule. It returns false when the
+// occurrence has already been claimed, so each occurrence is only ever
+// materialized once even if several schedulers run at the same time.
+func (r *RecurringRepo) Claim(id string, occurrence time.Time) (bool, error) {
+	query := `INSERT INTO recurring_occurrences (recurring, occurrence)
+	VALUES ($1, $2) ON CONFLICT DO NOTHING`
+
+	result, err := r.DB.Exec(query, id, occurrence)
+
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// Release gives up a claim when the occurrence couldn't be materialized, so
+// that it is retried on the next run.
+func (r *RecurringRepo) Release(id string, occurrence time.Time) error {
+	_, err := r.DB.Exec(`DELETE FROM recurring_occurrences WHERE recurring = $1 AND occurrence = $2`, id, occurrence)
+
+	return err
+}
+
+// Link records the transaction created for a claimed occurrence.
+func (r *RecurringRepo) Link(id string, occurrence time.Time, transactionId string) error {
+	query := `UPDATE recurring_occurrences SET transaction = $1 WHERE recurring = $2 AND occurrence = $3`
+
+	_, err := r.DB.Exec(query, transactionId, id, occurrence)
+
+	return err
+}
+
+func (r *RecurringRepo) query(query string, args ...any) ([]*Recurring, error) {
+	rows, err := r.DB.Query(query, args...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	recurring := []*Recurring{}
+
+	for rows.Next() {
+		rec, err := scanIntoRecurring(rows)
+		if err != nil {
+			return nil, err
+		}
+		recurring = append(recurring, rec)
+	}
+
+	rows.Close()
+
+	return recurring, nil
+}
+
+func (r *RecurringRepo) create(rec *Recurring) (*Recurring, error) {
+	if err := rec.checkCurrency(); err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO recurring (userid, ledger, account, category, amount, currency, description, vendor, type, rule, start_date, active)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id, created_at, updated_at, deleted_at`
+
+	row := r.DB.QueryRow(query, rec.UserID, rec.LedgerID, rec.AccountID, rec.CategoryID, rec.Amount, rec.Currency,
+		rec.Description, rec.Vendor, rec.Type, rec.Rule, rec.StartDate, rec.Active)
+
+	err := row.Scan(&rec.ID, &rec.CreatedAt, &rec.UpdatedAt, &rec.DeletedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rec, nil
+}
+
+func (r *RecurringRepo) update(rec *Recurring) (*Recurring, error) {
+	if err := rec.checkCurrency(); err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE recurring SET
+	account = $1,
+	category = $2,
+	amount = $3,
+	currency = $4,
+	description = $5,
+	vendor = $6,
+	type = $7,
+	rule = $8,
+	start_date = $9,
+	active = $10,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = $11`
+
+	_, err := r.DB.Exec(query, rec.AccountID, rec.CategoryID, rec.Amount, rec.Currency, rec.Description, rec.Vendor,
+		rec.Type, rec.Rule, rec.StartDate, rec.Active, rec.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rec.UpdatedAt = time.Now().UTC()
+
+	return rec, nil
+}
+
+func scanIntoRecurring(row scanner) (*Recurring, error) {
+	rec := &Recurring{}
+
+	err := row.Scan(
+		&rec.ID,
+		&rec.UserID,
+		&rec.LedgerID,
+		&rec.AccountID,
+		&rec.Category,
+		&rec.CategoryID,
+		&rec.Amount,
+		&rec.Currency,
+		&rec.Description,
+		&rec.Vendor,
+		&rec.Type,
+		&rec.Rule,
+		&rec.StartDate,
+		&rec.Active,
+		&rec.CreatedAt,
+		&rec.UpdatedAt,
+		&rec.DeletedAt,
+	)
+
+	rec.Amount.Currency = rec.Currency
+
+	return rec, err
+}
+
+// checkCurrency makes sure the recurring transaction's amount is in a
+// currency, and sets its currency to it.
+func (rec *Recurring) checkCurrency() error {
+	if rec.Amount.Currency == "" {
+		return fmt.Errorf("recurring amount has no currency")
+	}
+
+	rec.Currency = rec.Amount.Currency
+
+	return nil
+}
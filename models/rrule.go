@@ -0,0 +1,307 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule is the subset of an RFC 5545 RRULE that recurring transactions
+// support:
+//
+//	FREQ=DAILY|WEEKLY|MONTHLY|YEARLY
+//	INTERVAL=n
+//	BYMONTHDAY=n[,n...]   negative values count back from the end of the month,
+//	                      for DAILY or MONTHLY
+//	BYDAY=MO,TU,...       weekdays, for DAILY, WEEKLY or MONTHLY
+//	BYSETPOS=n[,n...]     picks from the month's candidates, e.g. -1 for the last
+//	COUNT=n
+//	UNTIL=YYYYMMDD
+//
+// "Last business day of the month" is FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1.
+// As in the RFC, a BYMONTHDAY that doesn't exist in a month is skipped rather
+// than clamped; use BYMONTHDAY=-1 for the last day of the month. BYMONTHDAY
+// and BYDAY together only match days satisfying both, so
+// BYMONTHDAY=-1;BYDAY=MO,TU,WE,TH,FR is the last day of the month when it's a
+// weekday.
+type Rule struct {
+	Freq       string
+	Interval   int
+	ByMonthDay []int
+	ByDay      []time.Weekday
+	BySetPos   []int
+	Count      int
+	Until      time.Time
+}
+
+// maxRulePeriods bounds how far a rule is expanded so a rule that can never
+// produce an occurrence doesn't loop forever.
+const maxRulePeriods = 100000
+
+var ruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+func ParseRule(s string) (*Rule, error) {
+	rule := &Rule{
+		Interval: 1,
+	}
+
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(part, "=")
+
+		if !ok {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+
+		var err error
+
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(value)
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(value)
+			if err == nil && rule.Interval < 1 {
+				err = fmt.Errorf("must be at least 1")
+			}
+		case "BYMONTHDAY":
+			rule.ByMonthDay, err = parseRuleInts(value, 31)
+		case "BYSETPOS":
+			rule.BySetPos, err = parseRuleInts(value, 31)
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				weekday, ok := ruleWeekdays[strings.ToUpper(day)]
+				if !ok {
+					err = fmt.Errorf("unknown weekday %q", day)
+					break
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(value)
+			if err == nil && rule.Count < 1 {
+				err = fmt.Errorf("must be at least 1")
+			}
+		case "UNTIL":
+			rule.Until, err = time.Parse("20060102", value)
+		default:
+			err = fmt.Errorf("unsupported")
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule part %q: %w", part, err)
+		}
+	}
+
+	switch rule.Freq {
+	case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+	case "":
+		return nil, fmt.Errorf("rule must have a FREQ")
+	default:
+		return nil, fmt.Errorf("unsupported FREQ %q", rule.Freq)
+	}
+
+	if len(rule.ByMonthDay) > 0 && (rule.Freq == "WEEKLY" || rule.Freq == "YEARLY") {
+		return nil, fmt.Errorf("BYMONTHDAY isn't supported with FREQ=%s", rule.Freq)
+	}
+
+	if len(rule.ByDay) > 0 && rule.Freq == "YEARLY" {
+		return nil, fmt.Errorf("BYDAY isn't supported with FREQ=YEARLY")
+	}
+
+	return rule, nil
+}
+
+func parseRuleInts(value string, max int) ([]int, error) {
+	ints := []int{}
+
+	for _, v := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(v)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n == 0 || n > max || n < -max {
+			return nil, fmt.Errorf("%d is out of range", n)
+		}
+
+		ints = append(ints, n)
+	}
+
+	return ints, nil
+}
+
+// Occurrences expands the rule from start and returns the occurrences that
+// fall within [from, to], at most limit of them when limit is positive.
+// Occurrences are dates at midnight UTC.
+func (rule *Rule) Occurrences(start, from, to time.Time, limit int) []time.Time {
+	start = truncateToDate(start)
+	from = truncateToDate(from)
+	to = truncateToDate(to)
+
+	if !rule.Until.IsZero() && rule.Until.Before(to) {
+		to = truncateToDate(rule.Until)
+	}
+
+	occurrences := []time.Time{}
+	count := 0
+
+	for period := 0; period < maxRulePeriods; period++ {
+		candidates := rule.candidates(start, period)
+
+		if len(candidates) > 0 && candidates[0].After(to) {
+			break
+		}
+
+		for _, candidate := range candidates {
+			if candidate.Before(start) || candidate.After(to) {
+				continue
+			}
+
+			count++
+
+			if rule.Count > 0 && count > rule.Count {
+				return occurrences
+			}
+
+			if candidate.Before(from) {
+				continue
+			}
+
+			occurrences = append(occurrences, candidate)
+
+			if limit > 0 && len(occurrences) >= limit {
+				return occurrences
+			}
+		}
+	}
+
+	return occurrences
+}
+
+// candidates returns the sorted dates the rule produces in the given period
+// (the n-th day, week, month or year after start).
+func (rule *Rule) candidates(start time.Time, period int) []time.Time {
+	step := period * rule.Interval
+	candidates := []time.Time{}
+
+	switch rule.Freq {
+	case "DAILY":
+		day := start.AddDate(0, 0, step)
+
+		if rule.matches(day) {
+			candidates = append(candidates, day)
+		}
+	case "WEEKLY":
+		offset := (int(start.Weekday()) + 6) % 7
+		monday := start.AddDate(0, 0, -offset+7*step)
+
+		if len(rule.ByDay) == 0 {
+			candidates = append(candidates, monday.AddDate(0, 0, offset))
+		}
+
+		for i := 0; i < 7 && len(rule.ByDay) > 0; i++ {
+			day := monday.AddDate(0, 0, i)
+			if containsWeekday(rule.ByDay, day.Weekday()) {
+				candidates = append(candidates, day)
+			}
+		}
+	case "MONTHLY":
+		first := time.Date(start.Year(), start.Month()+time.Month(step), 1, 0, 0, 0, 0, time.UTC)
+		days := first.AddDate(0, 1, -1).Day()
+
+		if len(rule.ByMonthDay) == 0 && len(rule.ByDay) == 0 {
+			if start.Day() <= days {
+				candidates = append(candidates, first.AddDate(0, 0, start.Day()-1))
+			}
+			break
+		}
+
+		for i := 0; i < days; i++ {
+			if day := first.AddDate(0, 0, i); rule.matches(day) {
+				candidates = append(candidates, day)
+			}
+		}
+	case "YEARLY":
+		day := time.Date(start.Year()+step, start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+
+		if day.Day() == start.Day() {
+			candidates = append(candidates, day)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Before(candidates[j])
+	})
+
+	if len(rule.BySetPos) == 0 || len(candidates) == 0 {
+		return candidates
+	}
+
+	picked := []time.Time{}
+
+	for _, pos := range rule.BySetPos {
+		if pos < 0 {
+			pos = len(candidates) + pos + 1
+		}
+		if pos >= 1 && pos <= len(candidates) {
+			picked = append(picked, candidates[pos-1])
+		}
+	}
+
+	sort.Slice(picked, func(i, j int) bool {
+		return picked[i].Before(picked[j])
+	})
+
+	return picked
+}
+
+// matches reports whether the day satisfies the rule's BYMONTHDAY and BYDAY,
+// each of which holds when unset.
+func (rule *Rule) matches(day time.Time) bool {
+	if len(rule.ByDay) > 0 && !containsWeekday(rule.ByDay, day.Weekday()) {
+		return false
+	}
+
+	if len(rule.ByMonthDay) == 0 {
+		return true
+	}
+
+	days := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+
+	for _, n := range rule.ByMonthDay {
+		if n == day.Day() || days+n+1 == day.Day() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsWeekday(weekdays []time.Weekday, weekday time.Weekday) bool {
+	for _, w := range weekdays {
+		if w == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+func truncateToDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
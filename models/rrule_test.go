@@ -0,0 +1,95 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRuleOccurrences(t *testing.T) {
+	tests := []struct {
+		rule  string
+		start string
+		want  []string
+	}{
+		{
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=-1;BYDAY=MO,TU,WE,TH,FR",
+			start: "2024-01-01",
+			want:  []string{"2024-01-31", "2024-02-29", "2024-04-30", "2024-05-31"},
+		},
+		{
+			rule:  "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1",
+			start: "2024-01-01",
+			want:  []string{"2024-01-31", "2024-02-29", "2024-03-29", "2024-04-30", "2024-05-31", "2024-06-28"},
+		},
+		{
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=13;BYDAY=FR",
+			start: "2024-01-01",
+			want:  []string{"2024-09-13", "2024-12-13"},
+		},
+		{
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=31,-1",
+			start: "2024-01-01",
+			want:  []string{"2024-01-31", "2024-02-29", "2024-03-31", "2024-04-30", "2024-05-31", "2024-06-30"},
+		},
+		{
+			rule:  "FREQ=DAILY;BYMONTHDAY=1,15",
+			start: "2024-01-01",
+			want:  []string{"2024-01-01", "2024-01-15", "2024-02-01", "2024-02-15"},
+		},
+		{
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=30",
+			start: "2024-01-01",
+			want:  []string{"2024-01-30", "2024-03-30", "2024-04-30", "2024-05-30", "2024-06-30"},
+		},
+	}
+
+	from := date("2024-01-01")
+	to := date("2024-12-31")
+
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			rule, err := ParseRule(tt.rule)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := rule.Occurrences(date(tt.start), from, to, len(tt.want))
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d occurrences %v, want %v", len(got), got, tt.want)
+			}
+
+			for i := range got {
+				if got[i].Format("2006-01-02") != tt.want[i] {
+					t.Errorf("occurrence %d = %s, want %s", i, got[i].Format("2006-01-02"), tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseRuleRejectsUnsupportedParts(t *testing.T) {
+	for _, rule := range []string{
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=YEARLY;BYMONTHDAY=1",
+		"FREQ=YEARLY;BYDAY=MO",
+		"FREQ=MONTHLY;BYMONTHDAY=0",
+		"FREQ=MONTHLY;BYDAY=XX",
+		"BYDAY=MO",
+	} {
+		if _, err := ParseRule(rule); err == nil {
+			t.Errorf("ParseRule(%q) succeeded", rule)
+		}
+	}
+}
+
+func date(s string) time.Time {
+	d, err := time.Parse("2006-01-02", s)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return d
+}
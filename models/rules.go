@@ -0,0 +1,370 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+const (
+	MatchContains = "contains"
+	MatchRegex    = "regex"
+)
+
+// RuleResult is what the matching rules of a transaction want to change.
+// CategoryID and Vendor are set by the first matching rule that sets them;
+// Tags collect the tags of every matching rule.
+type RuleResult struct {
+	RuleIDs    []string       `json:"rule_ids"`
+	CategoryID OptionalString `json:"category_id"`
+	Vendor     OptionalString `json:"vendor"`
+	Tags       []string       `json:"tags"`
+}
+
+type RulesRepo struct {
+	DB *sql.DB
+}
+
+const ruleSelect = `
+SELECT id,
+	userid,
+	ledger,
+	name,
+	priority,
+	active,
+	vendor_match,
+	vendor_pattern,
+	description_match,
+	description_pattern,
+	amount_min,
+	amount_max,
+	currency,
+	account,
+	type,
+	set_category,
+	rename_vendor,
+	add_tags,
+	created_at,
+	updated_at,
+	deleted_at
+FROM rules`
+
+// Validate checks that the rule has at least one condition and one action,
+// and that its patterns compile.
+func (rule *TransactionRule) Validate() error {
+	if strings.TrimSpace(rule.Name) == "" {
+		return fmt.Errorf("rule name is required")
+	}
+
+	for _, match := range []string{rule.VendorMatch, rule.DescriptionMatch} {
+		if match != "" && match != MatchContains && match != MatchRegex {
+			return fmt.Errorf("match must be %s or %s", MatchContains, MatchRegex)
+		}
+	}
+
+	for _, amount := range []*Money{rule.AmountMin, rule.AmountMax} {
+		if amount != nil && amount.Currency != rule.Currency {
+			return fmt.Errorf("rule amounts must be in %s", rule.Currency)
+		}
+	}
+
+	if rule.AmountMin != nil && rule.AmountMax != nil && rule.AmountMin.Minor > rule.AmountMax.Minor {
+		return fmt.Errorf("amount_min is greater than amount_max")
+	}
+
+	hasCondition := rule.VendorMatch != "" || rule.DescriptionMatch != "" || rule.AmountMin != nil ||
+		rule.AmountMax != nil || rule.AccountID.Valid || rule.Type.Valid
+
+	if !hasCondition {
+		return fmt.Errorf("rule needs at least one condition")
+	}
+
+	if !rule.SetCategoryID.Valid && !rule.RenameVendor.Valid && len(rule.AddTags) == 0 {
+		return fmt.Errorf("rule needs at least one action")
+	}
+
+	return rule.compile()
+}
+
+func (rule *TransactionRule) compile() error {
+	var err error
+
+	if rule.VendorMatch == MatchRegex && rule.vendorRegex == nil {
+		if rule.vendorRegex, err = regexp.Compile("(?i)" + rule.VendorPattern); err != nil {
+			return fmt.Errorf("invalid vendor pattern: %w", err)
+		}
+	}
+
+	if rule.DescriptionMatch == MatchRegex && rule.descriptionRegex == nil {
+		if rule.descriptionRegex, err = regexp.Compile("(?i)" + rule.DescriptionPattern); err != nil {
+			return fmt.Errorf("invalid description pattern: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Matches reports whether every condition of the rule holds for t. Amount
+// bounds only hold for transactions in the rule's currency.
+func (rule *TransactionRule) Matches(t *Transaction) bool {
+	if rule.compile() != nil {
+		return false
+	}
+
+	if !matchText(rule.VendorMatch, rule.VendorPattern, rule.vendorRegex, t.Vendor) {
+		return false
+	}
+
+	if !matchText(rule.DescriptionMatch, rule.DescriptionPattern, rule.descriptionRegex, t.Description.String) {
+		return false
+	}
+
+	if (rule.AmountMin != nil || rule.AmountMax != nil) && t.Amount.Currency != rule.Currency {
+		return false
+	}
+
+	if rule.AmountMin != nil && t.Amount.Minor < rule.AmountMin.Minor {
+		return false
+	}
+
+	if rule.AmountMax != nil && t.Amount.Minor > rule.AmountMax.Minor {
+		return false
+	}
+
+	if rule.AccountID.Valid && rule.AccountID.String != t.AccountID.String {
+		return false
+	}
+
+	if rule.Type.Valid && rule.Type.String != t.Type {
+		return false
+	}
+
+	return true
+}
+
+func matchText(match, pattern string, re *regexp.Regexp, value string) bool {
+	switch match {
+	case MatchContains:
+		return strings.Contains(strings.ToLower(value), strings.ToLower(pattern))
+	case MatchRegex:
+		return re.MatchString(value)
+	}
+	return true
+}
+
+// ApplyRules runs the active rules, in the order given, against t and
+// returns what they want to change. Conditions are checked against t as it
+// was passed in, so a rule renaming the vendor doesn't affect later rules.
+func ApplyRules(rules []*TransactionRule, t *Transaction) *RuleResult {
+	result := &RuleResult{
+		RuleIDs: []string{},
+		Tags:    []string{},
+	}
+
+	seen := map[string]bool{}
+
+	for _, rule := range rules {
+		if !rule.Active || !rule.Matches(t) {
+			continue
+		}
+
+		result.RuleIDs = append(result.RuleIDs, rule.ID)
+
+		if rule.SetCategoryID.Valid && !result.CategoryID.Valid {
+			result.CategoryID = rule.SetCategoryID
+		}
+
+		if rule.RenameVendor.Valid && !result.Vendor.Valid {
+			result.Vendor = rule.RenameVendor
+		}
+
+		for _, tag := range rule.AddTags {
+			if !seen[strings.ToLower(tag)] {
+				seen[strings.ToLower(tag)] = true
+				result.Tags = append(result.Tags, tag)
+			}
+		}
+	}
+
+	return result
+}
+
+// Matched reports whether any rule matched.
+func (result *RuleResult) Matched() bool {
+	return len(result.RuleIDs) > 0
+}
+
+// Find returns the ledger's rules in the order they run.
+func (r *RulesRepo) Find(userId, ledgerId string) ([]*TransactionRule, error) {
+	query := ruleSelect + `
+WHERE deleted_at IS NULL
+AND userid = $1
+AND ledger = $2
+ORDER BY priority ASC, created_at ASC`
+
+	rows, err := r.DB.Query(query, userId, ledgerId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []*TransactionRule{}
+
+	for rows.Next() {
+		rule, err := scanIntoRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+
+	rows.Close()
+
+	return rules, nil
+}
+
+func (r *RulesRepo) FindOne(rule *TransactionRule) (*TransactionRule, error) {
+	query := ruleSelect + `
+WHERE deleted_at IS NULL
+AND id = $1`
+
+	if rule.ID == "" {
+		return nil, fmt.Errorf("you must provide an id")
+	}
+
+	found, err := scanIntoRule(r.DB.QueryRow(query, rule.ID))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("rule with id not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+func (r *RulesRepo) Exists(rule *TransactionRule) bool {
+	f, err := r.FindOne(rule)
+
+	if err != nil {
+		return false
+	}
+
+	return f != nil && f.ID != ""
+}
+
+func (r *RulesRepo) Save(rule *TransactionRule) (*TransactionRule, error) {
+	if err := rule.Validate(); err != nil {
+		return nil, err
+	}
+
+	if rule.AddTags == nil {
+		rule.AddTags = []string{}
+	}
+
+	if rule.ID != "" && r.Exists(rule) {
+		return r.update(rule)
+	}
+	return r.create(rule)
+}
+
+func (r *RulesRepo) Delete(id string) error {
+	query := `UPDATE rules SET deleted_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $1`
+
+	_, err := r.DB.Exec(query, id)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (r *RulesRepo) create(rule *TransactionRule) (*TransactionRule, error) {
+	query := `INSERT INTO rules (userid, ledger, name, priority, active, vendor_match, vendor_pattern, description_match,
+	description_pattern, amount_min, amount_max, currency, account, type, set_category, rename_vendor, add_tags)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17) RETURNING id, created_at, updated_at, deleted_at`
+
+	row := r.DB.QueryRow(query, rule.UserID, rule.LedgerID, rule.Name, rule.Priority, rule.Active, rule.VendorMatch,
+		rule.VendorPattern, rule.DescriptionMatch, rule.DescriptionPattern, rule.AmountMin, rule.AmountMax,
+		rule.Currency, rule.AccountID, rule.Type, rule.SetCategoryID, rule.RenameVendor, pq.Array(rule.AddTags))
+
+	err := row.Scan(&rule.ID, &rule.CreatedAt, &rule.UpdatedAt, &rule.DeletedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+func (r *RulesRepo) update(rule *TransactionRule) (*TransactionRule, error) {
+	query := `UPDATE rules SET
+	name = $1,
+	priority = $2,
+	active = $3,
+	vendor_match = $4,
+	vendor_pattern = $5,
+	description_match = $6,
+	description_pattern = $7,
+	amount_min = $8,
+	amount_max = $9,
+	currency = $10,
+	account = $11,
+	type = $12,
+	set_category = $13,
+	rename_vendor = $14,
+	add_tags = $15,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = $16`
+
+	_, err := r.DB.Exec(query, rule.Name, rule.Priority, rule.Active, rule.VendorMatch, rule.VendorPattern,
+		rule.DescriptionMatch, rule.DescriptionPattern, rule.AmountMin, rule.AmountMax, rule.Currency, rule.AccountID,
+		rule.Type, rule.SetCategoryID, rule.RenameVendor, pq.Array(rule.AddTags), rule.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+func scanIntoRule(row scanner) (*TransactionRule, error) {
+	rule := &TransactionRule{}
+	err := row.Scan(
+		&rule.ID,
+		&rule.UserID,
+		&rule.LedgerID,
+		&rule.Name,
+		&rule.Priority,
+		&rule.Active,
+		&rule.VendorMatch,
+		&rule.VendorPattern,
+		&rule.DescriptionMatch,
+		&rule.DescriptionPattern,
+		&rule.AmountMin,
+		&rule.AmountMax,
+		&rule.Currency,
+		&rule.AccountID,
+		&rule.Type,
+		&rule.SetCategoryID,
+		&rule.RenameVendor,
+		pq.Array(&rule.AddTags),
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+		&rule.DeletedAt,
+	)
+
+	for _, amount := range []*Money{rule.AmountMin, rule.AmountMax} {
+		if amount != nil {
+			amount.Currency = rule.Currency
+		}
+	}
+
+	return rule, err
+}
@@ -0,0 +1,43 @@
+package models
+
+import "testing"
+
+func TestRuleMatchesAmounts(t *testing.T) {
+	low := NewMoney(10000, "KWD")
+	high := NewMoney(20000, "KWD")
+	rule := &TransactionRule{AmountMin: &low, AmountMax: &high, Currency: "KWD"}
+
+	tests := []struct {
+		amount Money
+		want   bool
+	}{
+		{NewMoney(10000, "KWD"), true},
+		{NewMoney(15500, "KWD"), true},
+		{NewMoney(20001, "KWD"), false},
+		{NewMoney(9999, "KWD"), false},
+		{NewMoney(15500, "USD"), false},
+	}
+
+	for _, tt := range tests {
+		got := rule.Matches(&Transaction{Amount: tt.amount})
+
+		if got != tt.want {
+			t.Errorf("Matches(%s %s) = %v, want %v", tt.amount, tt.amount.Currency, got, tt.want)
+		}
+	}
+}
+
+func TestRuleValidateAmountCurrency(t *testing.T) {
+	low := NewMoney(1000, "USD")
+	rule := &TransactionRule{Name: "Coffee", AmountMin: &low, Currency: "CAD", AddTags: []string{"coffee"}}
+
+	if err := rule.Validate(); err == nil {
+		t.Errorf("Validate of a USD bound on a CAD rule succeeded")
+	}
+
+	rule.Currency = "USD"
+
+	if err := rule.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"html"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// TransactionSearchResult is a transaction matching a search, with its rank
+// and the matched fields with matching words wrapped in <mark> tags. The
+// highlights are HTML-escaped.
+type TransactionSearchResult struct {
+	*Transaction
+	Rank       float64               `json:"rank"`
+	Highlights TransactionHighlights `json:"highlights"`
+}
+
+type TransactionHighlights struct {
+	Vendor      string `json:"vendor"`
+	Description string `json:"description"`
+	Category    string `json:"category"`
+}
+
+// Highlights are delimited by control characters, which can't appear in
+// to_tsvector output, and turned into tags after the text is escaped.
+const (
+	highlightStart = "\x01"
+	highlightStop  = "\x02"
+)
+
+// SearchQuery turns what a user typed into a tsquery matching transactions
+// that contain every word, or a word starting with it. Anything but letters
+// and digits separates words, so the input can't inject tsquery syntax. It
+// returns "" when there are no words.
+func SearchQuery(q string) string {
+	terms := []string{}
+
+	for _, word := range strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		terms = append(terms, word+":*")
+	}
+
+	return strings.Join(terms, " & ")
+}
+
+// Search returns the ledger's transactions matching the words of q, best
+// matches first. Ties go to the most recent.
+func (r *TransactionsRepo) Search(userId, ledgerId, q string, limit int) ([]*TransactionSearchResult, error) {
+	results := []*TransactionSearchResult{}
+	tsquery := SearchQuery(q)
+
+	if tsquery == "" {
+		return results, nil
+	}
+
+	short := "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", HighlightAll=true"
+	long := "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MinWords=8, MaxWords=25"
+
+	query := `WITH q AS (SELECT to_tsquery('english', $3) AS query)
+SELECT t.id,
+	ts_rank_cd(t.search, q.query) AS rank,
+	ts_headline('english', t.vendor, q.query, $5),
+	ts_headline('english', COALESCE(t.description, ''), q.query, $6),
+	ts_headline('english', COALESCE(categories.name, ''), q.query, $5)
+FROM transactions t
+CROSS JOIN q
+LEFT JOIN categories
+ON categories.id = t.category
+WHERE t.deleted_at IS NULL
+AND t.userid = $1
+AND t.ledger = $2
+AND t.search @@ q.query
+ORDER BY rank DESC, t.date DESC
+LIMIT $4`
+
+	rows, err := r.DB.Query(query, userId, ledgerId, tsquery, limit, short, long)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+
+	for rows.Next() {
+		result := &TransactionSearchResult{Transaction: &Transaction{}}
+		h := &result.Highlights
+
+		err := rows.Scan(&result.Transaction.ID, &result.Rank, &h.Vendor, &h.Description, &h.Category)
+
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		h.Vendor = markHighlights(h.Vendor)
+		h.Description = markHighlights(h.Description)
+		h.Category = markHighlights(h.Category)
+
+		ids = append(ids, result.Transaction.ID)
+		results = append(results, result)
+	}
+
+	rows.Close()
+
+	transactions, err := r.findByIds(ids)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, result := range results {
+		result.Transaction = transactions[result.Transaction.ID]
+	}
+
+	return results, nil
+}
+
+// findByIds loads the transactions with the given ids, with their splits and
+// tags.
+func (r *TransactionsRepo) findByIds(ids []string) (map[string]*Transaction, error) {
+	byId := map[string]*Transaction{}
+
+	if len(ids) == 0 {
+		return byId, nil
+	}
+
+	transactions, err := r.find(transactionSelect+`
+WHERE t.id = ANY($1::uuid[])`, pq.Array(ids))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, transaction := range transactions {
+		byId[transaction.ID] = transaction
+	}
+
+	return byId, nil
+}
+
+// markHighlights escapes a ts_headline result and turns its delimiters into
+// <mark> tags.
+func markHighlights(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, highlightStart, "<mark>")
+	return strings.ReplaceAll(s, highlightStop, "</mark>")
+}
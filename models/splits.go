@@ -0,0 +1,136 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// Lines returns the category lines that make up the transaction: its splits
+// when it has any, otherwise a single line for the whole amount. Category
+// reports should always be built from lines rather than the parent.
+func (t *Transaction) Lines() []*Split {
+	if len(t.Splits) > 0 {
+		return t.Splits
+	}
+
+	return []*Split{
+		{
+			TransactionID: t.ID,
+			Category:      t.Category,
+			CategoryID:    t.CategoryID,
+			Amount:        t.Amount,
+			Memo:          t.Description,
+		},
+	}
+}
+
+// ValidateSplits makes sure every split has a category and that the splits
+// add up to the parent amount.
+func (t *Transaction) ValidateSplits() error {
+	if len(t.Splits) == 0 {
+		return nil
+	}
+
+	sum := NewMoney(0, t.Amount.Currency)
+
+	for _, split := range t.Splits {
+		if split.CategoryID == "" {
+			return fmt.Errorf("every split must have a category")
+		}
+
+		var err error
+
+		if sum, err = sum.Add(split.Amount); err != nil {
+			return err
+		}
+	}
+
+	if sum.Minor != t.Amount.Minor {
+		return fmt.Errorf("splits add up to %s but the transaction amount is %s", sum, t.Amount)
+	}
+
+	return nil
+}
+
+// attachSplits loads the splits of the given transactions in one query.
+func attachSplits(db dbtx, transactions []*Transaction) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	ids := []string{}
+	byId := map[string]*Transaction{}
+
+	for _, t := range transactions {
+		ids = append(ids, t.ID)
+		byId[t.ID] = t
+	}
+
+	query := `SELECT s.id,
+	s.transaction,
+	categories.name,
+	s.category,
+	s.amount,
+	s.memo
+FROM transaction_splits s
+JOIN categories
+ON categories.id = s.category
+WHERE s.transaction = ANY($1::uuid[])
+ORDER BY s.created_at ASC`
+
+	rows, err := db.Query(query, pq.Array(ids))
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		split := &Split{}
+
+		err := rows.Scan(
+			&split.ID,
+			&split.TransactionID,
+			&split.Category,
+			&split.CategoryID,
+			&split.Amount,
+			&split.Memo,
+		)
+
+		if err != nil {
+			return err
+		}
+
+		t := byId[split.TransactionID]
+		split.Amount.Currency = t.Currency
+		t.Splits = append(t.Splits, split)
+	}
+
+	return rows.Err()
+}
+
+// replaceSplits swaps the stored splits of the transaction for t.Splits.
+func replaceSplits(db dbtx, t *Transaction) error {
+	_, err := db.Exec(`DELETE FROM transaction_splits WHERE transaction = $1`, t.ID)
+
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO transaction_splits (transaction, category, amount, memo)
+	VALUES ($1, $2, $3, $4) RETURNING id`
+
+	for _, split := range t.Splits {
+		split.TransactionID = t.ID
+
+		err := db.QueryRow(query, t.ID, split.CategoryID, split.Amount, split.Memo).Scan(&split.ID)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
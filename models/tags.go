@@ -0,0 +1,518 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+type TagsRepo struct {
+	DB *sql.DB
+}
+
+const tagSelect = `
+SELECT id,
+	userid,
+	ledger,
+	name,
+	created_at,
+	updated_at,
+	deleted_at
+FROM tags`
+
+// maxTagLength matches the width of tags.name.
+const maxTagLength = 64
+
+// NormalizeTags trims the tag names and drops empty ones and repeats, ignoring
+// case. The first spelling of a name is kept.
+func NormalizeTags(names []string) []string {
+	normalized := []string{}
+	seen := map[string]bool{}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+
+		seen[strings.ToLower(name)] = true
+		normalized = append(normalized, name)
+	}
+
+	return normalized
+}
+
+// ValidateTags checks that every tag name fits in the database.
+func ValidateTags(names []string) error {
+	for _, name := range names {
+		if len([]rune(name)) > maxTagLength {
+			return fmt.Errorf("tag %q is longer than %d characters", name, maxTagLength)
+		}
+	}
+
+	return nil
+}
+
+func (tag *Tag) Validate() error {
+	tag.Name = strings.TrimSpace(tag.Name)
+
+	if tag.Name == "" {
+		return fmt.Errorf("tag name is required")
+	}
+
+	return ValidateTags([]string{tag.Name})
+}
+
+func (r *TagsRepo) Find(userId, ledgerId string) ([]*Tag, error) {
+	query := tagSelect + `
+WHERE deleted_at IS NULL
+AND userid = $1
+AND ledger = $2
+ORDER BY lower(name) ASC`
+
+	rows, err := r.DB.Query(query, userId, ledgerId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []*Tag{}
+
+	for rows.Next() {
+		tag, err := scanIntoTag(rows)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	rows.Close()
+
+	return tags, nil
+}
+
+func (r *TagsRepo) FindOne(tag *Tag) (*Tag, error) {
+	query := tagSelect + `
+WHERE deleted_at IS NULL
+AND id = $1`
+
+	if tag.ID == "" {
+		return nil, fmt.Errorf("you must provide an id")
+	}
+
+	found, err := scanIntoTag(r.DB.QueryRow(query, tag.ID))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("tag with id not found")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+func (r *TagsRepo) Exists(tag *Tag) bool {
+	_, err := r.FindOne(tag)
+	return err == nil
+}
+
+func (r *TagsRepo) Save(tag *Tag) (*Tag, error) {
+	if err := tag.Validate(); err != nil {
+		return nil, err
+	}
+
+	if tag.ID != "" && r.Exists(tag) {
+		return r.update(tag)
+	}
+	return r.create(tag)
+}
+
+// Delete removes the tag from every transaction along with the tag itself.
+func (r *TagsRepo) Delete(id string) error {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM transaction_tags WHERE tag = $1`, id)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE tags SET deleted_at = (NOW() AT TIME ZONE 'UTC') WHERE id = $1`, id)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Assign adds and removes tags on the ledger's transactions in a single
+// database transaction. Tags that are added but don't exist yet are created.
+func (r *TagsRepo) Assign(userId, ledgerId string, transactionIds, add, remove []string) error {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := addTags(tx, userId, ledgerId, transactionIds, add); err != nil {
+		return err
+	}
+
+	if _, err := removeTags(tx, ledgerId, transactionIds, remove); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Spending totals the ledger's transactions by tag in the given currency,
+// optionally between two dates (inclusive), converting each transaction at
+// the rate on its date. Refunds are netted against expenses; transfers and
+// adjustments aren't counted. Tags without transactions in the range are
+// included with zero totals. Transactions without a rate are left out of
+// their tag's totals, and the tag's MissingRate describes the first missing
+// rate.
+func (r *TagsRepo) Spending(userId, ledgerId, currency string, from, to sql.NullTime) ([]*TagSpending, error) {
+	query := `SELECT tags.id,
+	tags.name,
+	t.type,
+	t.currency,
+	t.date,
+	COALESCE(SUM(t.amount), 0)::bigint,
+	COUNT(t.id)
+FROM tags
+LEFT JOIN transaction_tags tt
+ON tt.tag = tags.id
+LEFT JOIN transactions t
+ON t.id = tt.transaction
+AND t.deleted_at IS NULL
+AND t.type IN ('expense', 'income', 'refund')
+AND ($3::date IS NULL OR t.date >= $3::date)
+AND ($4::date IS NULL OR t.date <= $4::date)
+WHERE tags.deleted_at IS NULL
+AND tags.userid = $1
+AND tags.ledger = $2
+GROUP BY tags.id, tags.name, t.type, t.currency, t.date`
+
+	rows, err := r.DB.Query(query, userId, ledgerId, from, to)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	converter := (&ExchangeRatesRepo{DB: r.DB}).Converter(userId, currency)
+	byTag := map[string]*TagSpending{}
+	spending := []*TagSpending{}
+
+	for rows.Next() {
+		var tagId, tag string
+		var typ, amountCurrency sql.NullString
+		var date sql.NullTime
+		var amount int64
+		var count int
+
+		if err := rows.Scan(&tagId, &tag, &typ, &amountCurrency, &date, &amount, &count); err != nil {
+			return nil, err
+		}
+
+		s, ok := byTag[tagId]
+
+		if !ok {
+			s = &TagSpending{
+				TagID:    tagId,
+				Tag:      tag,
+				Expense:  NewMoney(0, currency),
+				Income:   NewMoney(0, currency),
+				Currency: currency,
+			}
+			byTag[tagId] = s
+			spending = append(spending, s)
+		}
+
+		// Tags without transactions come back as a single row of NULLs.
+		if !typ.Valid {
+			continue
+		}
+
+		s.Transactions += count
+
+		converted, err := converter.Convert(NewMoney(amount, amountCurrency.String), date.Time)
+
+		if errors.Is(err, ErrNoExchangeRate) {
+			if s.MissingRate == "" {
+				s.MissingRate = err.Error()
+			}
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch typ.String {
+		case TypeExpense:
+			s.Expense, err = s.Expense.Add(converted)
+		case TypeRefund:
+			s.Expense, err = s.Expense.Sub(converted)
+		case TypeIncome:
+			s.Income, err = s.Income.Add(converted)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(spending, func(i, j int) bool {
+		if spending[i].Expense.Minor != spending[j].Expense.Minor {
+			return spending[i].Expense.Minor > spending[j].Expense.Minor
+		}
+		return strings.ToLower(spending[i].Tag) < strings.ToLower(spending[j].Tag)
+	})
+
+	return spending, nil
+}
+
+func (r *TagsRepo) create(tag *Tag) (*Tag, error) {
+	query := `INSERT INTO tags (userid, ledger, name)
+	VALUES ($1, $2, $3) RETURNING id, created_at, updated_at, deleted_at`
+
+	err := r.DB.QueryRow(query, tag.UserID, tag.LedgerID, tag.Name).
+		Scan(&tag.ID, &tag.CreatedAt, &tag.UpdatedAt, &tag.DeletedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tag, nil
+}
+
+func (r *TagsRepo) update(tag *Tag) (*Tag, error) {
+	query := `UPDATE tags SET
+	name = $1,
+	updated_at = (NOW() AT TIME ZONE 'UTC')
+	WHERE id = $2 RETURNING updated_at`
+
+	err := r.DB.QueryRow(query, tag.Name, tag.ID).Scan(&tag.UpdatedAt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tag, nil
+}
+
+// ensureTags returns the ledger's tags with the given names, creating the
+// ones that don't exist yet.
+func ensureTags(db dbtx, userId, ledgerId string, names []string) ([]*Tag, error) {
+	names = NormalizeTags(names)
+
+	if err := ValidateTags(names); err != nil {
+		return nil, err
+	}
+
+	tags := []*Tag{}
+
+	if len(names) == 0 {
+		return tags, nil
+	}
+
+	_, err := db.Exec(`INSERT INTO tags (userid, ledger, name)
+	SELECT $1, $2, unnest($3::text[])
+	ON CONFLICT (ledger, lower(name)) WHERE deleted_at IS NULL DO NOTHING`, userId, ledgerId, pq.Array(names))
+
+	if err != nil {
+		return nil, err
+	}
+
+	lowered := []string{}
+
+	for _, name := range names {
+		lowered = append(lowered, strings.ToLower(name))
+	}
+
+	rows, err := db.Query(tagSelect+`
+WHERE deleted_at IS NULL
+AND ledger = $1
+AND lower(name) = ANY($2::text[])
+ORDER BY lower(name) ASC`, ledgerId, pq.Array(lowered))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		tag, err := scanIntoTag(rows)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// attachTags loads the tag names of the given transactions in one query.
+func attachTags(db dbtx, transactions []*Transaction) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+
+	ids := []string{}
+	byId := map[string]*Transaction{}
+
+	for _, t := range transactions {
+		t.Tags = []string{}
+		ids = append(ids, t.ID)
+		byId[t.ID] = t
+	}
+
+	query := `SELECT tt.transaction, tags.name
+FROM transaction_tags tt
+JOIN tags
+ON tags.id = tt.tag
+WHERE tags.deleted_at IS NULL
+AND tt.transaction = ANY($1::uuid[])
+ORDER BY lower(tags.name) ASC`
+
+	rows, err := db.Query(query, pq.Array(ids))
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var transactionId, name string
+
+		if err := rows.Scan(&transactionId, &name); err != nil {
+			return err
+		}
+
+		t := byId[transactionId]
+		t.Tags = append(t.Tags, name)
+	}
+
+	return rows.Err()
+}
+
+// replaceTags swaps the stored tags of the transaction for t.Tags, creating
+// any that don't exist yet, and sets t.Tags to the tags' stored names.
+func replaceTags(db dbtx, t *Transaction) error {
+	tags, err := ensureTags(db, t.UserID, t.LedgerID, t.Tags)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`DELETE FROM transaction_tags WHERE transaction = $1`, t.ID)
+
+	if err != nil {
+		return err
+	}
+
+	t.Tags = []string{}
+
+	for _, tag := range tags {
+		_, err := db.Exec(`INSERT INTO transaction_tags (transaction, tag) VALUES ($1, $2)`, t.ID, tag.ID)
+
+		if err != nil {
+			return err
+		}
+
+		t.Tags = append(t.Tags, tag.Name)
+	}
+
+	return nil
+}
+
+// addTags tags the ledger's transactions with the named tags, creating any
+// that don't exist yet, and returns how many tags were added. Tags a
+// transaction already has are left alone.
+func addTags(db dbtx, userId, ledgerId string, transactionIds, names []string) (int64, error) {
+	tags, err := ensureTags(db, userId, ledgerId, names)
+
+	if err != nil || len(tags) == 0 || len(transactionIds) == 0 {
+		return 0, err
+	}
+
+	tagIds := []string{}
+
+	for _, tag := range tags {
+		tagIds = append(tagIds, tag.ID)
+	}
+
+	result, err := db.Exec(`INSERT INTO transaction_tags (transaction, tag)
+	SELECT t.id, tags.id
+	FROM transactions t, tags
+	WHERE t.ledger = $1
+	AND t.deleted_at IS NULL
+	AND t.id = ANY($2::uuid[])
+	AND tags.id = ANY($3::uuid[])
+	ON CONFLICT DO NOTHING`, ledgerId, pq.Array(transactionIds), pq.Array(tagIds))
+
+	return rowsAffected(result, err)
+}
+
+// removeTags takes the named tags off the ledger's transactions and returns
+// how many were removed. Names that aren't tags are ignored.
+func removeTags(db dbtx, ledgerId string, transactionIds, names []string) (int64, error) {
+	names = NormalizeTags(names)
+
+	if len(names) == 0 || len(transactionIds) == 0 {
+		return 0, nil
+	}
+
+	lowered := []string{}
+
+	for _, name := range names {
+		lowered = append(lowered, strings.ToLower(name))
+	}
+
+	result, err := db.Exec(`DELETE FROM transaction_tags
+	WHERE transaction = ANY($1::uuid[])
+	AND tag IN (SELECT id FROM tags WHERE ledger = $2 AND lower(name) = ANY($3::text[]))`,
+		pq.Array(transactionIds), ledgerId, pq.Array(lowered))
+
+	return rowsAffected(result, err)
+}
+
+func scanIntoTag(row scanner) (*Tag, error) {
+	tag := &Tag{}
+	err := row.Scan(
+		&tag.ID,
+		&tag.UserID,
+		&tag.LedgerID,
+		&tag.Name,
+		&tag.CreatedAt,
+		&tag.UpdatedAt,
+		&tag.DeletedAt,
+	)
+
+	return tag, err
+}
@@ -4,48 +4,95 @@ import (
 	"database/sql"
 	"fmt"
 	"time"
+
+	"github.com/lib/pq"
+)
+
+// Transaction types. Amounts are stored unsigned with the direction given by
+// the type: expenses take money out of the account, income and refunds bring
+// it in. Transfer legs and adjustments carry their own sign.
+const (
+	TypeExpense    = "expense"
+	TypeIncome     = "income"
+	TypeRefund     = "refund"
+	TypeTransfer   = "transfer"
+	TypeAdjustment = "adjustment"
 )
 
+var TransactionTypes = map[string]bool{
+	TypeExpense:    true,
+	TypeIncome:     true,
+	TypeRefund:     true,
+	TypeTransfer:   true,
+	TypeAdjustment: true,
+}
+
+// signedAmount is the SQL for the amount of transaction t as it changes its
+// account's balance.
+const signedAmount = `CASE WHEN t.type = 'expense' THEN -t.amount ELSE t.amount END`
+
 type TransactionsRepo struct {
 	DB *sql.DB
 }
 
 type transactionPredicateFunction func(*Transaction) bool
 
-func (r *TransactionsRepo) Filter(transactions []*Transaction, pred transactionPredicateFunction) []*Transaction {
-	filtered := []*Transaction{}
-
-	for _, transaction := range transactions {
-		if pred(transaction) {
-			filtered = append(filtered, transaction)
-		}
-	}
-
-	return filtered
-}
-
-func (r *TransactionsRepo) Find(userId string) ([]*Transaction, error) {
-	query := `
+const transactionSelect = `
 SELECT t.id,
 	t.userid,
+	t.ledger,
+	t.account,
+	t.transfer,
+	t.payee,
+	COALESCE(payees.name, ''),
+	t.external_id,
+	t.fingerprint,
 	t.amount,
-	categories.NAME AS category_name,
-	t.category,
+	t.currency,
+	COALESCE(categories.NAME, '') AS category_name,
+	COALESCE(t.category::text, ''),
 	t.description,
 	t.vendor,
 	t.date,
 	t.type,
+	t.status,
+	t.reconciliation,
+	t.reimbursable,
 	t.created_at,
 	t.updated_at,
 	t.deleted_at
 FROM transactions t
-JOIN categories
+LEFT JOIN categories
 ON categories.id = t.category
+LEFT JOIN payees
+ON payees.id = t.payee`
+
+func (r *TransactionsRepo) Filter(transactions []*Transaction, pred transactionPredicateFunction) []*Transaction {
+	filtered := []*Transaction{}
+
+	for _, transaction := range transactions {
+		if pred(transaction) {
+			filtered = append(filtered, transaction)
+		}
+	}
+
+	return filtered
+}
+
+func (r *TransactionsRepo) Find(userId, ledgerId string) ([]*Transaction, error) {
+	query := transactionSelect + `
 WHERE t.deleted_at IS NULL
 AND t.userid = $1
+AND t.ledger = $2
 ORDER BY t.created_at DESC`
 
-	rows, err := r.DB.Query(query, userId)
+	return r.find(query, userId, ledgerId)
+}
+
+// find runs a query selecting transactionSelect's columns and loads the
+// splits and tags of the transactions it returns.
+func (r *TransactionsRepo) find(query string, args ...any) ([]*Transaction, error) {
+	rows, err := r.DB.Query(query, args...)
 
 	if err != nil {
 		return nil, err
@@ -56,6 +103,7 @@ ORDER BY t.created_at DESC`
 	for rows.Next() {
 		transaction, err := scanIntoTransaction(rows)
 		if err != nil {
+			rows.Close()
 			return nil, err
 		}
 		transactions = append(transactions, transaction)
@@ -63,26 +111,21 @@ ORDER BY t.created_at DESC`
 
 	rows.Close()
 
+	err = attachSplits(r.DB, transactions)
+
+	if err == nil {
+		err = attachTags(r.DB, transactions)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
 	return transactions, nil
 }
 
 func (r *TransactionsRepo) FindOne(t *Transaction) (*Transaction, error) {
-	query := `
-SELECT t.id,
-	t.userid,
-	t.amount,
-	categories.NAME AS category_name,
-	t.category,
-	t.description,
-	t.vendor,
-	t.date,
-	t.type,
-	t.created_at,
-	t.updated_at,
-	t.deleted_at
-FROM transactions t
-JOIN categories
-ON categories.id = t.category
+	query := transactionSelect + `
 WHERE t.deleted_at IS NULL
 AND t.id = $1`
 
@@ -92,22 +135,17 @@ AND t.id = $1`
 
 	row := r.DB.QueryRow(query, t.ID)
 
-	transaction := &Transaction{}
+	transaction, err := scanIntoTransaction(row)
 
-	err := row.Scan(
-		&transaction.ID,
-		&transaction.UserID,
-		&transaction.Amount,
-		&transaction.Category,
-		&transaction.CategoryID,
-		&transaction.Description,
-		&transaction.Vendor,
-		&transaction.Date,
-		&transaction.Type,
-		&transaction.CreatedAt,
-		&transaction.UpdatedAt,
-		&transaction.DeletedAt,
-	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = attachSplits(r.DB, []*Transaction{transaction})
+
+	if err == nil {
+		err = attachTags(r.DB, []*Transaction{transaction})
+	}
 
 	if err != nil {
 		return nil, err
@@ -116,6 +154,102 @@ AND t.id = $1`
 	return transaction, nil
 }
 
+// FindTransfer returns both legs of a transfer, outflow first.
+func (r *TransactionsRepo) FindTransfer(transferId string) ([]*Transaction, error) {
+	query := transactionSelect + `
+WHERE t.deleted_at IS NULL
+AND t.transfer = $1
+ORDER BY t.amount ASC`
+
+	rows, err := r.DB.Query(query, transferId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := []*Transaction{}
+
+	for rows.Next() {
+		transaction, err := scanIntoTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	rows.Close()
+
+	if len(transactions) != 2 {
+		return nil, fmt.Errorf("transfer with id not found")
+	}
+
+	return transactions, nil
+}
+
+// FingerprintExists reports whether the ledger already has a transaction in
+// the account imported from the same statement entry.
+func (r *TransactionsRepo) FingerprintExists(ledgerId string, accountId OptionalString, fingerprint string) (bool, error) {
+	return fingerprintExists(r.DB, ledgerId, accountId, fingerprint)
+}
+
+func fingerprintExists(db dbtx, ledgerId string, accountId OptionalString, fingerprint string) (bool, error) {
+	query := `SELECT EXISTS (
+	SELECT 1 FROM transactions
+	WHERE deleted_at IS NULL
+	AND ledger = $1
+	AND account IS NOT DISTINCT FROM $2
+	AND fingerprint = $3
+)`
+
+	var exists bool
+
+	err := db.QueryRow(query, ledgerId, accountId, fingerprint).Scan(&exists)
+
+	return exists, err
+}
+
+// FindSimilar returns the ledger's transactions that may be the same entry as
+// t: same type, amount and currency, dated within the given number of days,
+// and in the same account unless either side has none. Transfers and the
+// transactions in exclude are left out.
+func (r *TransactionsRepo) FindSimilar(t *Transaction, days int, exclude []string) ([]*Transaction, error) {
+	query := transactionSelect + `
+WHERE t.deleted_at IS NULL
+AND t.ledger = $1
+AND t.type = $2
+AND t.amount = $3
+AND t.currency = $8
+AND t.date BETWEEN $4::date - $5::int AND $4::date + $5::int
+AND (t.account IS NULL OR $6::uuid IS NULL OR t.account = $6::uuid)
+AND t.transfer IS NULL
+AND NOT (t.id = ANY($7::uuid[]))
+ORDER BY t.date ASC`
+
+	if exclude == nil {
+		exclude = []string{}
+	}
+
+	rows, err := r.DB.Query(query, t.LedgerID, t.Type, t.Amount, t.Date, days, t.AccountID, pq.Array(exclude), t.Amount.Currency)
+
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := []*Transaction{}
+
+	for rows.Next() {
+		transaction, err := scanIntoTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, transaction)
+	}
+
+	rows.Close()
+
+	return transactions, nil
+}
+
 func (r *TransactionsRepo) Exists(t *Transaction) bool {
 	_, err := r.FindOne(t)
 	return err == nil
@@ -133,18 +267,150 @@ func (r *TransactionsRepo) Delete(id string) error {
 	return nil
 }
 
+// DeleteTransfer removes both legs of a transfer.
+func (r *TransactionsRepo) DeleteTransfer(transferId string) error {
+	query := `UPDATE transactions SET deleted_at = (NOW() AT TIME ZONE 'UTC') WHERE transfer = $1`
+
+	_, err := r.DB.Exec(query, transferId)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (r *TransactionsRepo) Save(t *Transaction) (*Transaction, error) {
+	if err := t.ValidateSplits(); err != nil {
+		return nil, err
+	}
+
 	if r.Exists(t) {
 		return r.update(t)
 	}
 	return r.create(t)
 }
 
+// SaveTransfer writes the outflow and inflow legs of a transfer in a single
+// database transaction. New transfers get a fresh transfer id shared by both
+// legs; existing ones are updated in place.
+func (r *TransactionsRepo) SaveTransfer(out, in *Transaction) error {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if !out.TransferID.Valid {
+		var transferId string
+
+		err := tx.QueryRow(`SELECT public.uuid_generate_v4()`).Scan(&transferId)
+
+		if err != nil {
+			return err
+		}
+
+		out.TransferID = OptionalString{transferId, true}
+		in.TransferID = out.TransferID
+	}
+
+	for _, t := range []*Transaction{out, in} {
+		if t.ID == "" {
+			_, err = insertTransaction(tx, t)
+		} else {
+			_, err = updateTransaction(tx, t)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UpdateAll saves changes to several existing transactions, including their
+// tags, in a single database transaction. Splits are left as they are.
+func (r *TransactionsRepo) UpdateAll(transactions []*Transaction) error {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, t := range transactions {
+		if _, err := updateTransaction(tx, t); err != nil {
+			return err
+		}
+
+		if err := replaceTags(tx, t); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (r *TransactionsRepo) create(t *Transaction) (*Transaction, error) {
-	query := `INSERT INTO transactions (userid, amount, category, description, vendor, date, type)
-	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at, updated_at, deleted_at`
+	return r.withSplits(t, insertTransaction)
+}
+
+func (r *TransactionsRepo) update(t *Transaction) (*Transaction, error) {
+	return r.withSplits(t, updateTransaction)
+}
+
+// withSplits runs write and stores the splits and tags of the transaction in
+// the same database transaction.
+func (r *TransactionsRepo) withSplits(t *Transaction, write func(dbtx, *Transaction) (*Transaction, error)) (*Transaction, error) {
+	tx, err := r.DB.Begin()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	t, err = write(tx, t)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = replaceSplits(tx, t)
+
+	if err == nil {
+		err = replaceTags(tx, t)
+	}
+
+	if err != nil {
+		return nil, err
+	}
 
-	row := r.DB.QueryRow(query, t.UserID, t.Amount, t.CategoryID, t.Description.String, t.Vendor, t.Date, t.Type)
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// insertTransaction writes a new transaction, in the currency of its amount.
+func insertTransaction(db dbtx, t *Transaction) (*Transaction, error) {
+	if err := t.checkType(); err != nil {
+		return nil, err
+	}
+
+	if err := t.checkCurrency(); err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO transactions (userid, ledger, account, transfer, payee, external_id, fingerprint, amount, category, description, vendor, date, type, currency, reimbursable)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')::uuid, $10, $11, $12, $13, $14, $15) RETURNING id, created_at, updated_at, deleted_at`
+
+	row := db.QueryRow(query, t.UserID, t.LedgerID, t.AccountID, t.TransferID, t.PayeeID, t.ExternalID, t.Fingerprint, t.Amount, t.CategoryID, t.Description.String, t.Vendor, t.Date, t.Type, t.Currency, t.Reimbursable)
 
 	err := row.Scan(&t.ID, &t.CreatedAt, &t.UpdatedAt, &t.DeletedAt)
 
@@ -155,19 +421,32 @@ func (r *TransactionsRepo) create(t *Transaction) (*Transaction, error) {
 	return t, nil
 }
 
-func (r *TransactionsRepo) update(t *Transaction) (*Transaction, error) {
-	fmt.Println("inside update: ", t.Description)
+// updateTransaction saves changes to a transaction, including its currency,
+// which is the currency of its amount.
+func updateTransaction(db dbtx, t *Transaction) (*Transaction, error) {
+	if err := t.checkType(); err != nil {
+		return nil, err
+	}
+
+	if err := t.checkCurrency(); err != nil {
+		return nil, err
+	}
+
 	query := `UPDATE transactions SET
 	amount = $1,
-	category = $2,
+	category = NULLIF($2, '')::uuid,
 	description = $3,
 	vendor = $4,
 	date = $5,
 	type = $6,
+	account = $7,
+	payee = $8,
+	currency = $9,
+	reimbursable = $10,
 	updated_at = (NOW() AT TIME ZONE 'UTC')
-	WHERE id = $7`
+	WHERE id = $11`
 
-	_, err := r.DB.Exec(query, t.Amount, t.CategoryID, t.Description.String, t.Vendor, t.Date, t.Type, t.ID)
+	_, err := db.Exec(query, t.Amount, t.CategoryID, t.Description.String, t.Vendor, t.Date, t.Type, t.AccountID, t.PayeeID, t.Currency, t.Reimbursable, t.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -177,22 +456,77 @@ func (r *TransactionsRepo) update(t *Transaction) (*Transaction, error) {
 	return t, nil
 }
 
-func scanIntoTransaction(rows *sql.Rows) (*Transaction, error) {
-	t := &Transaction{}
-	err := rows.Scan(
+func scanIntoTransaction(row scanner) (*Transaction, error) {
+	t := &Transaction{Tags: []string{}}
+	err := row.Scan(
 		&t.ID,
 		&t.UserID,
+		&t.LedgerID,
+		&t.AccountID,
+		&t.TransferID,
+		&t.PayeeID,
+		&t.Payee,
+		&t.ExternalID,
+		&t.Fingerprint,
 		&t.Amount,
+		&t.Currency,
 		&t.Category,
 		&t.CategoryID,
 		&t.Description,
 		&t.Vendor,
 		&t.Date,
 		&t.Type,
+		&t.Status,
+		&t.ReconciliationID,
+		&t.Reimbursable,
 		&t.CreatedAt,
 		&t.UpdatedAt,
 		&t.DeletedAt,
 	)
 
+	t.Amount.Currency = t.Currency
+
 	return t, err
 }
+
+// checkCurrency makes sure the amount and splits of the transaction are in a
+// currency, and sets the transaction's currency to it.
+func (t *Transaction) checkCurrency() error {
+	if t.Amount.Currency == "" {
+		return fmt.Errorf("transaction amount has no currency")
+	}
+
+	for _, split := range t.Splits {
+		if split.Amount.Currency != t.Amount.Currency {
+			return fmt.Errorf("split amounts must be in %s like the transaction", t.Amount.Currency)
+		}
+	}
+
+	t.Currency = t.Amount.Currency
+
+	return nil
+}
+
+// Signed reports whether the transaction's amount carries its own sign rather
+// than taking its direction from the type.
+func (t *Transaction) Signed() bool {
+	return t.Type == TypeTransfer || t.Type == TypeAdjustment
+}
+
+// checkType makes sure the transaction has a known type, and that amounts
+// which take their direction from it aren't negative.
+func (t *Transaction) checkType() error {
+	if !TransactionTypes[t.Type] {
+		return fmt.Errorf("invalid transaction type: %s", t.Type)
+	}
+
+	if !t.Signed() && t.Amount.Sign() < 0 {
+		return fmt.Errorf("%s amount can't be negative", t.Type)
+	}
+
+	if t.Reimbursable && t.Type != TypeExpense {
+		return fmt.Errorf("only expenses can be reimbursable")
+	}
+
+	return nil
+}
@@ -50,10 +50,10 @@ func (r *UserRepo) FindOne(user *User) (*User, error) {
 	query := ""
 	paramOne := ""
 	if user.ID != "" { // if the ID is provided, we use that to find it.
-		query = `SELECT id, username, passwordhash, created_at, updated_at, deleted_at FROM users WHERE id = $1`
+		query = `SELECT id, username, passwordhash, base_currency, created_at, updated_at, deleted_at FROM users WHERE id = $1`
 		paramOne = user.ID
 	} else if user.Username != "" {
-		query = `SELECT id, username, passwordhash, created_at, updated_at, deleted_at FROM users WHERE username = $1`
+		query = `SELECT id, username, passwordhash, base_currency, created_at, updated_at, deleted_at FROM users WHERE username = $1`
 		paramOne = user.Username
 	}
 
@@ -63,6 +63,7 @@ func (r *UserRepo) FindOne(user *User) (*User, error) {
 		&user.ID,
 		&user.Username,
 		&user.PasswordHash,
+		&user.BaseCurrency,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.DeletedAt,
@@ -81,11 +82,11 @@ func (r *UserRepo) FindOne(user *User) (*User, error) {
 
 func (r *UserRepo) create(u *User) (*User, error) {
 	query := `
-	INSERT INTO users (username, passwordhash) VALUES($1, $2) RETURNING id, created_at, updated_at, deleted_at`
+	INSERT INTO users (username, passwordhash) VALUES($1, $2) RETURNING id, base_currency, created_at, updated_at, deleted_at`
 
 	row := r.DB.QueryRow(query, u.Username, u.PasswordHash)
 
-	err := row.Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt)
+	err := row.Scan(&u.ID, &u.BaseCurrency, &u.CreatedAt, &u.UpdatedAt, &u.DeletedAt)
 
 	if err != nil {
 		return nil, err
@@ -99,9 +100,9 @@ func (r *UserRepo) create(u *User) (*User, error) {
 }
 
 func (r *UserRepo) update(u *User) (*User, error) {
-	query := `UPDATE users SET username = $1, passwordhash = $2, updated_at = $3 WHERE id = $4 RETURNING updated_at`
+	query := `UPDATE users SET username = $1, passwordhash = $2, base_currency = $3, updated_at = $4 WHERE id = $5 RETURNING updated_at`
 
-	err := r.DB.QueryRow(query, u.Username, u.PasswordHash, time.Now().UTC(), u.ID).Scan(&u.UpdatedAt)
+	err := r.DB.QueryRow(query, u.Username, u.PasswordHash, u.BaseCurrency, time.Now().UTC(), u.ID).Scan(&u.UpdatedAt)
 
 	if err != nil {
 		return nil, err
@@ -0,0 +1,132 @@
+package scheduler
+
+import (
+	"log"
+	"time"
+
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/storage"
+)
+
+// Scheduler periodically turns due occurrences of recurring schedules into
+// transactions. Every run looks at all occurrences up to today, so schedules
+// catch up on anything missed while the server was down.
+type Scheduler struct {
+	DB       *storage.DBStore
+	Interval time.Duration
+}
+
+func NewScheduler(db *storage.DBStore, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		DB:       db,
+		Interval: interval,
+	}
+}
+
+// Start runs the materializer immediately and then on every interval, in the
+// background.
+func (s *Scheduler) Start() {
+	go func() {
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+
+		for {
+			if err := s.Materialize(time.Now().UTC()); err != nil {
+				log.Println("ERROR: Failed materializing recurring transactions:", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Materialize creates a transaction for every occurrence of every active
+// schedule that is due on or before now and hasn't been created yet.
+func (s *Scheduler) Materialize(now time.Time) error {
+	schedules, err := s.DB.Recurring.FindActive()
+
+	if err != nil {
+		return err
+	}
+
+	for _, rec := range schedules {
+		err := s.materializeSchedule(rec, now)
+
+		if err != nil {
+			log.Printf("ERROR: Failed materializing recurring transaction (%s): %s", rec.ID, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (s *Scheduler) materializeSchedule(rec *models.Recurring, now time.Time) error {
+	rule, err := models.ParseRule(rec.Rule)
+
+	if err != nil {
+		return err
+	}
+
+	materialized, err := s.DB.Recurring.Materialized(rec.ID)
+
+	if err != nil {
+		return err
+	}
+
+	payees, err := s.DB.Payees.Find(rec.UserID, rec.LedgerID)
+
+	if err != nil {
+		return err
+	}
+
+	payee := models.OptionalString{}
+
+	if match := models.MatchPayee(payees, rec.Vendor); match != nil {
+		payee = models.OptionalString{String: match.ID, Valid: true}
+	}
+
+	for _, occurrence := range rule.Occurrences(rec.StartDate, rec.StartDate, now, 0) {
+		if materialized[occurrence.Format("2006-01-02")] {
+			continue
+		}
+
+		claimed, err := s.DB.Recurring.Claim(rec.ID, occurrence)
+
+		if err != nil {
+			return err
+		}
+
+		if !claimed {
+			continue
+		}
+
+		t, err := s.DB.Transactions.Save(&models.Transaction{
+			UserID:      rec.UserID,
+			LedgerID:    rec.LedgerID,
+			AccountID:   rec.AccountID,
+			CategoryID:  rec.CategoryID,
+			Amount:      rec.Amount,
+			Description: rec.Description,
+			Vendor:      rec.Vendor,
+			PayeeID:     payee,
+			Date:        occurrence,
+			Type:        rec.Type,
+		})
+
+		if err != nil {
+			if releaseErr := s.DB.Recurring.Release(rec.ID, occurrence); releaseErr != nil {
+				log.Printf("ERROR: Failed releasing occurrence (%s, %s): %s", rec.ID, occurrence, releaseErr.Error())
+			}
+			return err
+		}
+
+		err = s.DB.Recurring.Link(rec.ID, occurrence, t.ID)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Materialized recurring transaction %s for %s", rec.ID, occurrence.Format("2006-01-02"))
+	}
+
+	return nil
+}
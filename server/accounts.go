@@ -0,0 +1,346 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type CreateAccountRequest struct {
+	Name           string       `json:"name"`
+	Kind           string       `json:"kind"`
+	OpeningBalance models.Money `json:"opening_balance"`
+	Currency       string       `json:"currency"`
+	Archived       bool         `json:"archived"`
+}
+
+type AccountWithBalance struct {
+	*models.Account
+	Balance models.Money `json:"balance"`
+}
+
+func (s *APIServer) registerAccounts() {
+	s.Router.Route("/api/accounts", func(r chi.Router) {
+		r.Get("/", s.WithUser(s.WithLedger(MakeHandler(s.getAccounts))))
+		r.Get("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.getAccount))))
+		r.Get("/{id}/balance", s.WithUser(s.WithLedger(MakeHandler(s.getAccountBalance))))
+
+		r.Post("/", s.WithUser(s.WithLedger(MakeHandler(s.createAccount))))
+
+		r.Put("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.updateAccount))))
+
+		r.Delete("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.deleteAccount))))
+	})
+}
+
+func (s *APIServer) getAccounts(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	accounts, err := s.DB.Accounts.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	accountsWithBalance := []*AccountWithBalance{}
+
+	now := time.Now().UTC()
+
+	for _, account := range accounts {
+		balance, err := s.DB.Accounts.Balance(account.ID, now)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+
+		accountsWithBalance = append(accountsWithBalance, &AccountWithBalance{
+			Account: account,
+			Balance: balance,
+		})
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": accountsWithBalance,
+		},
+	}
+}
+
+func (s *APIServer) getAccount(w http.ResponseWriter, r *http.Request) *Response {
+	account, resp := s.findUserAccount(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	balance, err := s.DB.Accounts.Balance(account.ID, time.Now().UTC())
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": &AccountWithBalance{
+				Account: account,
+				Balance: balance,
+			},
+		},
+	}
+}
+
+// getAccountBalance returns the balance at the end of the day given by the
+// as_of query parameter (YYYY-MM-DD), or the current balance when omitted.
+func (s *APIServer) getAccountBalance(w http.ResponseWriter, r *http.Request) *Response {
+	account, resp := s.findUserAccount(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	asOf := time.Now().UTC()
+
+	if asOfString := r.URL.Query().Get("as_of"); asOfString != "" {
+		date, err := time.Parse("2006-01-02", asOfString)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+
+		asOf = date.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+
+	balance, err := s.DB.Accounts.Balance(account.ID, asOf)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": JSON{
+				"account": account.ID,
+				"as_of":   asOf,
+				"balance": balance,
+			},
+		},
+	}
+}
+
+func (s *APIServer) createAccount(w http.ResponseWriter, r *http.Request) *Response {
+	car := &CreateAccountRequest{}
+
+	err := utils.DecodeBody(r, car)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	if car.Currency == "" {
+		car.Currency = user.BaseCurrency
+	}
+
+	currency, err := models.NormalizeCurrency(car.Currency)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	openingBalance, resp := amountIn(car.OpeningBalance, currency)
+
+	if resp != nil {
+		return resp
+	}
+
+	a, err := s.DB.Accounts.Save(&models.Account{
+		UserID:         user.ID,
+		LedgerID:       ledger.ID,
+		Name:           car.Name,
+		Kind:           car.Kind,
+		OpeningBalance: openingBalance,
+		Currency:       currency,
+		Archived:       car.Archived,
+	})
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": a,
+		},
+	}
+}
+
+func (s *APIServer) updateAccount(w http.ResponseWriter, r *http.Request) *Response {
+	car := &CreateAccountRequest{}
+
+	err := utils.DecodeBody(r, car)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	account, resp := s.findUserAccount(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	account.Name = car.Name
+	account.Kind = car.Kind
+	account.Archived = car.Archived
+
+	if car.Currency != "" {
+		currency, err := models.NormalizeCurrency(car.Currency)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+
+		account.Currency = currency
+	}
+
+	account.OpeningBalance, resp = amountIn(car.OpeningBalance, account.Currency)
+
+	if resp != nil {
+		return resp
+	}
+
+	a, err := s.DB.Accounts.Save(account)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": a,
+		},
+	}
+}
+
+func (s *APIServer) deleteAccount(w http.ResponseWriter, r *http.Request) *Response {
+	account, resp := s.findUserAccount(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	err := s.DB.Accounts.Delete(account.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"deleted": account.ID,
+		},
+	}
+}
+
+// findUserAccount loads the account with the given id, making sure it belongs
+// to the ledger on the request context.
+func (s *APIServer) findUserAccount(r *http.Request, id string) (*models.Account, *Response) {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	account, err := s.DB.Accounts.FindOne(&models.Account{
+		ID: id,
+	})
+
+	if err != nil {
+		return nil, &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	if account.UserID != user.ID || account.LedgerID != ledger.ID {
+		return nil, &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "account not found",
+			},
+		}
+	}
+
+	return account, nil
+}
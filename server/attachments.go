@@ -0,0 +1,398 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alexgaudon/budgie/blobstore"
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/thumbnail"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxAttachmentSize is the largest file accepted as an attachment.
+const maxAttachmentSize = 10 << 20
+
+// thumbnailSize is the longest side, in pixels, of attachment thumbnails.
+const thumbnailSize = 256
+
+// attachmentTypes are the content types accepted for attachments, as sniffed
+// from the file itself rather than taken from the upload.
+var attachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+func (s *APIServer) getAttachments(w http.ResponseWriter, r *http.Request) *Response {
+	t, resp := s.findUserTransaction(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	attachments, err := s.DB.Attachments.Find(t.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": attachments,
+		},
+	}
+}
+
+// uploadAttachment stores the "file" field of a multipart form as an
+// attachment of the transaction, along with a thumbnail when it's an image the
+// server can decode.
+func (s *APIServer) uploadAttachment(w http.ResponseWriter, r *http.Request) *Response {
+	t, resp := s.findUserTransaction(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	// Leave room for the rest of the form so a file right at the limit still
+	// gets through to the size check below.
+	r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+
+	if err := r.ParseMultipartForm(maxAttachmentSize); err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	file, header, err := r.FormFile("file")
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxAttachmentSize+1))
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	if len(data) > maxAttachmentSize {
+		return &Response{
+			Status: http.StatusRequestEntityTooLarge,
+			Content: JSON{
+				"error": fmt.Sprintf("attachments can be at most %d MB", maxAttachmentSize>>20),
+			},
+		}
+	}
+
+	if len(data) == 0 {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "attachment is empty",
+			},
+		}
+	}
+
+	contentType := http.DetectContentType(data)
+
+	if !attachmentTypes[contentType] {
+		return &Response{
+			Status: http.StatusUnsupportedMediaType,
+			Content: JSON{
+				"error": fmt.Sprintf("attachments must be images or PDFs, not %s", contentType),
+			},
+		}
+	}
+
+	id, err := randomKey()
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusInternalServerError,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	sum := sha256.Sum256(data)
+
+	attachment := &models.Attachment{
+		UserID:        t.UserID,
+		LedgerID:      t.LedgerID,
+		TransactionID: t.ID,
+		Filename:      attachmentFilename(header.Filename),
+		ContentType:   contentType,
+		Size:          len(data),
+		SHA256:        hex.EncodeToString(sum[:]),
+		StorageKey:    fmt.Sprintf("%s/%s/%s", t.LedgerID, t.ID, id),
+	}
+
+	if err := s.Blobs.Put(r.Context(), attachment.StorageKey, data, contentType); err != nil {
+		return &Response{
+			Status: http.StatusInternalServerError,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	// A missing thumbnail isn't worth failing the upload over.
+	if thumbnail.Supported(contentType) {
+		thumb, err := thumbnail.Make(data, thumbnailSize)
+
+		if err == nil {
+			key := attachment.StorageKey + ".thumb.jpg"
+			err = s.Blobs.Put(r.Context(), key, thumb, "image/jpeg")
+
+			if err == nil {
+				attachment.ThumbnailKey = models.OptionalString{String: key, Valid: true}
+			}
+		}
+
+		if err != nil {
+			log.Printf("ERROR: Failed making thumbnail (%s): %s", attachment.StorageKey, err.Error())
+		}
+	}
+
+	created, err := s.DB.Attachments.Create(attachment)
+
+	if err != nil {
+		s.deleteAttachmentBlobs(r, attachment)
+
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusCreated,
+		Content: JSON{
+			"data": created,
+		},
+	}
+}
+
+func (s *APIServer) deleteAttachment(w http.ResponseWriter, r *http.Request) *Response {
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+	attachment, resp := s.findUserAttachment(r)
+
+	if resp == nil && attachment.LedgerID != ledger.ID {
+		resp = &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "attachment not found",
+			},
+		}
+	}
+
+	if resp != nil {
+		return resp
+	}
+
+	err := s.DB.Attachments.Delete(attachment.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	s.deleteAttachmentBlobs(r, attachment)
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"deleted": attachment.ID,
+		},
+	}
+}
+
+func (s *APIServer) downloadAttachment(w http.ResponseWriter, r *http.Request) {
+	attachment, resp := s.findUserAttachment(r)
+
+	if resp != nil {
+		writeResponse(w, resp.Status, resp.Content)
+		return
+	}
+
+	s.serveBlob(w, r, attachment.StorageKey, attachment.ContentType, attachment.Filename, attachment.Size)
+}
+
+func (s *APIServer) downloadThumbnail(w http.ResponseWriter, r *http.Request) {
+	attachment, resp := s.findUserAttachment(r)
+
+	if resp != nil {
+		writeResponse(w, resp.Status, resp.Content)
+		return
+	}
+
+	if !attachment.ThumbnailKey.Valid {
+		writeResponse(w, http.StatusNotFound, JSON{"error": "attachment has no thumbnail"})
+		return
+	}
+
+	filename := strings.TrimSuffix(attachment.Filename, filepath.Ext(attachment.Filename)) + ".thumb.jpg"
+
+	s.serveBlob(w, r, attachment.ThumbnailKey.String, "image/jpeg", filename, -1)
+}
+
+// serveBlob streams a blob to the client. A negative size leaves the length
+// unset.
+func (s *APIServer) serveBlob(w http.ResponseWriter, r *http.Request, key, contentType, filename string, size int) {
+	blob, err := s.Blobs.Get(r.Context(), key)
+
+	if errors.Is(err, blobstore.ErrNotFound) {
+		writeResponse(w, http.StatusNotFound, JSON{"error": "attachment not found"})
+		return
+	}
+
+	if err != nil {
+		writeResponse(w, http.StatusInternalServerError, JSON{"error": err.Error()})
+		return
+	}
+
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+	}
+
+	if _, err := io.Copy(w, blob); err != nil {
+		log.Println("ERROR:", err)
+	}
+}
+
+func (s *APIServer) deleteAttachmentBlobs(r *http.Request, attachment *models.Attachment) {
+	keys := []string{attachment.StorageKey}
+
+	if attachment.ThumbnailKey.Valid {
+		keys = append(keys, attachment.ThumbnailKey.String)
+	}
+
+	for _, key := range keys {
+		if err := s.Blobs.Delete(r.Context(), key); err != nil {
+			log.Printf("ERROR: Failed deleting blob (%s): %s", key, err.Error())
+		}
+	}
+}
+
+// findUserTransaction loads the transaction in the URL, checking it belongs
+// to the user and the request's ledger.
+func (s *APIServer) findUserTransaction(r *http.Request) (*models.Transaction, *Response) {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	t, err := s.DB.Transactions.FindOne(&models.Transaction{
+		ID: chi.URLParam(r, "id"),
+	})
+
+	if err != nil || t.UserID != user.ID || t.LedgerID != ledger.ID {
+		return nil, &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "transaction not found",
+			},
+		}
+	}
+
+	return t, nil
+}
+
+// findUserAttachment loads the attachment in the URL, checking it belongs to
+// the transaction in the URL and to the user.
+func (s *APIServer) findUserAttachment(r *http.Request) (*models.Attachment, *Response) {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+
+	attachment, err := s.DB.Attachments.FindOne(&models.Attachment{
+		ID: chi.URLParam(r, "attachmentId"),
+	})
+
+	if err != nil || attachment.UserID != user.ID || attachment.TransactionID != chi.URLParam(r, "id") {
+		return nil, &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "attachment not found",
+			},
+		}
+	}
+
+	return attachment, nil
+}
+
+// attachmentFilename keeps the base name of an uploaded file, dropping any
+// directories the client sent and characters that don't belong in a header.
+func attachmentFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+
+	if name == "." || name == "/" {
+		name = ""
+	}
+
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[len(runes)-255:])
+	}
+
+	return name
+}
+
+func randomKey() (string, error) {
+	b := make([]byte, 16)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
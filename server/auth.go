@@ -23,6 +23,12 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// BaseCurrencyRequest sets the currency reports and budgets are converted
+// into.
+type BaseCurrencyRequest struct {
+	BaseCurrency string `json:"base_currency"`
+}
+
 type ContextKey string
 
 func (c ContextKey) String() string {
@@ -37,6 +43,8 @@ func (s *APIServer) registerAuth() {
 		r.Get("/me", MakeHandler(s.refresh))
 
 		r.Get("/logout", s.WithUser(MakeHandler(s.logout)))
+
+		r.Put("/base-currency", s.WithUser(MakeHandler(s.updateBaseCurrency)))
 	})
 }
 
@@ -119,8 +127,58 @@ func (s *APIServer) refresh(w http.ResponseWriter, r *http.Request) *Response {
 	return &Response{
 		Status: http.StatusOK,
 		Content: JSON{
-			"userId":   user.ID,
-			"username": user.Username,
+			"userId":        user.ID,
+			"username":      user.Username,
+			"base_currency": user.BaseCurrency,
+		},
+	}
+}
+
+// updateBaseCurrency changes the user's base currency. Existing transactions
+// keep their currencies; reports convert them at the rates on their dates.
+func (s *APIServer) updateBaseCurrency(w http.ResponseWriter, r *http.Request) *Response {
+	req := &BaseCurrencyRequest{}
+
+	err := utils.DecodeBody(r, req)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	currency, err := models.NormalizeCurrency(req.BaseCurrency)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	user.BaseCurrency = currency
+
+	user, err = s.DB.User.Save(user)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": user,
 		},
 	}
 }
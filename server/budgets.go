@@ -1,7 +1,7 @@
 package server
 
 import (
-	"fmt"
+	"errors"
 	"net/http"
 	"time"
 
@@ -11,27 +11,42 @@ import (
 )
 
 type CreateBudgetRequest struct {
-	Category string    `json:"category"`
-	Amount   int       `json:"amount"`
-	Period   time.Time `json:"period"`
+	Category string       `json:"category"`
+	Amount   models.Money `json:"amount"`
+	Period   time.Time    `json:"period"`
 }
 
+// PatchBudgetRequest is a JSON merge patch of a budget. None of its fields
+// can be cleared.
+type PatchBudgetRequest struct {
+	Category models.Patch[string]       `json:"category"`
+	Amount   models.Patch[models.Money] `json:"amount"`
+	Period   models.Patch[time.Time]    `json:"period"`
+}
+
+// BudgetWithUtilization is a budget with what was spent against it, in the
+// budget's currency. When some spending couldn't be converted for want of an
+// exchange rate, Utilization leaves it out and MissingRate says which rate is
+// missing.
 type BudgetWithUtilization struct {
 	*models.Budget
-	Utilization int `json:"utilization"`
+	Utilization models.Money `json:"utilization"`
+	MissingRate string       `json:"missing_rate,omitempty"`
 }
 
 func (s *APIServer) registerBudgets() {
 	s.Router.Route("/api/budgets", func(r chi.Router) {
-		r.Get("/", s.WithUser(MakeHandler(s.getBudgets)))
-		r.Get("/{id}", s.WithUser(MakeHandler(s.getBudget)))
-		r.Get("/utilization/{period}", s.WithUser(MakeHandler(s.getBudgetsWithUtilization)))
+		r.Get("/", s.WithUser(s.WithLedger(MakeHandler(s.getBudgets))))
+		r.Get("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.getBudget))))
+		r.Get("/utilization/{period}", s.WithUser(s.WithLedger(MakeHandler(s.getBudgetsWithUtilization))))
+
+		r.Post("/", s.WithUser(s.WithLedger(MakeHandler(s.createBudget))))
 
-		r.Post("/", s.WithUser(MakeHandler(s.createBudget)))
+		r.Patch("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.patchBudget))))
 
-		r.Delete("/{id}", s.WithUser(MakeHandler(s.deleteBudget)))
+		r.Delete("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.deleteBudget))))
 
-		r.Get("/copy-last-period-budgets", s.WithUser(MakeHandler(s.copyLastPeriodsBudgets)))
+		r.Get("/copy-last-period-budgets", s.WithUser(s.WithLedger(MakeHandler(s.copyLastPeriodsBudgets))))
 	})
 }
 
@@ -44,8 +59,9 @@ func (s *APIServer) copyLastPeriodsBudgets(w http.ResponseWriter, r *http.Reques
 	}
 
 	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
 
-	err = s.copyBudgetsFromPeriod(user.ID, lastPeriod)
+	err = s.copyBudgetsFromPeriod(user.ID, ledger.ID, lastPeriod)
 
 	if err != nil {
 		return &Response{Status: http.StatusBadRequest, Content: JSON{
@@ -61,8 +77,8 @@ func (s *APIServer) copyLastPeriodsBudgets(w http.ResponseWriter, r *http.Reques
 	}
 }
 
-func (s *APIServer) copyBudgetsFromPeriod(userId string, period time.Time) error {
-	budgets, err := s.getBudgetsForPeriod(userId, period)
+func (s *APIServer) copyBudgetsFromPeriod(userId, ledgerId string, period time.Time) error {
+	budgets, err := s.getBudgetsForPeriod(userId, ledgerId, period)
 
 	if err != nil {
 		return err
@@ -89,8 +105,8 @@ func (s *APIServer) copyBudgetsFromPeriod(userId string, period time.Time) error
 	return nil
 }
 
-func (s *APIServer) getBudgetsForPeriod(userId string, period time.Time) ([]*models.Budget, error) {
-	budgets, err := s.DB.Budgets.Find(userId)
+func (s *APIServer) getBudgetsForPeriod(userId, ledgerId string, period time.Time) ([]*models.Budget, error) {
+	budgets, err := s.DB.Budgets.Find(userId, ledgerId)
 
 	if err != nil {
 		return nil, err
@@ -118,8 +134,9 @@ func (s *APIServer) getBudgetsWithUtilization(w http.ResponseWriter, r *http.Req
 	}
 
 	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
 
-	budgets, err := s.getBudgetsForPeriod(user.ID, period)
+	budgets, err := s.getBudgetsForPeriod(user.ID, ledger.ID, period)
 
 	if err != nil {
 		return &Response{
@@ -130,7 +147,7 @@ func (s *APIServer) getBudgetsWithUtilization(w http.ResponseWriter, r *http.Req
 		}
 	}
 
-	allTransactions, err := s.DB.Transactions.Find(user.ID)
+	allTransactions, err := s.DB.Transactions.Find(user.ID, ledger.ID)
 
 	if err != nil {
 		return &Response{
@@ -142,17 +159,35 @@ func (s *APIServer) getBudgetsWithUtilization(w http.ResponseWriter, r *http.Req
 	}
 
 	budgetsWithUtil := []*BudgetWithUtilization{}
+	converters := map[string]*models.Converter{}
 
 	for _, budget := range budgets {
+		converter, ok := converters[budget.Currency]
+
+		if !ok {
+			converter = s.DB.ExchangeRates.Converter(user.ID, budget.Currency)
+			converters[budget.Currency] = converter
+		}
+
 		transactions := s.DB.Transactions.Filter(allTransactions, func(t *models.Transaction) bool {
-			return t.Date.Month() == period.Month() && t.Date.Year() == period.Year() && t.CategoryID == budget.CategoryID
+			return t.Date.Month() == period.Month() && t.Date.Year() == period.Year() && !t.TransferID.Valid
 		})
 
-		tranSum := sumTransactions(transactions)
+		tranSum, missingRate, err := sumTransactions(transactions, budget.CategoryID, converter)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
 
 		budgetWithUtil := &BudgetWithUtilization{
 			Budget:      budget,
 			Utilization: tranSum,
+			MissingRate: missingRate,
 		}
 
 		budgetsWithUtil = append(budgetsWithUtil, budgetWithUtil)
@@ -168,8 +203,9 @@ func (s *APIServer) getBudgetsWithUtilization(w http.ResponseWriter, r *http.Req
 
 func (s *APIServer) getBudgets(w http.ResponseWriter, r *http.Request) *Response {
 	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
 
-	budgets, err := s.DB.Budgets.Find(user.ID)
+	budgets, err := s.DB.Budgets.Find(user.ID, ledger.ID)
 
 	if err != nil {
 		return &Response{
@@ -191,25 +227,17 @@ func (s *APIServer) getBudgets(w http.ResponseWriter, r *http.Request) *Response
 func (s *APIServer) getBudget(w http.ResponseWriter, r *http.Request) *Response {
 	id := chi.URLParam(r, "id")
 	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
 
 	budget, err := s.DB.Budgets.FindOne(&models.Budget{
 		ID: id,
 	})
 
-	if err != nil {
-		return &Response{
-			Status: http.StatusBadRequest,
-			Content: JSON{
-				"error": err.Error(),
-			},
-		}
-	}
-
-	if budget.UserID != user.ID {
+	if err != nil || budget.UserID != user.ID || budget.LedgerID != ledger.ID {
 		return &Response{
 			Status: http.StatusNotFound,
 			Content: JSON{
-				"error": fmt.Errorf("budget not found"),
+				"error": "budget not found",
 			},
 		}
 	}
@@ -237,11 +265,32 @@ func (s *APIServer) createBudget(w http.ResponseWriter, r *http.Request) *Respon
 	}
 
 	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	category, err := s.DB.Categories.FindOne(&models.Category{
+		ID: cbr.Category,
+	})
+
+	if err != nil || category.LedgerID != ledger.ID {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "category not found in ledger",
+			},
+		}
+	}
+
+	amount, resp := amountIn(cbr.Amount, user.BaseCurrency)
+
+	if resp != nil {
+		return resp
+	}
 
 	newBudget := models.Budget{
 		UserID:   user.ID,
+		LedgerID: ledger.ID,
 		Category: cbr.Category,
-		Amount:   cbr.Amount,
+		Amount:   amount,
 		Period:   cbr.Period,
 	}
 
@@ -264,14 +313,71 @@ func (s *APIServer) createBudget(w http.ResponseWriter, r *http.Request) *Respon
 	}
 }
 
-func (s *APIServer) deleteBudget(w http.ResponseWriter, r *http.Request) *Response {
-	id := chi.URLParam(r, "id")
+// patchBudget applies a JSON merge patch to a budget.
+func (s *APIServer) patchBudget(w http.ResponseWriter, r *http.Request) *Response {
+	req := &PatchBudgetRequest{}
+
+	if resp := decodePatch(r, req); resp != nil {
+		return resp
+	}
+
 	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
 
 	budget, err := s.DB.Budgets.FindOne(&models.Budget{
-		ID: id,
+		ID: chi.URLParam(r, "id"),
 	})
 
+	if err != nil || budget.UserID != user.ID || budget.LedgerID != ledger.ID {
+		return &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "budget not found",
+			},
+		}
+	}
+
+	invalid := fieldErrors{}
+
+	if invalid.notNull("category", req.Category.Set, req.Category.Null) {
+		category, err := s.DB.Categories.FindOne(&models.Category{
+			ID: req.Category.Value,
+		})
+
+		if err != nil || category.LedgerID != ledger.ID {
+			invalid["category"] = "category not found in ledger"
+		}
+
+		budget.CategoryID = req.Category.Value
+	}
+
+	if invalid.notNull("amount", req.Amount.Set, req.Amount.Null) {
+		amount, err := req.Amount.Value.In(budget.Currency)
+
+		switch {
+		case err != nil:
+			invalid["amount"] = err.Error()
+		case amount.Sign() < 0:
+			invalid["amount"] = "can't be negative"
+		default:
+			budget.Amount = amount
+		}
+	}
+
+	if invalid.notNull("period", req.Period.Set, req.Period.Null) {
+		if req.Period.Value.IsZero() {
+			invalid["period"] = "period is required"
+		}
+
+		budget.Period = req.Period.Value
+	}
+
+	if resp := invalid.response(); resp != nil {
+		return resp
+	}
+
+	b, err := s.DB.Budgets.Save(budget)
+
 	if err != nil {
 		return &Response{
 			Status: http.StatusBadRequest,
@@ -281,11 +387,28 @@ func (s *APIServer) deleteBudget(w http.ResponseWriter, r *http.Request) *Respon
 		}
 	}
 
-	if budget.UserID != user.ID {
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": b,
+		},
+	}
+}
+
+func (s *APIServer) deleteBudget(w http.ResponseWriter, r *http.Request) *Response {
+	id := chi.URLParam(r, "id")
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	budget, err := s.DB.Budgets.FindOne(&models.Budget{
+		ID: id,
+	})
+
+	if err != nil || budget.UserID != user.ID || budget.LedgerID != ledger.ID {
 		return &Response{
 			Status: http.StatusNotFound,
 			Content: JSON{
-				"error": fmt.Errorf("budget not found"),
+				"error": "budget not found",
 			},
 		}
 	}
@@ -308,10 +431,40 @@ func (s *APIServer) deleteBudget(w http.ResponseWriter, r *http.Request) *Respon
 
 }
 
-func sumTransactions(transactions []*models.Transaction) int {
-	sum := 0
+// sumTransactions adds up the spending on the lines of the transactions that
+// fall in the category, so split transactions only count their matching
+// splits. Refunds are taken off the spending, and income, transfers and
+// adjustments don't count. Each line is converted into the converter's
+// currency at the rate on its transaction's date; lines without a rate are
+// left out, and the first missing rate is described in missingRate.
+func sumTransactions(transactions []*models.Transaction, categoryId string, converter *models.Converter) (sum models.Money, missingRate string, err error) {
+	sum = models.NewMoney(0, converter.Currency)
 	for _, t := range transactions {
-		sum += t.Amount
+		if t.Type != models.TypeExpense && t.Type != models.TypeRefund {
+			continue
+		}
+		for _, line := range t.Lines() {
+			if line.CategoryID == categoryId {
+				amount, err := converter.Convert(line.Amount, t.Date)
+				if errors.Is(err, models.ErrNoExchangeRate) {
+					if missingRate == "" {
+						missingRate = err.Error()
+					}
+					continue
+				}
+				if err != nil {
+					return models.Money{}, "", err
+				}
+				if t.Type == models.TypeRefund {
+					sum, err = sum.Sub(amount)
+				} else {
+					sum, err = sum.Add(amount)
+				}
+				if err != nil {
+					return models.Money{}, "", err
+				}
+			}
+		}
 	}
-	return sum
+	return sum, missingRate, nil
 }
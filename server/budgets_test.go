@@ -0,0 +1,97 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alexgaudon/budgie/models"
+)
+
+func TestSumTransactions(t *testing.T) {
+	const groceries, dining = "groceries", "dining"
+
+	cad := func(minor int64) models.Money {
+		return models.NewMoney(minor, "CAD")
+	}
+
+	line := func(typ, categoryId string, minor int64) *models.Transaction {
+		return &models.Transaction{Type: typ, CategoryID: categoryId, Amount: cad(minor)}
+	}
+
+	split := func(typ string, splits ...*models.Split) *models.Transaction {
+		var total int64
+
+		for _, s := range splits {
+			total += s.Amount.Minor
+		}
+
+		return &models.Transaction{Type: typ, CategoryID: groceries, Amount: cad(total), Splits: splits}
+	}
+
+	tests := []struct {
+		name         string
+		transactions []*models.Transaction
+		want         int64
+	}{
+		{"none", nil, 0},
+		{"expense", []*models.Transaction{line(models.TypeExpense, groceries, 1250)}, 1250},
+		{"other category", []*models.Transaction{line(models.TypeExpense, dining, 1250)}, 0},
+		{"refund", []*models.Transaction{
+			line(models.TypeExpense, groceries, 5000),
+			line(models.TypeRefund, groceries, 1200),
+		}, 3800},
+		{"refund beyond spending", []*models.Transaction{line(models.TypeRefund, groceries, 700)}, -700},
+		{"income", []*models.Transaction{line(models.TypeIncome, groceries, 10000)}, 0},
+		{"transfer", []*models.Transaction{line(models.TypeTransfer, groceries, -3000)}, 0},
+		{"adjustment", []*models.Transaction{line(models.TypeAdjustment, groceries, 3000)}, 0},
+		{"expense split", []*models.Transaction{split(models.TypeExpense,
+			&models.Split{CategoryID: groceries, Amount: cad(3000)},
+			&models.Split{CategoryID: dining, Amount: cad(2000)},
+			&models.Split{CategoryID: groceries, Amount: cad(500)},
+		)}, 3500},
+		{"split in other categories", []*models.Transaction{split(models.TypeExpense,
+			&models.Split{CategoryID: dining, Amount: cad(2000)},
+		)}, 0},
+		{"refund split", []*models.Transaction{split(models.TypeRefund,
+			&models.Split{CategoryID: groceries, Amount: cad(400)},
+			&models.Split{CategoryID: dining, Amount: cad(600)},
+		)}, -400},
+		{"income split", []*models.Transaction{split(models.TypeIncome,
+			&models.Split{CategoryID: groceries, Amount: cad(400)},
+		)}, 0},
+		{"transfer split", []*models.Transaction{split(models.TypeTransfer,
+			&models.Split{CategoryID: groceries, Amount: cad(400)},
+		)}, 0},
+		{"mixed", []*models.Transaction{
+			line(models.TypeExpense, groceries, 8000),
+			line(models.TypeIncome, groceries, 2500),
+			line(models.TypeRefund, groceries, 1000),
+			line(models.TypeTransfer, groceries, 4000),
+			line(models.TypeAdjustment, groceries, -300),
+			split(models.TypeExpense,
+				&models.Split{CategoryID: groceries, Amount: cad(1500)},
+				&models.Split{CategoryID: dining, Amount: cad(900)},
+			),
+		}, 8500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, transaction := range tt.transactions {
+				transaction.Date = time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+			}
+
+			converter := (&models.ExchangeRatesRepo{}).Converter("", "CAD")
+
+			sum, missingRate, err := sumTransactions(tt.transactions, groceries, converter)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if sum.Minor != tt.want || sum.Currency != "CAD" || missingRate != "" {
+				t.Errorf("sumTransactions() = %d %s %q, want %d CAD", sum.Minor, sum.Currency, missingRate, tt.want)
+			}
+		})
+	}
+}
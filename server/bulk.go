@@ -0,0 +1,208 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/alexgaudon/budgie/filter"
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/utils"
+)
+
+// BulkTransactionsRequest applies the operations to either the listed
+// transactions or every transaction matching the filter (see package filter
+// for the syntax), but not both.
+type BulkTransactionsRequest struct {
+	TransactionIDs []string                `json:"transaction_ids"`
+	Filter         string                  `json:"filter"`
+	Operations     []*models.BulkOperation `json:"operations"`
+}
+
+// bulkTransactions changes many transactions at once. Listed transactions
+// must all belong to the ledger; transfers and reconciled transactions
+// matched by a filter are skipped.
+func (s *APIServer) bulkTransactions(w http.ResponseWriter, r *http.Request) *Response {
+	req := &BulkTransactionsRequest{}
+
+	err := utils.DecodeBody(r, &req)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	if len(req.Operations) == 0 {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "no operations given",
+			},
+		}
+	}
+
+	for _, op := range req.Operations {
+		if resp := s.checkBulkOperation(ledger.ID, op); resp != nil {
+			return resp
+		}
+
+		if op.Op == models.BulkDelete && len(req.Operations) > 1 {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "delete can't be combined with other operations",
+				},
+			}
+		}
+	}
+
+	ids, resp := s.bulkTargets(user.ID, ledger.ID, req)
+
+	if resp != nil {
+		return resp
+	}
+
+	result, err := s.DB.Transactions.Bulk(user.ID, ledger.ID, ids, req.Operations)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": result,
+		},
+	}
+}
+
+// bulkTargets resolves the transactions a bulk request applies to.
+func (s *APIServer) bulkTargets(userId, ledgerId string, req *BulkTransactionsRequest) ([]string, *Response) {
+	if (len(req.TransactionIDs) == 0) == (req.Filter == "") {
+		return nil, &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "give either transaction_ids or filter",
+			},
+		}
+	}
+
+	if len(req.TransactionIDs) > 0 {
+		if resp := s.checkLedgerTransactions(userId, ledgerId, req.TransactionIDs); resp != nil {
+			return nil, resp
+		}
+
+		return req.TransactionIDs, nil
+	}
+
+	query, err := filter.Parse(req.Filter)
+
+	if err != nil {
+		content := JSON{
+			"error": err.Error(),
+		}
+
+		if syntaxErr, ok := err.(*filter.Error); ok {
+			content["position"] = syntaxErr.Pos
+		}
+
+		return nil, &Response{
+			Status:  http.StatusBadRequest,
+			Content: content,
+		}
+	}
+
+	// A blank filter would match the whole ledger, which is more likely a
+	// mistake than intended.
+	if query == nil {
+		return nil, &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "filter is empty",
+			},
+		}
+	}
+
+	transactions, err := s.DB.Transactions.FindFiltered(userId, ledgerId, query)
+
+	if err != nil {
+		return nil, &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	ids := []string{}
+
+	for _, t := range transactions {
+		if !t.TransferID.Valid && !t.Locked() {
+			ids = append(ids, t.ID)
+		}
+	}
+
+	return ids, nil
+}
+
+// checkBulkOperation validates an operation before anything is changed,
+// normalizing its tags.
+func (s *APIServer) checkBulkOperation(ledgerId string, op *models.BulkOperation) *Response {
+	var message string
+
+	switch op.Op {
+	case models.BulkRecategorize:
+		category, err := s.DB.Categories.FindOne(&models.Category{
+			ID: op.CategoryID,
+		})
+
+		if err != nil || category.LedgerID != ledgerId {
+			message = "category not found in ledger"
+		}
+	case models.BulkSetType:
+		if !entryType(op.Type) {
+			message = entryTypeError
+		}
+	case models.BulkSetStatus:
+		if op.Status != models.StatusPending && op.Status != models.StatusCleared {
+			message = "status must be pending or cleared"
+		}
+	case models.BulkAddTags, models.BulkRemoveTags:
+		op.Tags = models.NormalizeTags(op.Tags)
+
+		if len(op.Tags) == 0 {
+			message = "no tags given"
+		} else if err := models.ValidateTags(op.Tags); err != nil {
+			message = err.Error()
+		}
+	case models.BulkSetDate:
+		if op.Date.IsZero() {
+			message = "date is required"
+		}
+	case models.BulkDelete:
+	default:
+		message = fmt.Sprintf("unknown operation %q", op.Op)
+	}
+
+	if message == "" {
+		return nil
+	}
+
+	return &Response{
+		Status: http.StatusBadRequest,
+		Content: JSON{
+			"error": message,
+		},
+	}
+}
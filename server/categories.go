@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/alexgaudon/budgie/models"
 	"github.com/alexgaudon/budgie/utils"
@@ -13,21 +14,29 @@ type CreateCategoryRequest struct {
 	Name string `json:"name"`
 }
 
+// PatchCategoryRequest is a JSON merge patch of a category.
+type PatchCategoryRequest struct {
+	Name models.Patch[string] `json:"name"`
+}
+
 func (s *APIServer) registerCategories() {
 	s.Router.Route("/api/categories", func(r chi.Router) {
-		r.Get("/", s.WithUser(MakeHandler(s.getCategories)))
-		r.Get("/{id}", s.WithUser(MakeHandler(s.getCategory)))
+		r.Get("/", s.WithUser(s.WithLedger(MakeHandler(s.getCategories))))
+		r.Get("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.getCategory))))
+
+		r.Post("/", s.WithUser(s.WithLedger(MakeHandler(s.createCategory))))
 
-		r.Post("/", s.WithUser(MakeHandler(s.createCategory)))
+		r.Patch("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.patchCategory))))
 
-		r.Delete("/{id}", s.WithUser(MakeHandler(s.deleteCategory)))
+		r.Delete("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.deleteCategory))))
 	})
 }
 
 func (s *APIServer) getCategories(w http.ResponseWriter, r *http.Request) *Response {
 	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
 
-	categories, err := s.DB.Categories.Find(user.ID)
+	categories, err := s.DB.Categories.Find(user.ID, ledger.ID)
 	if err != nil {
 		return &Response{
 			Status: http.StatusBadRequest,
@@ -95,10 +104,12 @@ func (s *APIServer) createCategory(w http.ResponseWriter, r *http.Request) *Resp
 	}
 
 	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
 
 	newCategory := models.Category{
-		Name:   ccr.Name,
-		UserID: user.ID,
+		Name:     ccr.Name,
+		UserID:   user.ID,
+		LedgerID: ledger.ID,
 	}
 
 	c, err := s.DB.Categories.Save(&newCategory)
@@ -120,6 +131,63 @@ func (s *APIServer) createCategory(w http.ResponseWriter, r *http.Request) *Resp
 	}
 }
 
+// patchCategory applies a JSON merge patch to a category.
+func (s *APIServer) patchCategory(w http.ResponseWriter, r *http.Request) *Response {
+	req := &PatchCategoryRequest{}
+
+	if resp := decodePatch(r, req); resp != nil {
+		return resp
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	category, err := s.DB.Categories.FindOne(&models.Category{
+		ID: chi.URLParam(r, "id"),
+	})
+
+	if err != nil || category.UserID != user.ID || category.LedgerID != ledger.ID {
+		return &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "category not found",
+			},
+		}
+	}
+
+	invalid := fieldErrors{}
+
+	if invalid.notNull("name", req.Name.Set, req.Name.Null) {
+		category.Name = strings.TrimSpace(req.Name.Value)
+
+		if category.Name == "" {
+			invalid["name"] = "name is required"
+		}
+	}
+
+	if resp := invalid.response(); resp != nil {
+		return resp
+	}
+
+	c, err := s.DB.Categories.Save(category)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": c,
+		},
+	}
+}
+
 func (s *APIServer) deleteCategory(w http.ResponseWriter, r *http.Request) *Response {
 	id := chi.URLParam(r, "id")
 	user := r.Context().Value(ContextKey("user")).(*models.User)
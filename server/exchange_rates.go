@@ -0,0 +1,308 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alexgaudon/budgie/importer"
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// CreateExchangeRateRequest enters a rate by hand: one unit of Base was worth
+// Rate units of Quote on Date. Rate is a decimal string so it isn't rounded
+// through a float.
+type CreateExchangeRateRequest struct {
+	Base  string    `json:"base"`
+	Quote string    `json:"quote"`
+	Date  time.Time `json:"date"`
+	Rate  string    `json:"rate"`
+}
+
+// Exchange rates belong to the user rather than a ledger, so every ledger
+// converts with the same rates.
+func (s *APIServer) registerExchangeRates() {
+	s.Router.Route("/api/exchange-rates", func(r chi.Router) {
+		r.Get("/", s.WithUser(MakeHandler(s.getExchangeRates)))
+
+		r.Post("/", s.WithUser(MakeHandler(s.createExchangeRate)))
+		r.Post("/import", s.WithUser(MakeHandler(s.importExchangeRates)))
+
+		r.Delete("/{id}", s.WithUser(MakeHandler(s.deleteExchangeRate)))
+	})
+}
+
+// getExchangeRates lists the user's rates, latest first. The optional base and
+// quote query parameters pick currencies, and from and to (YYYY-MM-DD,
+// inclusive) limit the date range.
+func (s *APIServer) getExchangeRates(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+
+	var base, quote string
+
+	for _, param := range []struct {
+		name  string
+		value *string
+	}{{"base", &base}, {"quote", &quote}} {
+		code := r.URL.Query().Get(param.name)
+
+		if code == "" {
+			continue
+		}
+
+		currency, err := models.NormalizeCurrency(code)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+
+		*param.value = currency
+	}
+
+	var from, to sql.NullTime
+
+	for _, param := range []struct {
+		name  string
+		value *sql.NullTime
+	}{{"from", &from}, {"to", &to}} {
+		dateString := r.URL.Query().Get(param.name)
+
+		if dateString == "" {
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02", dateString)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+
+		*param.value = sql.NullTime{Time: date, Valid: true}
+	}
+
+	rates, err := s.DB.ExchangeRates.Find(user.ID, base, quote, from, to)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": rates,
+		},
+	}
+}
+
+// createExchangeRate stores a manual rate, replacing any rate already stored
+// for the same currencies and day.
+func (s *APIServer) createExchangeRate(w http.ResponseWriter, r *http.Request) *Response {
+	req := &CreateExchangeRateRequest{}
+
+	err := utils.DecodeBody(r, req)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+
+	rate, message := newExchangeRate(user.ID, req.Base, req.Quote, req.Date, req.Rate, models.RateManual)
+
+	if message != "" {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": message,
+			},
+		}
+	}
+
+	rate, err = s.DB.ExchangeRates.Save(rate)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusCreated,
+		Content: JSON{
+			"data": rate,
+		},
+	}
+}
+
+// importExchangeRates loads a European Central Bank reference rate file, XML
+// or CSV, uploaded as "file" in a multipart form. Every rate is stored against
+// the euro; other pairs are crossed through it when converting.
+func (s *APIServer) importExchangeRates(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	file, _, err := r.FormFile("file")
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "file is required",
+			},
+		}
+	}
+
+	defer file.Close()
+
+	parsed, err := importer.ParseECB(file)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	rates := []*models.ExchangeRate{}
+
+	for _, p := range parsed {
+		rate, message := newExchangeRate(user.ID, importer.ECBBase, p.Currency, p.Date, p.Rate, models.RateECB)
+
+		if message != "" {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": p.Date.Format("2006-01-02") + ": " + message,
+				},
+			}
+		}
+
+		rates = append(rates, rate)
+	}
+
+	imported, err := s.DB.ExchangeRates.SaveAll(user.ID, rates)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"imported": imported,
+		},
+	}
+}
+
+func (s *APIServer) deleteExchangeRate(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+
+	rate, err := s.DB.ExchangeRates.FindOne(&models.ExchangeRate{
+		ID: chi.URLParam(r, "id"),
+	})
+
+	if err != nil || rate.UserID != user.ID {
+		return &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "exchange rate not found",
+			},
+		}
+	}
+
+	if err := s.DB.ExchangeRates.Delete(rate.ID); err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"deleted": rate.ID,
+		},
+	}
+}
+
+// newExchangeRate validates a rate, returning why it's invalid if it is. The
+// date is kept to the day.
+func newExchangeRate(userId, base, quote string, date time.Time, value, source string) (*models.ExchangeRate, string) {
+	base, err := models.NormalizeCurrency(base)
+
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	quote, err = models.NormalizeCurrency(quote)
+
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	if base == quote {
+		return nil, "base and quote currencies must differ"
+	}
+
+	if date.IsZero() {
+		return nil, "date is required"
+	}
+
+	if _, err := models.ParseRate(value); err != nil {
+		return nil, err.Error()
+	}
+
+	return &models.ExchangeRate{
+		UserID: userId,
+		Base:   base,
+		Quote:  quote,
+		Date:   time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC),
+		Rate:   strings.TrimSpace(value),
+		Source: source,
+	}, ""
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/alexgaudon/budgie/importer"
+	"github.com/alexgaudon/budgie/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// qifAccountKinds maps account kinds to QIF account types.
+var qifAccountKinds = map[string]string{
+	"checking": "Bank",
+	"savings":  "Bank",
+	"credit":   "CCard",
+	"cash":     "Cash",
+}
+
+func (s *APIServer) registerExports() {
+	s.Router.Route("/api/exports", func(r chi.Router) {
+		r.Get("/qif", s.WithUser(s.WithLedger(s.exportQIF)))
+	})
+}
+
+// exportQIF downloads the ledger's categories and transactions as a QIF file,
+// with one section per account and transactions without an account in a
+// leading bank section.
+func (s *APIServer) exportQIF(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	categories, err := s.DB.Categories.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, JSON{"error": err.Error()})
+		return
+	}
+
+	accounts, err := s.DB.Accounts.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, JSON{"error": err.Error()})
+		return
+	}
+
+	transactions, err := s.DB.Transactions.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, JSON{"error": err.Error()})
+		return
+	}
+
+	categoryNames := []string{}
+
+	for _, category := range categories {
+		categoryNames = append(categoryNames, category.Name)
+	}
+
+	unassigned := &importer.QIFAccount{Kind: "Bank"}
+	qifAccounts := []*importer.QIFAccount{unassigned}
+	byAccount := map[string]*importer.QIFAccount{}
+
+	for _, account := range accounts {
+		qifAccount := &importer.QIFAccount{
+			Name: account.Name,
+			Kind: qifAccountKinds[account.Kind],
+		}
+
+		byAccount[account.ID] = qifAccount
+		qifAccounts = append(qifAccounts, qifAccount)
+	}
+
+	// Transactions are listed newest first; QIF files read better oldest first.
+	for i := len(transactions) - 1; i >= 0; i-- {
+		t := transactions[i]
+
+		qifAccount, ok := byAccount[t.AccountID.String]
+
+		if !ok {
+			qifAccount = unassigned
+		}
+
+		qifAccount.Rows = append(qifAccount.Rows, qifRowFromTransaction(t))
+	}
+
+	if len(unassigned.Rows) == 0 {
+		qifAccounts = qifAccounts[1:]
+	}
+
+	w.Header().Set("Content-Type", "application/qif")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", ledger.Name+".qif"))
+
+	err = importer.WriteQIF(w, categoryNames, qifAccounts)
+
+	if err != nil {
+		log.Println("ERROR:", err)
+	}
+}
+
+// qifRowFromTransaction signs the amount by the transaction type and writes
+// transfer legs against the other account, as QIF expects. Amounts keep all
+// the decimals of their currency.
+func qifRowFromTransaction(t *models.Transaction) *importer.Row {
+	signed := func(amount models.Money) models.Money {
+		if t.Type == models.TypeExpense {
+			amount.Minor = -amount.Minor
+		}
+		return amount
+	}
+
+	row := &importer.Row{
+		Date:        t.Date,
+		Amount:      signed(t.Amount),
+		Vendor:      t.Vendor,
+		Description: t.Description.String,
+		Category:    t.Category,
+	}
+
+	if t.TransferID.Valid {
+		row.Category = "[" + t.Vendor + "]"
+	}
+
+	for _, split := range t.Splits {
+		row.Splits = append(row.Splits, &importer.RowSplit{
+			Category: split.Category,
+			Amount:   signed(split.Amount),
+			Memo:     split.Memo.String,
+		})
+	}
+
+	return row
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type UpdateImportRowRequest struct {
+	Date        time.Time             `json:"date"`
+	Amount      models.Money          `json:"amount"`
+	Type        string                `json:"type"`
+	Vendor      string                `json:"vendor"`
+	Description models.OptionalString `json:"description"`
+	CategoryID  string                `json:"category_id"`
+	Splits      []*CreateSplitRequest `json:"splits"`
+	Tags        []string              `json:"tags"`
+	Excluded    bool                  `json:"excluded"`
+}
+
+func (s *APIServer) getImportBatches(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	batches, err := s.DB.ImportBatches.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": batches,
+		},
+	}
+}
+
+func (s *APIServer) getImportBatch(w http.ResponseWriter, r *http.Request) *Response {
+	batch, resp := s.findUserImportBatch(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": batch,
+		},
+	}
+}
+
+// updateImportRow replaces the editable fields of a staged row, or excludes
+// it from the commit.
+func (s *APIServer) updateImportRow(w http.ResponseWriter, r *http.Request) *Response {
+	batch, resp := s.findUserImportBatch(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	if batch.Status != models.ImportStaged {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "only staged imports can be edited",
+			},
+		}
+	}
+
+	row, err := s.DB.ImportBatches.FindRow(chi.URLParam(r, "rowId"))
+
+	if err != nil || row.BatchID != batch.ID {
+		return &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "import row not found",
+			},
+		}
+	}
+
+	// Rows that failed to parse can only be fixed by re-uploading the file.
+	if row.Error.Valid {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "rows that failed to import can't be edited",
+			},
+		}
+	}
+
+	req := &UpdateImportRowRequest{}
+
+	err = utils.DecodeBody(r, &req)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	if !entryType(req.Type) {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": entryTypeError,
+			},
+		}
+	}
+
+	category, err := s.DB.Categories.FindOne(&models.Category{
+		ID: req.CategoryID,
+	})
+
+	if err != nil || category.LedgerID != batch.LedgerID {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "category not found in ledger",
+			},
+		}
+	}
+
+	amount, resp := amountIn(req.Amount, batch.Currency)
+
+	if resp != nil {
+		return resp
+	}
+
+	splits, resp := s.transactionSplits(batch.LedgerID, batch.Currency, req.Splits)
+
+	if resp != nil {
+		return resp
+	}
+
+	check := &models.Transaction{
+		Amount: amount,
+		Splits: splits,
+	}
+
+	if err := check.ValidateSplits(); err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	if err := models.ValidateTags(models.NormalizeTags(req.Tags)); err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	payees, err := s.DB.Payees.Find(batch.UserID, batch.LedgerID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	payee := models.OptionalString{}
+
+	if match := models.MatchPayee(payees, req.Vendor); match != nil {
+		payee = models.OptionalString{String: match.ID, Valid: true}
+	}
+
+	row.Date = req.Date
+	row.Amount = amount
+	row.Type = req.Type
+	row.Vendor = req.Vendor
+	row.PayeeID = payee
+	row.Description = req.Description
+	row.Category = category.Name
+	row.CategoryID = category.ID
+	row.NewCategory = ""
+	row.Splits = splits
+	row.Excluded = req.Excluded
+
+	// Tags are only replaced when the request includes them.
+	if req.Tags != nil {
+		row.Tags = models.NormalizeTags(req.Tags)
+	}
+
+	row, err = s.DB.ImportBatches.SaveRow(row)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": row,
+		},
+	}
+}
+
+// commitImportBatch writes the included rows of a staged batch to the ledger
+// in one database transaction.
+func (s *APIServer) commitImportBatch(w http.ResponseWriter, r *http.Request) *Response {
+	batch, resp := s.findUserImportBatch(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	summary, err := s.DB.ImportBatches.Commit(batch)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data":    batch,
+			"summary": summary,
+		},
+	}
+}
+
+// deleteImportBatch discards a staged batch, or undoes a committed one by
+// removing every transaction it created. Undone batches are kept for
+// reference until deleted again.
+func (s *APIServer) deleteImportBatch(w http.ResponseWriter, r *http.Request) *Response {
+	batch, resp := s.findUserImportBatch(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	var err error
+
+	if batch.Status == models.ImportCommitted {
+		err = s.DB.ImportBatches.Revert(batch)
+	} else {
+		err = s.DB.ImportBatches.Delete(batch.ID)
+	}
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"deleted": batch.ID,
+		},
+	}
+}
+
+// findUserImportBatch loads the batch in the URL, with its rows, checking it
+// belongs to the user and the request's ledger.
+func (s *APIServer) findUserImportBatch(r *http.Request) (*models.ImportBatch, *Response) {
+	id := chi.URLParam(r, "id")
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	batch, err := s.DB.ImportBatches.FindOne(&models.ImportBatch{
+		ID: id,
+	})
+
+	if err != nil || batch.UserID != user.ID || batch.LedgerID != ledger.ID {
+		return nil, &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "import not found",
+			},
+		}
+	}
+
+	return batch, nil
+}
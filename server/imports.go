@@ -0,0 +1,809 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/alexgaudon/budgie/classifier"
+	"github.com/alexgaudon/budgie/importer"
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxImportSize is the largest statement file accepted for import.
+const maxImportSize = 10 << 20
+
+// fuzzyDuplicateDays is how far apart, in days, a staged row and an existing
+// transaction of the same amount can be and still be flagged as a possible
+// duplicate.
+const fuzzyDuplicateDays = 2
+
+// importOptions are the form fields shared by every import endpoint.
+// CategoryID is used for rows whose category can't be resolved from the file,
+// unless CreateCategories asks for missing categories to be created.
+// Currency is the account's, or the user's base currency for imports without
+// an account; files are read in it. CardAccount is set when the rows are for
+// a credit card account.
+type importOptions struct {
+	CategoryID       string
+	AccountID        models.OptionalString
+	CreateCategories bool
+	Currency         string
+	CardAccount      bool
+}
+
+func (s *APIServer) registerImports() {
+	s.Router.Route("/api/imports", func(r chi.Router) {
+		r.Post("/csv", s.WithUser(s.WithLedger(MakeHandler(s.importCSV))))
+		r.Post("/ofx", s.WithUser(s.WithLedger(MakeHandler(s.importStatement("ofx", importer.ParseOFX)))))
+		r.Post("/camt053", s.WithUser(s.WithLedger(MakeHandler(s.importStatement("camt053", importer.ParseCamt053)))))
+		r.Post("/mt940", s.WithUser(s.WithLedger(MakeHandler(s.importStatement("mt940", importer.ParseMT940)))))
+		r.Post("/qif", s.WithUser(s.WithLedger(MakeHandler(s.importQIF))))
+
+		r.Get("/", s.WithUser(s.WithLedger(MakeHandler(s.getImportBatches))))
+		r.Get("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.getImportBatch))))
+		r.Put("/{id}/rows/{rowId}", s.WithUser(s.WithLedger(MakeHandler(s.updateImportRow))))
+		r.Post("/{id}/commit", s.WithUser(s.WithLedger(MakeHandler(s.commitImportBatch))))
+		r.Delete("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.deleteImportBatch))))
+
+		r.Get("/profiles", s.WithUser(MakeHandler(s.getImportProfiles)))
+		r.Get("/profiles/{id}", s.WithUser(MakeHandler(s.getImportProfile)))
+		r.Post("/profiles", s.WithUser(MakeHandler(s.createImportProfile)))
+		r.Put("/profiles/{id}", s.WithUser(MakeHandler(s.updateImportProfile)))
+		r.Delete("/profiles/{id}", s.WithUser(MakeHandler(s.deleteImportProfile)))
+	})
+}
+
+// importCSV stages a multipart upload with the statement in "file" and the
+// column mapping either saved ("profile_id") or inline as JSON ("profile").
+func (s *APIServer) importCSV(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+
+	opts, resp := s.parseImportForm(w, r)
+
+	if resp != nil {
+		return resp
+	}
+
+	profile := &models.ImportProfile{}
+
+	if id := r.FormValue("profile_id"); id != "" {
+		p, err := s.DB.ImportProfiles.FindOne(&models.ImportProfile{
+			ID: id,
+		})
+
+		if err != nil || p.UserID != user.ID {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "import profile not found",
+				},
+			}
+		}
+
+		profile = p
+	} else {
+		*profile = defaultImportProfile()
+
+		err := json.Unmarshal([]byte(r.FormValue("profile")), profile)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "profile_id or a valid profile is required",
+				},
+			}
+		}
+	}
+
+	file, header, err := r.FormFile("file")
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	defer file.Close()
+
+	rows, err := importer.ParseCSV(file, profile, opts.Currency)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return s.stageImport(r, "csv", header.Filename, rows, opts)
+}
+
+// importStatement stages a bank statement uploaded as "file", read with the
+// given parser. It backs the formats that need no options beyond the shared
+// ones: OFX/QFX, camt.053 and MT940.
+func (s *APIServer) importStatement(format string, parse func(io.Reader, string) ([]*importer.Row, error)) func(w http.ResponseWriter, r *http.Request) *Response {
+	return func(w http.ResponseWriter, r *http.Request) *Response {
+		opts, resp := s.parseImportForm(w, r)
+
+		if resp != nil {
+			return resp
+		}
+
+		file, header, err := r.FormFile("file")
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+
+		defer file.Close()
+
+		rows, err := parse(file, opts.Currency)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+
+		return s.stageImport(r, format, header.Filename, rows, opts)
+	}
+}
+
+// importQIF stages a QIF file uploaded as "file". Dates are read as M/D/Y
+// unless "day_first" is true. Categories the rows use are created when the
+// batch is committed if "create_categories" is true.
+func (s *APIServer) importQIF(w http.ResponseWriter, r *http.Request) *Response {
+	opts, resp := s.parseImportForm(w, r)
+
+	if resp != nil {
+		return resp
+	}
+
+	file, header, err := r.FormFile("file")
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	defer file.Close()
+
+	rows, _, err := importer.ParseQIF(file, r.FormValue("day_first") == "true", opts.Currency)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return s.stageImport(r, "qif", header.Filename, rows, opts)
+}
+
+// parseImportForm reads the multipart form and the options shared by all
+// import endpoints, checking that they belong to the request's ledger.
+func (s *APIServer) parseImportForm(w http.ResponseWriter, r *http.Request) (*importOptions, *Response) {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		return nil, &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	opts := &importOptions{
+		CategoryID:       r.FormValue("category_id"),
+		CreateCategories: r.FormValue("create_categories") == "true",
+		Currency:         user.BaseCurrency,
+	}
+
+	if accountId := r.FormValue("account_id"); accountId != "" {
+		opts.AccountID = models.OptionalString{String: accountId, Valid: true}
+	}
+
+	if resp := s.checkTransactionAccount(ledger.ID, opts.AccountID); resp != nil {
+		return nil, resp
+	}
+
+	if opts.AccountID.Valid {
+		account, err := s.DB.Accounts.FindOne(&models.Account{
+			ID: opts.AccountID.String,
+		})
+
+		if err != nil {
+			return nil, &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+
+		opts.Currency = account.Currency
+		opts.CardAccount = account.Kind == "credit"
+	}
+
+	if opts.CategoryID != "" {
+		category, err := s.DB.Categories.FindOne(&models.Category{
+			ID: opts.CategoryID,
+		})
+
+		if err != nil || category.LedgerID != ledger.ID {
+			return nil, &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "category not found in ledger",
+				},
+			}
+		}
+	}
+
+	return opts, nil
+}
+
+// stageImport stores the parsed rows as a staged batch for review, with the
+// suggested category of every row, from the file, the ledger's rules or the
+// categories of past transactions, and its duplicate flags. Rows whose
+// fingerprint was already imported start out excluded; rows resembling
+// existing transactions, imported or entered by hand, list them as possible
+// duplicates. Nothing is written to the ledger until the batch is committed.
+func (s *APIServer) stageImport(r *http.Request, format, filename string, rows []*importer.Row, opts *importOptions) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	categories, err := s.newImportCategories(user.ID, ledger.ID, opts)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	rules, err := s.DB.Rules.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	payees, err := s.DB.Payees.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	model, _, err := s.trainClassifier(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	importer.Fingerprint(rows)
+
+	batch := &models.ImportBatch{
+		UserID:    user.ID,
+		LedgerID:  ledger.ID,
+		AccountID: opts.AccountID,
+		Currency:  opts.Currency,
+		Format:    format,
+		Filename:  filename,
+	}
+
+	for _, row := range rows {
+		staged, err := s.stageRow(batch, row, categories, rules, payees, model)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+
+		batch.Rows = append(batch.Rows, staged)
+	}
+
+	batch, err = s.DB.ImportBatches.Create(batch)
+
+	if err == nil {
+		batch, err = s.DB.ImportBatches.FindOne(batch)
+	}
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusCreated,
+		Content: JSON{
+			"data": batch,
+		},
+	}
+}
+
+// stageRow turns a parsed row into a staged one in the batch's currency.
+// Problems with the row itself are recorded on it; the returned error is only
+// set when the database can't be queried.
+func (s *APIServer) stageRow(batch *models.ImportBatch, row *importer.Row, categories *importCategories, rules []*models.TransactionRule, payees []*models.Payee, model *classifier.Model) (*models.ImportRow, error) {
+	staged := &models.ImportRow{
+		Line:        row.Line,
+		Date:        row.Date,
+		Amount:      models.NewMoney(0, batch.Currency),
+		Type:        row.Type,
+		Vendor:      row.Vendor,
+		Description: models.OptionalString{String: row.Description, Valid: row.Description != ""},
+		ExternalID:  models.OptionalString{String: row.ExternalID, Valid: row.ExternalID != ""},
+		Fingerprint: models.OptionalString{String: row.Fingerprint, Valid: row.Fingerprint != ""},
+	}
+
+	if row.Err != nil {
+		staged.Error = models.OptionalString{String: row.Err.Error(), Valid: true}
+		staged.Excluded = true
+		return staged, nil
+	}
+
+	t, err := transactionFromRow(row, categories, batch.Currency)
+
+	if err != nil {
+		staged.Error = models.OptionalString{String: err.Error(), Valid: true}
+		staged.Excluded = true
+		return staged, nil
+	}
+
+	staged.Amount = t.Amount
+
+	t.UserID = batch.UserID
+	t.LedgerID = batch.LedgerID
+	t.AccountID = batch.AccountID
+
+	// A category from the file wins over rules, then the payee's default,
+	// then what the classifier learned, then the default for the import.
+	setCategory := row.Category == "" && len(row.Splits) == 0
+	result := applyRules(rules, t, setCategory)
+	payee := linkPayee(payees, t)
+
+	if setCategory && !result.CategoryID.Valid && payee != nil && payee.DefaultCategoryID.Valid {
+		t.CategoryID = payee.DefaultCategoryID.String
+	} else if setCategory && !result.CategoryID.Valid {
+		if suggestion := model.Best(t.Vendor, t.Description.String, int(t.Amount.Cents())); suggestion != nil {
+			t.CategoryID = suggestion.CategoryID
+		}
+	}
+
+	if t.CategoryID == "" && t.Category == "" {
+		err = fmt.Errorf("no category for row and no default category given")
+	} else {
+		err = validateStagedSplits(t)
+	}
+
+	if err != nil {
+		staged.Error = models.OptionalString{String: err.Error(), Valid: true}
+		staged.Excluded = true
+		return staged, nil
+	}
+
+	// Money coming back into a category that's spent from is a refund.
+	if t.Type == models.TypeIncome && categories.spending[t.CategoryID] {
+		t.Type = models.TypeRefund
+	}
+
+	staged.Type = t.Type
+	staged.Vendor = t.Vendor
+	staged.PayeeID = t.PayeeID
+	staged.Tags = t.Tags
+	staged.CategoryID = t.CategoryID
+	staged.Splits = t.Splits
+
+	if t.CategoryID == "" {
+		staged.NewCategory = t.Category
+	}
+
+	exists, err := s.DB.Transactions.FingerprintExists(batch.LedgerID, batch.AccountID, row.Fingerprint)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		staged.Duplicate = true
+		staged.Excluded = true
+		return staged, nil
+	}
+
+	similar, err := s.DB.Transactions.FindSimilar(t, fuzzyDuplicateDays, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, match := range similar {
+		staged.PossibleDuplicates = append(staged.PossibleDuplicates, match.ID)
+	}
+
+	return staged, nil
+}
+
+// validateStagedSplits checks the splits of a staged transaction, counting
+// splits for categories that are only created on commit as categorized.
+func validateStagedSplits(t *models.Transaction) error {
+	check := *t
+	check.Splits = nil
+
+	for _, split := range t.Splits {
+		split := *split
+
+		if split.CategoryID == "" {
+			split.CategoryID = split.Category
+		}
+
+		check.Splits = append(check.Splits, &split)
+	}
+
+	return check.ValidateSplits()
+}
+
+// transactionFromRow builds the transaction for an imported row in the
+// currency, resolving the row's categories. Categories that are still to be
+// created are left with only their name. Amounts are stored unsigned with
+// the direction in the type, so split amounts are flipped along with the
+// parent's. Money coming back onto a card is a refund rather than income.
+func transactionFromRow(row *importer.Row, categories *importCategories, currency string) (*models.Transaction, error) {
+	categoryId, err := categories.resolve(row.Category)
+
+	if err != nil {
+		return nil, err
+	}
+
+	amount, err := row.AbsAmount().In(currency)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := &models.Transaction{
+		CategoryID:  categoryId,
+		Amount:      amount,
+		ExternalID:  models.OptionalString{String: row.ExternalID, Valid: row.ExternalID != ""},
+		Fingerprint: models.OptionalString{String: row.Fingerprint, Valid: row.Fingerprint != ""},
+		Description: models.OptionalString{String: row.Description, Valid: row.Description != ""},
+		Vendor:      row.Vendor,
+		Date:        row.Date,
+		Type:        row.Type,
+	}
+
+	if categoryId == "" {
+		t.Category = row.Category
+	}
+
+	if t.Type == models.TypeIncome && categories.opts.CardAccount {
+		t.Type = models.TypeRefund
+	}
+
+	for _, rowSplit := range row.Splits {
+		categoryId, err := categories.resolve(rowSplit.Category)
+
+		if err != nil {
+			return nil, err
+		}
+
+		amount, err := rowSplit.Amount.In(currency)
+
+		if err == nil && row.Amount.Sign() < 0 {
+			amount, err = amount.Neg()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		split := &models.Split{
+			CategoryID: categoryId,
+			Amount:     amount,
+			Memo:       models.OptionalString{String: rowSplit.Memo, Valid: rowSplit.Memo != ""},
+		}
+
+		if categoryId == "" {
+			split.Category = rowSplit.Category
+		}
+
+		t.Splits = append(t.Splits, split)
+	}
+
+	return t, nil
+}
+
+// importCategories resolves category names from import files to categories
+// of the ledger, falling back to the import's default category for names it
+// doesn't know. spending holds the categories money is spent from, as given
+// by SpendingCategories.
+type importCategories struct {
+	opts     *importOptions
+	byName   map[string]string
+	spending map[string]bool
+}
+
+func (s *APIServer) newImportCategories(userId, ledgerId string, opts *importOptions) (*importCategories, error) {
+	categories, err := s.DB.Categories.Find(userId, ledgerId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	spending, err := s.DB.Categories.SpendingCategories(ledgerId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := &importCategories{
+		opts:     opts,
+		byName:   map[string]string{},
+		spending: spending,
+	}
+
+	for _, category := range categories {
+		c.byName[strings.ToLower(category.Name)] = category.ID
+	}
+
+	return c, nil
+}
+
+// resolve returns the id of the named category. When the import creates
+// missing categories, unknown names resolve to no id: the rows keep the name
+// and the category is only created when the batch is committed.
+func (c *importCategories) resolve(name string) (string, error) {
+	if id, ok := c.byName[strings.ToLower(name)]; ok {
+		return id, nil
+	}
+
+	// Rows without a category may still be categorized by rules.
+	if name == "" {
+		return c.opts.CategoryID, nil
+	}
+
+	if c.opts.CreateCategories {
+		return "", nil
+	}
+
+	if c.opts.CategoryID == "" {
+		return "", fmt.Errorf("no category for row and no default category given")
+	}
+
+	return c.opts.CategoryID, nil
+}
+
+func defaultImportProfile() models.ImportProfile {
+	return models.ImportProfile{
+		HasHeader:        true,
+		Delimiter:        ",",
+		Encoding:         "utf-8",
+		DateFormat:       "YYYY-MM-DD",
+		SignConvention:   models.SignNegativeIsExpense,
+		DecimalSeparator: ".",
+	}
+}
+
+func (s *APIServer) getImportProfiles(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+
+	profiles, err := s.DB.ImportProfiles.Find(user.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": profiles,
+		},
+	}
+}
+
+func (s *APIServer) getImportProfile(w http.ResponseWriter, r *http.Request) *Response {
+	profile, resp := s.findUserImportProfile(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": profile,
+		},
+	}
+}
+
+func (s *APIServer) createImportProfile(w http.ResponseWriter, r *http.Request) *Response {
+	profile := defaultImportProfile()
+
+	err := utils.DecodeBody(r, &profile)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+
+	profile.ID = ""
+	profile.UserID = user.ID
+
+	p, err := s.DB.ImportProfiles.Save(&profile)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": p,
+		},
+	}
+}
+
+func (s *APIServer) updateImportProfile(w http.ResponseWriter, r *http.Request) *Response {
+	existing, resp := s.findUserImportProfile(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	profile := defaultImportProfile()
+
+	err := utils.DecodeBody(r, &profile)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	profile.ID = existing.ID
+	profile.UserID = existing.UserID
+
+	p, err := s.DB.ImportProfiles.Save(&profile)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": p,
+		},
+	}
+}
+
+func (s *APIServer) deleteImportProfile(w http.ResponseWriter, r *http.Request) *Response {
+	profile, resp := s.findUserImportProfile(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	err := s.DB.ImportProfiles.Delete(profile.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"deleted": profile.ID,
+		},
+	}
+}
+
+func (s *APIServer) findUserImportProfile(r *http.Request) (*models.ImportProfile, *Response) {
+	id := chi.URLParam(r, "id")
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+
+	profile, err := s.DB.ImportProfiles.FindOne(&models.ImportProfile{
+		ID: id,
+	})
+
+	if err != nil {
+		return nil, &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	if profile.UserID != user.ID {
+		return nil, &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "import profile not found",
+			},
+		}
+	}
+
+	return profile, nil
+}
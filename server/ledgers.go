@@ -0,0 +1,314 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+const LedgerHeader = "X-Ledger-ID"
+
+type CreateLedgerRequest struct {
+	Name string `json:"name"`
+}
+
+type CloneLedgerRequest struct {
+	Name string `json:"name"`
+}
+
+func (s *APIServer) registerLedgers() {
+	s.Router.Route("/api/ledgers", func(r chi.Router) {
+		r.Get("/", s.WithUser(MakeHandler(s.getLedgers)))
+		r.Get("/{id}", s.WithUser(MakeHandler(s.getLedger)))
+
+		r.Post("/", s.WithUser(MakeHandler(s.createLedger)))
+		r.Post("/{id}/archive", s.WithUser(MakeHandler(s.archiveLedger)))
+		r.Post("/{id}/unarchive", s.WithUser(MakeHandler(s.unarchiveLedger)))
+		r.Post("/{id}/clone", s.WithUser(MakeHandler(s.cloneLedger)))
+
+		r.Put("/{id}", s.WithUser(MakeHandler(s.renameLedger)))
+	})
+}
+
+// WithLedger resolves the ledger named by the X-Ledger-ID header, falling back
+// to the user's default ledger, and stores it on the request context. It must
+// be wrapped by WithUser.
+func (s *APIServer) WithLedger(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(ContextKey("user")).(*models.User)
+
+		var ledger *models.Ledger
+		var err error
+
+		if id := r.Header.Get(LedgerHeader); id != "" {
+			ledger, err = s.DB.Ledgers.FindOne(&models.Ledger{
+				ID: id,
+			})
+
+			if err != nil || ledger.UserID != user.ID {
+				writeResponse(w, http.StatusNotFound, JSON{
+					"error": "ledger not found",
+				})
+				return
+			}
+		} else {
+			ledger, err = s.DB.Ledgers.Default(user.ID)
+
+			if err != nil {
+				writeResponse(w, http.StatusInternalServerError, JSON{
+					"error": err.Error(),
+				})
+				return
+			}
+		}
+
+		if ledger.Archived && r.Method != http.MethodGet {
+			writeResponse(w, http.StatusBadRequest, JSON{
+				"error": "ledger is archived",
+			})
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ContextKey("ledger"), ledger)
+
+		handlerFunc(w, r.WithContext(ctx))
+	}
+}
+
+func (s *APIServer) getLedgers(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+
+	ledgers, err := s.DB.Ledgers.Find(user.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": ledgers,
+		},
+	}
+}
+
+func (s *APIServer) getLedger(w http.ResponseWriter, r *http.Request) *Response {
+	ledger, resp := s.findUserLedger(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": ledger,
+		},
+	}
+}
+
+func (s *APIServer) createLedger(w http.ResponseWriter, r *http.Request) *Response {
+	clr := &CreateLedgerRequest{}
+
+	err := utils.DecodeBody(r, clr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	if clr.Name == "" {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "name is required",
+			},
+		}
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+
+	l, err := s.DB.Ledgers.Save(&models.Ledger{
+		UserID: user.ID,
+		Name:   clr.Name,
+	})
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": l,
+		},
+	}
+}
+
+func (s *APIServer) renameLedger(w http.ResponseWriter, r *http.Request) *Response {
+	clr := &CreateLedgerRequest{}
+
+	err := utils.DecodeBody(r, clr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	if clr.Name == "" {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "name is required",
+			},
+		}
+	}
+
+	ledger, resp := s.findUserLedger(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	ledger.Name = clr.Name
+
+	return s.saveLedger(ledger)
+}
+
+func (s *APIServer) archiveLedger(w http.ResponseWriter, r *http.Request) *Response {
+	ledger, resp := s.findUserLedger(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	ledger.Archived = true
+
+	return s.saveLedger(ledger)
+}
+
+func (s *APIServer) unarchiveLedger(w http.ResponseWriter, r *http.Request) *Response {
+	ledger, resp := s.findUserLedger(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	ledger.Archived = false
+
+	return s.saveLedger(ledger)
+}
+
+func (s *APIServer) cloneLedger(w http.ResponseWriter, r *http.Request) *Response {
+	clr := &CloneLedgerRequest{}
+
+	err := utils.DecodeBody(r, clr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	ledger, resp := s.findUserLedger(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	if clr.Name == "" {
+		clr.Name = fmt.Sprintf("%s (copy)", ledger.Name)
+	}
+
+	clone, err := s.DB.Ledgers.Clone(ledger, clr.Name)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": clone,
+		},
+	}
+}
+
+func (s *APIServer) findUserLedger(r *http.Request) (*models.Ledger, *Response) {
+	id := chi.URLParam(r, "id")
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+
+	ledger, err := s.DB.Ledgers.FindOne(&models.Ledger{
+		ID: id,
+	})
+
+	if err != nil {
+		return nil, &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	if ledger.UserID != user.ID {
+		return nil, &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "ledger not found",
+			},
+		}
+	}
+
+	return ledger, nil
+}
+
+func (s *APIServer) saveLedger(ledger *models.Ledger) *Response {
+	l, err := s.DB.Ledgers.Save(ledger)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": l,
+		},
+	}
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultLinkWindowDays is how many days apart a transaction and the ones
+// suggested for linking with it can be, unless asked for another window.
+const defaultLinkWindowDays = 60
+
+// CreateLinkRequest links the transaction in the URL with TransactionID. For
+// refund_of the transaction in the URL is the refund and TransactionID the
+// purchase; for reimbursed_by it's the expense and TransactionID the income
+// that paid it back. Amount, in the expense's currency, defaults to as much as
+// both have left to link.
+type CreateLinkRequest struct {
+	Kind          string        `json:"kind"`
+	TransactionID string        `json:"transaction_id"`
+	Amount        *models.Money `json:"amount"`
+}
+
+// getReimbursables lists the ledger's reimbursable expenses still owed, with
+// their total in the user's base currency.
+func (s *APIServer) getReimbursables(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	reimbursables, err := s.DB.Transactions.FindReimbursables(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	converter := s.DB.ExchangeRates.Converter(user.ID, user.BaseCurrency)
+	total := models.NewMoney(0, user.BaseCurrency)
+
+	for _, reimbursable := range reimbursables {
+		outstanding, err := converter.Convert(reimbursable.Outstanding, reimbursable.Date)
+
+		if err == nil {
+			total, err = total.Add(outstanding)
+		}
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data":  reimbursables,
+			"total": total,
+		},
+	}
+}
+
+func (s *APIServer) getLinks(w http.ResponseWriter, r *http.Request) *Response {
+	t, resp := s.findUserTransaction(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	links, err := s.DB.Links.Find(t.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": links,
+		},
+	}
+}
+
+// suggestLinks suggests transactions to link with the one in the URL, matched
+// by amount and vendor within the number of days in the "days" query
+// parameter.
+func (s *APIServer) suggestLinks(w http.ResponseWriter, r *http.Request) *Response {
+	t, resp := s.findUserTransaction(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	days := defaultLinkWindowDays
+
+	if value := r.URL.Query().Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+
+		if err != nil || n < 0 {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "days can't be negative",
+				},
+			}
+		}
+
+		days = n
+	}
+
+	suggestions, err := s.DB.Transactions.SuggestLinks(t, days)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": suggestions,
+		},
+	}
+}
+
+func (s *APIServer) createLink(w http.ResponseWriter, r *http.Request) *Response {
+	t, resp := s.findUserTransaction(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	req := &CreateLinkRequest{}
+
+	if err := utils.DecodeBody(r, req); err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	other, err := s.DB.Transactions.FindOne(&models.Transaction{
+		ID: req.TransactionID,
+	})
+
+	if err != nil || other.UserID != t.UserID || other.LedgerID != t.LedgerID {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "transaction not found in ledger",
+			},
+		}
+	}
+
+	link := &models.TransactionLink{
+		UserID:   t.UserID,
+		LedgerID: t.LedgerID,
+		Kind:     req.Kind,
+	}
+
+	switch req.Kind {
+	case models.LinkRefundOf:
+		link.OriginalID, link.SettlementID = other.ID, t.ID
+	case models.LinkReimbursedBy:
+		link.OriginalID, link.SettlementID = t.ID, other.ID
+	default:
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "kind must be " + models.LinkRefundOf + " or " + models.LinkReimbursedBy,
+			},
+		}
+	}
+
+	currency := t.Currency
+
+	if link.OriginalID == other.ID {
+		currency = other.Currency
+	}
+
+	link.Amount = models.NewMoney(0, currency)
+
+	if req.Amount != nil {
+		if link.Amount, resp = amountIn(*req.Amount, currency); resp != nil {
+			return resp
+		}
+
+		if link.Amount.Sign() <= 0 {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "amount must be positive",
+				},
+			}
+		}
+	}
+
+	link, err = s.DB.Links.Create(link)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusCreated,
+		Content: JSON{
+			"data": link,
+		},
+	}
+}
+
+func (s *APIServer) deleteLink(w http.ResponseWriter, r *http.Request) *Response {
+	t, resp := s.findUserTransaction(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	link, err := s.DB.Links.FindOne(&models.TransactionLink{
+		ID: chi.URLParam(r, "linkId"),
+	})
+
+	if err != nil || (link.OriginalID != t.ID && link.SettlementID != t.ID) {
+		return &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "transaction link not found",
+			},
+		}
+	}
+
+	if err := s.DB.Links.Delete(link.ID); err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"deleted": link.ID,
+		},
+	}
+}
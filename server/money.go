@@ -0,0 +1,24 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/alexgaudon/budgie/models"
+)
+
+// amountIn resolves an amount from a request in the currency it's meant to be
+// in, see models.Money.In.
+func amountIn(amount models.Money, currency string) (models.Money, *Response) {
+	m, err := amount.In(currency)
+
+	if err != nil {
+		return models.Money{}, &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return m, nil
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+)
+
+// mergePatchType is the media type of JSON merge patches (RFC 7396).
+const mergePatchType = "application/merge-patch+json"
+
+// decodePatch reads a JSON merge patch into req, whose fields are
+// models.Patch values. Fields req doesn't know are rejected rather than
+// silently dropped.
+func decodePatch(r *http.Request, req any) *Response {
+	if header := r.Header.Get("Content-Type"); header != "" {
+		mediaType, _, err := mime.ParseMediaType(header)
+
+		if err != nil || (mediaType != mergePatchType && mediaType != "application/json") {
+			return &Response{
+				Status: http.StatusUnsupportedMediaType,
+				Content: JSON{
+					"error": "patches must be " + mergePatchType,
+				},
+			}
+		}
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(req); err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return nil
+}
+
+// fieldErrors collects validation problems by field so a patch can report
+// all of them at once.
+type fieldErrors map[string]string
+
+// notNull records an error when a field that can't be cleared was set to null,
+// and reports whether the field holds a value to apply.
+func (fe fieldErrors) notNull(field string, set, null bool) bool {
+	if set && null {
+		fe[field] = "can't be null"
+	}
+
+	return set && !null
+}
+
+// response is the error response for the collected problems, or nil when
+// there are none.
+func (fe fieldErrors) response() *Response {
+	if len(fe) == 0 {
+		return nil
+	}
+
+	return &Response{
+		Status: http.StatusBadRequest,
+		Content: JSON{
+			"error":  "invalid fields",
+			"fields": fe,
+		},
+	}
+}
@@ -0,0 +1,372 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type CreatePayeeRequest struct {
+	Name              string                `json:"name"`
+	Aliases           []string              `json:"aliases"`
+	Patterns          []string              `json:"patterns"`
+	DefaultCategoryID models.OptionalString `json:"default_category_id"`
+}
+
+type MergePayeesRequest struct {
+	PayeeIDs []string `json:"payee_ids"`
+}
+
+func (s *APIServer) registerPayees() {
+	s.Router.Route("/api/payees", func(r chi.Router) {
+		r.Get("/", s.WithUser(s.WithLedger(MakeHandler(s.getPayees))))
+		r.Get("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.getPayee))))
+
+		r.Post("/", s.WithUser(s.WithLedger(MakeHandler(s.createPayee))))
+		r.Post("/{id}/merge", s.WithUser(s.WithLedger(MakeHandler(s.mergePayees))))
+		r.Post("/{id}/split", s.WithUser(s.WithLedger(MakeHandler(s.splitPayee))))
+
+		r.Put("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.updatePayee))))
+
+		r.Delete("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.deletePayee))))
+	})
+}
+
+func (s *APIServer) getPayees(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	payees, err := s.DB.Payees.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": payees,
+		},
+	}
+}
+
+func (s *APIServer) getPayee(w http.ResponseWriter, r *http.Request) *Response {
+	payee, resp := s.findUserPayee(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": payee,
+		},
+	}
+}
+
+// createPayee adds a payee and links the ledger's existing transactions
+// whose vendor it matches.
+func (s *APIServer) createPayee(w http.ResponseWriter, r *http.Request) *Response {
+	cpr := &CreatePayeeRequest{}
+
+	err := utils.DecodeBody(r, cpr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	payee := &models.Payee{
+		UserID:   user.ID,
+		LedgerID: ledger.ID,
+	}
+
+	if resp := s.applyPayeeRequest(cpr, payee); resp != nil {
+		return resp
+	}
+
+	payee, err = s.DB.Payees.Save(payee)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": payee,
+		},
+	}
+}
+
+func (s *APIServer) updatePayee(w http.ResponseWriter, r *http.Request) *Response {
+	cpr := &CreatePayeeRequest{}
+
+	err := utils.DecodeBody(r, cpr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	payee, resp := s.findUserPayee(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	if resp := s.applyPayeeRequest(cpr, payee); resp != nil {
+		return resp
+	}
+
+	payee, err = s.DB.Payees.Save(payee)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": payee,
+		},
+	}
+}
+
+func (s *APIServer) deletePayee(w http.ResponseWriter, r *http.Request) *Response {
+	payee, resp := s.findUserPayee(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	err := s.DB.Payees.Delete(payee)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"deleted": payee.ID,
+		},
+	}
+}
+
+// mergePayees folds the payees in "payee_ids" into the one in the URL, moving
+// their transactions along.
+func (s *APIServer) mergePayees(w http.ResponseWriter, r *http.Request) *Response {
+	mpr := &MergePayeesRequest{}
+
+	err := utils.DecodeBody(r, mpr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	payee, resp := s.findUserPayee(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	others := []*models.Payee{}
+
+	for _, id := range mpr.PayeeIDs {
+		if id == payee.ID {
+			continue
+		}
+
+		other, resp := s.findUserPayee(r, id)
+
+		if resp != nil {
+			return resp
+		}
+
+		others = append(others, other)
+	}
+
+	if len(others) == 0 {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "no payees to merge",
+			},
+		}
+	}
+
+	payee, err = s.DB.Payees.Merge(payee, others)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": payee,
+		},
+	}
+}
+
+// splitPayee creates a payee from the request, moving the aliases and
+// patterns it names out of the payee in the URL, along with the transactions
+// they match.
+func (s *APIServer) splitPayee(w http.ResponseWriter, r *http.Request) *Response {
+	cpr := &CreatePayeeRequest{}
+
+	err := utils.DecodeBody(r, cpr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	payee, resp := s.findUserPayee(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	split := &models.Payee{
+		UserID:   payee.UserID,
+		LedgerID: payee.LedgerID,
+	}
+
+	if resp := s.applyPayeeRequest(cpr, split); resp != nil {
+		return resp
+	}
+
+	split, err = s.DB.Payees.Split(payee, split)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": JSON{
+				"payee": payee,
+				"split": split,
+			},
+		},
+	}
+}
+
+// linkPayee links the transaction to the payee its vendor matches, if any,
+// and returns that payee.
+func linkPayee(payees []*models.Payee, t *models.Transaction) *models.Payee {
+	payee := models.MatchPayee(payees, t.Vendor)
+
+	if payee == nil {
+		t.PayeeID = models.OptionalString{}
+		return nil
+	}
+
+	t.PayeeID = models.OptionalString{String: payee.ID, Valid: true}
+
+	return payee
+}
+
+func (s *APIServer) applyPayeeRequest(cpr *CreatePayeeRequest, payee *models.Payee) *Response {
+	if cpr.DefaultCategoryID.Valid {
+		category, err := s.DB.Categories.FindOne(&models.Category{
+			ID: cpr.DefaultCategoryID.String,
+		})
+
+		if err != nil || category.LedgerID != payee.LedgerID {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "category not found in ledger",
+				},
+			}
+		}
+
+		payee.DefaultCategory = category.Name
+	}
+
+	payee.Name = cpr.Name
+	payee.Aliases = cpr.Aliases
+	payee.Patterns = cpr.Patterns
+	payee.DefaultCategoryID = cpr.DefaultCategoryID
+
+	return nil
+}
+
+// findUserPayee loads a payee, checking it belongs to the user and the
+// request's ledger.
+func (s *APIServer) findUserPayee(r *http.Request, id string) (*models.Payee, *Response) {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	payee, err := s.DB.Payees.FindOne(&models.Payee{
+		ID: id,
+	})
+
+	if err != nil || payee.UserID != user.ID || payee.LedgerID != ledger.ID {
+		return nil, &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "payee not found",
+			},
+		}
+	}
+
+	return payee, nil
+}
@@ -0,0 +1,437 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// CreateReconciliationRequest starts reconciling an account against a
+// statement. EndingBalance uses the same sign as account balances, so a credit
+// card statement owing $100 has an ending balance of "-100.00".
+type CreateReconciliationRequest struct {
+	AccountID     string       `json:"account_id"`
+	StatementDate time.Time    `json:"statement_date"`
+	EndingBalance models.Money `json:"ending_balance"`
+}
+
+type ReconcileTransactionsRequest struct {
+	TransactionIDs []string `json:"transaction_ids"`
+}
+
+func (s *APIServer) registerReconciliations() {
+	s.Router.Route("/api/reconciliations", func(r chi.Router) {
+		r.Get("/", s.WithUser(s.WithLedger(MakeHandler(s.getReconciliations))))
+		r.Get("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.getReconciliation))))
+		r.Get("/{id}/transactions", s.WithUser(s.WithLedger(MakeHandler(s.getReconciliationTransactions))))
+
+		r.Post("/", s.WithUser(s.WithLedger(MakeHandler(s.createReconciliation))))
+		r.Post("/{id}/clear", s.WithUser(s.WithLedger(MakeHandler(s.clearTransactions))))
+		r.Post("/{id}/unclear", s.WithUser(s.WithLedger(MakeHandler(s.unclearTransactions))))
+		r.Post("/{id}/finalize", s.WithUser(s.WithLedger(MakeHandler(s.finalizeReconciliation))))
+
+		r.Delete("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.deleteReconciliation))))
+	})
+}
+
+// getReconciliations lists the ledger's reconciliations, only those of one
+// account when the "account_id" parameter is given.
+func (s *APIServer) getReconciliations(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	reconciliations, err := s.DB.Reconciliations.Find(user.ID, ledger.ID, r.URL.Query().Get("account_id"))
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": reconciliations,
+		},
+	}
+}
+
+func (s *APIServer) getReconciliation(w http.ResponseWriter, r *http.Request) *Response {
+	rec, resp := s.findUserReconciliation(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": rec,
+		},
+	}
+}
+
+// getReconciliationTransactions lists the account's transactions up to the
+// statement date that haven't been reconciled, pending and cleared alike.
+func (s *APIServer) getReconciliationTransactions(w http.ResponseWriter, r *http.Request) *Response {
+	rec, resp := s.findUserReconciliation(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	transactions, err := s.DB.Transactions.FindUnreconciled(rec.AccountID, rec.StatementDate)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": transactions,
+		},
+	}
+}
+
+func (s *APIServer) createReconciliation(w http.ResponseWriter, r *http.Request) *Response {
+	req := &CreateReconciliationRequest{}
+
+	err := utils.DecodeBody(r, req)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	account, err := s.DB.Accounts.FindOne(&models.Account{
+		ID: req.AccountID,
+	})
+
+	if err != nil || account.UserID != user.ID || account.LedgerID != ledger.ID {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "account not found in ledger",
+			},
+		}
+	}
+
+	if req.StatementDate.IsZero() {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "statement date is required",
+			},
+		}
+	}
+
+	statementDate := time.Date(req.StatementDate.Year(), req.StatementDate.Month(), req.StatementDate.Day(), 0, 0, 0, 0, time.UTC)
+
+	existing, err := s.DB.Reconciliations.Find(user.ID, ledger.ID, account.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	for _, rec := range existing {
+		if rec.Status == models.ReconciliationOpen {
+			return &Response{
+				Status: http.StatusConflict,
+				Content: JSON{
+					"error":             "the account already has an open reconciliation",
+					"reconciliation_id": rec.ID,
+				},
+			}
+		}
+
+		if !statementDate.After(rec.StatementDate) {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "statement date must be after " + rec.StatementDate.Format("2006-01-02") + ", the last reconciled statement",
+				},
+			}
+		}
+	}
+
+	endingBalance, resp := amountIn(req.EndingBalance, account.Currency)
+
+	if resp != nil {
+		return resp
+	}
+
+	rec, err := s.DB.Reconciliations.Create(&models.Reconciliation{
+		UserID:        user.ID,
+		LedgerID:      ledger.ID,
+		AccountID:     account.ID,
+		StatementDate: statementDate,
+		EndingBalance: endingBalance,
+	})
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusCreated,
+		Content: JSON{
+			"data": rec,
+		},
+	}
+}
+
+func (s *APIServer) clearTransactions(w http.ResponseWriter, r *http.Request) *Response {
+	return s.setReconciliationStatus(r, models.StatusCleared)
+}
+
+func (s *APIServer) unclearTransactions(w http.ResponseWriter, r *http.Request) *Response {
+	return s.setReconciliationStatus(r, models.StatusPending)
+}
+
+// setReconciliationStatus marks transactions of an open reconciliation's
+// account and returns the reconciliation with its new difference.
+func (s *APIServer) setReconciliationStatus(r *http.Request, status string) *Response {
+	req := &ReconcileTransactionsRequest{}
+
+	err := utils.DecodeBody(r, req)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	rec, resp := s.findOpenReconciliation(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	if len(req.TransactionIDs) == 0 {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "no transactions given",
+			},
+		}
+	}
+
+	changed, err := s.DB.Transactions.SetStatus(rec.AccountID, req.TransactionIDs, status)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	rec, err = s.DB.Reconciliations.FindOne(rec)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data":    rec,
+			"changed": changed,
+		},
+	}
+}
+
+// finalizeReconciliation locks the cleared transactions once the cleared
+// balance matches the statement.
+func (s *APIServer) finalizeReconciliation(w http.ResponseWriter, r *http.Request) *Response {
+	rec, resp := s.findOpenReconciliation(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	if !rec.Difference.IsZero() {
+		return &Response{
+			Status: http.StatusConflict,
+			Content: JSON{
+				"error":      "the cleared balance doesn't match the statement",
+				"difference": rec.Difference,
+			},
+		}
+	}
+
+	reconciled, err := s.DB.Reconciliations.Finalize(rec)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data":       rec,
+			"reconciled": reconciled,
+		},
+	}
+}
+
+// deleteReconciliation abandons an open reconciliation. Finalized ones stay
+// as the record of what was reconciled.
+func (s *APIServer) deleteReconciliation(w http.ResponseWriter, r *http.Request) *Response {
+	rec, resp := s.findOpenReconciliation(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	if err := s.DB.Reconciliations.Delete(rec.ID); err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"deleted": rec.ID,
+		},
+	}
+}
+
+// unlockTransaction takes a reconciled transaction back to cleared so it can
+// be edited again.
+func (s *APIServer) unlockTransaction(w http.ResponseWriter, r *http.Request) *Response {
+	t, resp := s.findUserTransaction(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	if !t.Locked() {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "transaction isn't reconciled",
+			},
+		}
+	}
+
+	t, err := s.DB.Transactions.Unlock(t)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": t,
+		},
+	}
+}
+
+// checkUnlocked refuses changes to reconciled transactions.
+func checkUnlocked(transactions ...*models.Transaction) *Response {
+	for _, t := range transactions {
+		if t.Locked() {
+			return &Response{
+				Status: http.StatusConflict,
+				Content: JSON{
+					"error": "transaction is reconciled, unlock it first",
+				},
+			}
+		}
+	}
+
+	return nil
+}
+
+// findUserReconciliation loads the reconciliation in the URL, checking it
+// belongs to the user and the request's ledger.
+func (s *APIServer) findUserReconciliation(r *http.Request) (*models.Reconciliation, *Response) {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	rec, err := s.DB.Reconciliations.FindOne(&models.Reconciliation{
+		ID: chi.URLParam(r, "id"),
+	})
+
+	if err != nil || rec.UserID != user.ID || rec.LedgerID != ledger.ID {
+		return nil, &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "reconciliation not found",
+			},
+		}
+	}
+
+	return rec, nil
+}
+
+// findOpenReconciliation is findUserReconciliation for changes, which only
+// open reconciliations accept.
+func (s *APIServer) findOpenReconciliation(r *http.Request) (*models.Reconciliation, *Response) {
+	rec, resp := s.findUserReconciliation(r)
+
+	if resp == nil && rec.Status != models.ReconciliationOpen {
+		resp = &Response{
+			Status: http.StatusConflict,
+			Content: JSON{
+				"error": "reconciliation is already finalized",
+			},
+		}
+	}
+
+	return rec, resp
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type CreateRecurringRequest struct {
+	AccountID   models.OptionalString `json:"account_id"`
+	CategoryID  string                `json:"category_id"`
+	Amount      models.Money          `json:"amount"`
+	Description models.OptionalString `json:"description"`
+	Vendor      string                `json:"vendor"`
+	Type        string                `json:"type"`
+	Rule        string                `json:"rule"`
+	StartDate   time.Time             `json:"start_date"`
+	Active      *bool                 `json:"active"`
+}
+
+func (s *APIServer) registerRecurring() {
+	s.Router.Route("/api/recurring", func(r chi.Router) {
+		r.Get("/", s.WithUser(s.WithLedger(MakeHandler(s.getRecurring))))
+		r.Get("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.getOneRecurring))))
+		r.Get("/{id}/preview", s.WithUser(s.WithLedger(MakeHandler(s.previewRecurring))))
+
+		r.Post("/", s.WithUser(s.WithLedger(MakeHandler(s.createRecurring))))
+
+		r.Put("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.updateRecurring))))
+
+		r.Delete("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.deleteRecurring))))
+	})
+}
+
+func (s *APIServer) getRecurring(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	recurring, err := s.DB.Recurring.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": recurring,
+		},
+	}
+}
+
+func (s *APIServer) getOneRecurring(w http.ResponseWriter, r *http.Request) *Response {
+	rec, resp := s.findUserRecurring(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": rec,
+		},
+	}
+}
+
+// previewRecurring lists the next n (default 5) occurrences of the schedule
+// from today on.
+func (s *APIServer) previewRecurring(w http.ResponseWriter, r *http.Request) *Response {
+	rec, resp := s.findUserRecurring(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	n := 5
+
+	if nString := r.URL.Query().Get("n"); nString != "" {
+		parsed, err := strconv.Atoi(nString)
+
+		if err != nil || parsed < 1 || parsed > 100 {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "n must be a number between 1 and 100",
+				},
+			}
+		}
+
+		n = parsed
+	}
+
+	rule, err := models.ParseRule(rec.Rule)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	from := time.Now().UTC()
+	if rec.StartDate.After(from) {
+		from = rec.StartDate
+	}
+
+	occurrences := rule.Occurrences(rec.StartDate, from, from.AddDate(100, 0, 0), n)
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": occurrences,
+		},
+	}
+}
+
+func (s *APIServer) createRecurring(w http.ResponseWriter, r *http.Request) *Response {
+	crr := &CreateRecurringRequest{}
+
+	err := utils.DecodeBody(r, crr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	rec := &models.Recurring{
+		UserID:   user.ID,
+		LedgerID: ledger.ID,
+		Currency: user.BaseCurrency,
+		Active:   true,
+	}
+
+	if resp := s.applyRecurringRequest(crr, rec); resp != nil {
+		return resp
+	}
+
+	rec, err = s.DB.Recurring.Save(rec)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": rec,
+		},
+	}
+}
+
+func (s *APIServer) updateRecurring(w http.ResponseWriter, r *http.Request) *Response {
+	crr := &CreateRecurringRequest{}
+
+	err := utils.DecodeBody(r, crr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	rec, resp := s.findUserRecurring(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	if resp := s.applyRecurringRequest(crr, rec); resp != nil {
+		return resp
+	}
+
+	rec, err = s.DB.Recurring.Save(rec)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": rec,
+		},
+	}
+}
+
+func (s *APIServer) deleteRecurring(w http.ResponseWriter, r *http.Request) *Response {
+	rec, resp := s.findUserRecurring(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	err := s.DB.Recurring.Delete(rec.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"deleted": rec.ID,
+		},
+	}
+}
+
+// applyRecurringRequest copies the request onto the recurring transaction.
+// Its amount is in the currency of its account, or stays in the currency it
+// has without one.
+func (s *APIServer) applyRecurringRequest(crr *CreateRecurringRequest, rec *models.Recurring) *Response {
+	if !entryType(crr.Type) {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": entryTypeError,
+			},
+		}
+	}
+
+	if _, err := models.ParseRule(crr.Rule); err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	category, err := s.DB.Categories.FindOne(&models.Category{
+		ID: crr.CategoryID,
+	})
+
+	if err != nil || category.LedgerID != rec.LedgerID {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "category not found in ledger",
+			},
+		}
+	}
+
+	if resp := s.checkTransactionAccount(rec.LedgerID, crr.AccountID); resp != nil {
+		return resp
+	}
+
+	currency, resp := s.accountCurrency(crr.AccountID, rec.Currency)
+
+	if resp != nil {
+		return resp
+	}
+
+	amount, resp := amountIn(crr.Amount, currency)
+
+	if resp != nil {
+		return resp
+	}
+
+	rec.AccountID = crr.AccountID
+	rec.Category = category.Name
+	rec.CategoryID = category.ID
+	rec.Amount = amount
+	rec.Description = crr.Description
+	rec.Vendor = crr.Vendor
+	rec.Type = crr.Type
+	rec.Rule = crr.Rule
+	rec.StartDate = crr.StartDate
+
+	if crr.Active != nil {
+		rec.Active = *crr.Active
+	}
+
+	return nil
+}
+
+func (s *APIServer) findUserRecurring(r *http.Request) (*models.Recurring, *Response) {
+	id := chi.URLParam(r, "id")
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+
+	rec, err := s.DB.Recurring.FindOne(&models.Recurring{
+		ID: id,
+	})
+
+	if err != nil {
+		return nil, &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	if rec.UserID != user.ID {
+		return nil, &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "recurring transaction not found",
+			},
+		}
+	}
+
+	return rec, nil
+}
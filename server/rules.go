@@ -0,0 +1,513 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type CreateRuleRequest struct {
+	Name               string                `json:"name"`
+	Priority           int                   `json:"priority"`
+	Active             *bool                 `json:"active"`
+	VendorMatch        string                `json:"vendor_match"`
+	VendorPattern      string                `json:"vendor_pattern"`
+	DescriptionMatch   string                `json:"description_match"`
+	DescriptionPattern string                `json:"description_pattern"`
+	AmountMin          *models.Money         `json:"amount_min"`
+	AmountMax          *models.Money         `json:"amount_max"`
+	AccountID          models.OptionalString `json:"account_id"`
+	Type               models.OptionalString `json:"type"`
+	SetCategoryID      models.OptionalString `json:"set_category_id"`
+	RenameVendor       models.OptionalString `json:"rename_vendor"`
+	AddTags            []string              `json:"add_tags"`
+}
+
+// RunRulesRequest selects the rules to run (all active ones when RuleIDs is
+// empty) and, optionally, only the transactions currently in CategoryID.
+// Nothing is written unless Apply is set.
+type RunRulesRequest struct {
+	Apply      bool     `json:"apply"`
+	RuleIDs    []string `json:"rule_ids"`
+	CategoryID string   `json:"category_id"`
+}
+
+// RuleChange is what running the rules does, or would do, to a transaction.
+type RuleChange struct {
+	TransactionID string                `json:"transaction_id"`
+	RuleIDs       []string              `json:"rule_ids"`
+	Vendor        string                `json:"vendor"`
+	NewVendor     models.OptionalString `json:"new_vendor"`
+	CategoryID    string                `json:"category_id"`
+	NewCategoryID models.OptionalString `json:"new_category_id"`
+	Tags          []string              `json:"tags"`
+}
+
+func (s *APIServer) registerRules() {
+	s.Router.Route("/api/rules", func(r chi.Router) {
+		r.Get("/", s.WithUser(s.WithLedger(MakeHandler(s.getRules))))
+		r.Get("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.getRule))))
+
+		r.Post("/", s.WithUser(s.WithLedger(MakeHandler(s.createRule))))
+		r.Post("/run", s.WithUser(s.WithLedger(MakeHandler(s.runRules))))
+
+		r.Put("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.updateRule))))
+
+		r.Delete("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.deleteRule))))
+	})
+}
+
+func (s *APIServer) getRules(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	rules, err := s.DB.Rules.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": rules,
+		},
+	}
+}
+
+func (s *APIServer) getRule(w http.ResponseWriter, r *http.Request) *Response {
+	rule, resp := s.findUserRule(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": rule,
+		},
+	}
+}
+
+func (s *APIServer) createRule(w http.ResponseWriter, r *http.Request) *Response {
+	crr := &CreateRuleRequest{}
+
+	err := utils.DecodeBody(r, crr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	rule := &models.TransactionRule{
+		UserID:   user.ID,
+		LedgerID: ledger.ID,
+		Currency: user.BaseCurrency,
+		Active:   true,
+	}
+
+	if resp := s.applyRuleRequest(crr, rule); resp != nil {
+		return resp
+	}
+
+	rule, err = s.DB.Rules.Save(rule)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": rule,
+		},
+	}
+}
+
+func (s *APIServer) updateRule(w http.ResponseWriter, r *http.Request) *Response {
+	crr := &CreateRuleRequest{}
+
+	err := utils.DecodeBody(r, crr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	rule, resp := s.findUserRule(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	if resp := s.applyRuleRequest(crr, rule); resp != nil {
+		return resp
+	}
+
+	rule, err = s.DB.Rules.Save(rule)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": rule,
+		},
+	}
+}
+
+func (s *APIServer) deleteRule(w http.ResponseWriter, r *http.Request) *Response {
+	rule, resp := s.findUserRule(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	err := s.DB.Rules.Delete(rule.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"deleted": rule.ID,
+		},
+	}
+}
+
+// runRules runs the ledger's rules against its existing transactions and
+// reports the changes. With "apply" the changes are saved in a single
+// database transaction; otherwise this is a dry run.
+func (s *APIServer) runRules(w http.ResponseWriter, r *http.Request) *Response {
+	req := &RunRulesRequest{}
+
+	err := utils.DecodeBody(r, req)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	rules, err := s.DB.Rules.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	// Selected rules still run in priority order.
+	if len(req.RuleIDs) > 0 {
+		byId := map[string]*models.TransactionRule{}
+
+		for _, rule := range rules {
+			byId[rule.ID] = rule
+		}
+
+		for _, id := range req.RuleIDs {
+			if byId[id] == nil {
+				return &Response{
+					Status: http.StatusNotFound,
+					Content: JSON{
+						"error": "rule not found",
+					},
+				}
+			}
+		}
+
+		selected := []*models.TransactionRule{}
+
+		for _, rule := range rules {
+			for _, id := range req.RuleIDs {
+				if rule.ID == id {
+					selected = append(selected, rule)
+					break
+				}
+			}
+		}
+
+		rules = selected
+	}
+
+	transactions, err := s.DB.Transactions.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	changes := []*RuleChange{}
+	changed := []*models.Transaction{}
+
+	for _, t := range transactions {
+		if t.TransferID.Valid || t.Locked() || (req.CategoryID != "" && t.CategoryID != req.CategoryID) {
+			continue
+		}
+
+		result := models.ApplyRules(rules, t)
+
+		if !result.Matched() {
+			continue
+		}
+
+		change := &RuleChange{
+			TransactionID: t.ID,
+			RuleIDs:       result.RuleIDs,
+			Vendor:        t.Vendor,
+			CategoryID:    t.CategoryID,
+			Tags:          newTags(t.Tags, result.Tags),
+		}
+
+		if result.Vendor.Valid && result.Vendor.String != t.Vendor {
+			change.NewVendor = result.Vendor
+			t.Vendor = result.Vendor.String
+		}
+
+		// The parent category of a split transaction isn't used for anything.
+		if result.CategoryID.Valid && result.CategoryID.String != t.CategoryID && len(t.Splits) == 0 {
+			change.NewCategoryID = result.CategoryID
+			t.CategoryID = result.CategoryID.String
+		}
+
+		if !change.NewVendor.Valid && !change.NewCategoryID.Valid && len(change.Tags) == 0 {
+			continue
+		}
+
+		t.Tags = append(t.Tags, change.Tags...)
+
+		changes = append(changes, change)
+		changed = append(changed, t)
+	}
+
+	if req.Apply {
+		payees, err := s.DB.Payees.Find(user.ID, ledger.ID)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+
+		// Renamed vendors may now belong to a different payee.
+		for _, t := range changed {
+			linkPayee(payees, t)
+		}
+
+		err = s.DB.Transactions.UpdateAll(changed)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data":    changes,
+			"applied": req.Apply,
+		},
+	}
+}
+
+// applyRules runs rules against a transaction about to be created, renaming
+// its vendor, adding their tags and, when setCategory is true, setting its
+// category.
+func applyRules(rules []*models.TransactionRule, t *models.Transaction, setCategory bool) *models.RuleResult {
+	result := models.ApplyRules(rules, t)
+
+	if result.Vendor.Valid {
+		t.Vendor = result.Vendor.String
+	}
+
+	t.Tags = models.NormalizeTags(append(t.Tags, result.Tags...))
+
+	if setCategory && result.CategoryID.Valid {
+		t.CategoryID = result.CategoryID.String
+	}
+
+	return result
+}
+
+// newTags returns the tags in add that aren't in tags, ignoring case.
+func newTags(tags, add []string) []string {
+	have := map[string]bool{}
+
+	for _, tag := range tags {
+		have[strings.ToLower(tag)] = true
+	}
+
+	added := []string{}
+
+	for _, tag := range models.NormalizeTags(add) {
+		if !have[strings.ToLower(tag)] {
+			added = append(added, tag)
+		}
+	}
+
+	return added
+}
+
+func (s *APIServer) applyRuleRequest(crr *CreateRuleRequest, rule *models.TransactionRule) *Response {
+	if crr.Type.Valid && !entryType(crr.Type.String) {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": entryTypeError,
+			},
+		}
+	}
+
+	if crr.SetCategoryID.Valid {
+		category, err := s.DB.Categories.FindOne(&models.Category{
+			ID: crr.SetCategoryID.String,
+		})
+
+		if err != nil || category.LedgerID != rule.LedgerID {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "category not found in ledger",
+				},
+			}
+		}
+	}
+
+	if resp := s.checkTransactionAccount(rule.LedgerID, crr.AccountID); resp != nil {
+		return resp
+	}
+
+	currency, resp := s.accountCurrency(crr.AccountID, rule.Currency)
+
+	if resp != nil {
+		return resp
+	}
+
+	for _, amount := range []*models.Money{crr.AmountMin, crr.AmountMax} {
+		if amount == nil {
+			continue
+		}
+
+		m, resp := amountIn(*amount, currency)
+
+		if resp != nil {
+			return resp
+		}
+
+		*amount = m
+	}
+
+	tags := models.NormalizeTags(crr.AddTags)
+
+	if err := models.ValidateTags(tags); err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	rule.Name = crr.Name
+	rule.Priority = crr.Priority
+	rule.VendorMatch = crr.VendorMatch
+	rule.VendorPattern = crr.VendorPattern
+	rule.DescriptionMatch = crr.DescriptionMatch
+	rule.DescriptionPattern = crr.DescriptionPattern
+	rule.AmountMin = crr.AmountMin
+	rule.AmountMax = crr.AmountMax
+	rule.Currency = currency
+	rule.AccountID = crr.AccountID
+	rule.Type = crr.Type
+	rule.SetCategoryID = crr.SetCategoryID
+	rule.RenameVendor = crr.RenameVendor
+	rule.AddTags = tags
+
+	if crr.Active != nil {
+		rule.Active = *crr.Active
+	}
+
+	return nil
+}
+
+// findUserRule loads a rule, checking it belongs to the user and the
+// request's ledger.
+func (s *APIServer) findUserRule(r *http.Request, id string) (*models.TransactionRule, *Response) {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	rule, err := s.DB.Rules.FindOne(&models.TransactionRule{
+		ID: id,
+	})
+
+	if err != nil || rule.UserID != user.ID || rule.LedgerID != ledger.ID {
+		return nil, &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "rule not found",
+			},
+		}
+	}
+
+	return rule, nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/alexgaudon/budgie/models"
+)
+
+// defaultSearchLimit and maxSearchLimit bound how many transactions a search
+// returns.
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+)
+
+// searchTransactions finds the ledger's transactions whose vendor,
+// description or category contain every word of the "q" query parameter,
+// each word also matching as a prefix. Results are ranked, with the matching
+// words marked in highlighted copies of the fields.
+func (s *APIServer) searchTransactions(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	query := r.URL.Query()
+
+	if models.SearchQuery(query.Get("q")) == "" {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "q must contain at least one word",
+			},
+		}
+	}
+
+	limit := defaultSearchLimit
+
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+
+		if err != nil || n < 1 || n > maxSearchLimit {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "limit must be between 1 and " + strconv.Itoa(maxSearchLimit),
+				},
+			}
+		}
+
+		limit = n
+	}
+
+	results, err := s.DB.Transactions.Search(user.ID, ledger.ID, query.Get("q"), limit)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": results,
+		},
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/alexgaudon/budgie/blobstore"
 	"github.com/alexgaudon/budgie/storage"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/chi/v5"
@@ -18,12 +19,14 @@ import (
 type APIServer struct {
 	Router *chi.Mux
 	DB     *storage.DBStore
+	Blobs  blobstore.Store
 }
 
-func NewAPIServer(db *storage.DBStore) *APIServer {
+func NewAPIServer(db *storage.DBStore, blobs blobstore.Store) *APIServer {
 	return &APIServer{
 		Router: chi.NewRouter(),
 		DB:     db,
+		Blobs:  blobs,
 	}
 }
 
@@ -75,8 +78,8 @@ func (a *APIServer) ConfigureServer() {
 		// AllowedOrigins:   []string{"https://foo.com"}, // Use this to allow specific origin hosts
 		AllowedOrigins: []string{"https://*", "http://*"},
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", LedgerHeader},
 		ExposedHeaders:   []string{"Content-Type", "Set-Cookie", "Cookie"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
@@ -85,9 +88,20 @@ func (a *APIServer) ConfigureServer() {
 	log.Println("Registering routes...")
 
 	a.registerAuth()
+	a.registerLedgers()
+	a.registerAccounts()
 	a.registerCategories()
 	a.registerBudgets()
 	a.registerTransactions()
+	a.registerTransfers()
+	a.registerRecurring()
+	a.registerImports()
+	a.registerRules()
+	a.registerPayees()
+	a.registerTags()
+	a.registerReconciliations()
+	a.registerExchangeRates()
+	a.registerExports()
 
 	workDir, _ := os.Getwd()
 	filesDir := filepath.Join(workDir, "/client/dist")
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/alexgaudon/budgie/classifier"
+	"github.com/alexgaudon/budgie/models"
+)
+
+// defaultSuggestionLimit is how many categories suggest-category returns
+// unless asked for more.
+const defaultSuggestionLimit = 5
+
+// suggestCategory ranks the ledger's categories for a transaction described
+// by the "vendor", "description" and "amount" query parameters, learning from
+// how the user categorized past transactions.
+func (s *APIServer) suggestCategory(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	query := r.URL.Query()
+
+	if query.Get("vendor") == "" && query.Get("description") == "" {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "vendor or description is required",
+			},
+		}
+	}
+
+	amount := 0
+	limit := defaultSuggestionLimit
+
+	if value := query.Get("amount"); value != "" {
+		n, err := strconv.Atoi(value)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "amount must be in cents",
+				},
+			}
+		}
+
+		amount = n
+	}
+
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+
+		if err != nil || n < 1 {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "limit must be a positive number",
+				},
+			}
+		}
+
+		limit = n
+	}
+
+	model, names, err := s.trainClassifier(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	suggestions := model.Suggest(query.Get("vendor"), query.Get("description"), amount)
+
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	for _, suggestion := range suggestions {
+		suggestion.Category = names[suggestion.CategoryID]
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": suggestions,
+		},
+	}
+}
+
+// trainClassifier learns the user's categorization habits from the ledger's
+// transactions, split lines included. Transfers carry no category and are
+// left out. It also returns the category names by id.
+func (s *APIServer) trainClassifier(userId, ledgerId string) (*classifier.Model, map[string]string, error) {
+	transactions, err := s.DB.Transactions.Find(userId, ledgerId)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	examples := []classifier.Example{}
+	names := map[string]string{}
+
+	for _, t := range transactions {
+		if t.TransferID.Valid {
+			continue
+		}
+
+		for _, line := range t.Lines() {
+			names[line.CategoryID] = line.Category
+
+			examples = append(examples, classifier.Example{
+				CategoryID:  line.CategoryID,
+				Vendor:      t.Vendor,
+				Description: line.Memo.String,
+				Amount:      int(line.Amount.Cents()),
+			})
+		}
+	}
+
+	return classifier.Train(examples), names, nil
+}
@@ -0,0 +1,369 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+type CreateTagRequest struct {
+	Name string `json:"name"`
+}
+
+// AssignTagsRequest adds and removes tags, by name, on several transactions
+// at once. Added tags that don't exist yet are created.
+type AssignTagsRequest struct {
+	TransactionIDs []string `json:"transaction_ids"`
+	Add            []string `json:"add"`
+	Remove         []string `json:"remove"`
+}
+
+func (s *APIServer) registerTags() {
+	s.Router.Route("/api/tags", func(r chi.Router) {
+		r.Get("/", s.WithUser(s.WithLedger(MakeHandler(s.getTags))))
+		r.Get("/spending", s.WithUser(s.WithLedger(MakeHandler(s.getTagSpending))))
+		r.Get("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.getTag))))
+
+		r.Post("/", s.WithUser(s.WithLedger(MakeHandler(s.createTag))))
+		r.Post("/assign", s.WithUser(s.WithLedger(MakeHandler(s.assignTags))))
+
+		r.Put("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.updateTag))))
+
+		r.Delete("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.deleteTag))))
+	})
+}
+
+func (s *APIServer) getTags(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	tags, err := s.DB.Tags.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": tags,
+		},
+	}
+}
+
+func (s *APIServer) getTag(w http.ResponseWriter, r *http.Request) *Response {
+	tag, resp := s.findUserTag(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": tag,
+		},
+	}
+}
+
+func (s *APIServer) createTag(w http.ResponseWriter, r *http.Request) *Response {
+	ctr := &CreateTagRequest{}
+
+	err := utils.DecodeBody(r, ctr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	tag, err := s.DB.Tags.Save(&models.Tag{
+		UserID:   user.ID,
+		LedgerID: ledger.ID,
+		Name:     ctr.Name,
+	})
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": tag,
+		},
+	}
+}
+
+// updateTag renames a tag. Transactions refer to tags by id, so they follow
+// the new name.
+func (s *APIServer) updateTag(w http.ResponseWriter, r *http.Request) *Response {
+	ctr := &CreateTagRequest{}
+
+	err := utils.DecodeBody(r, ctr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	tag, resp := s.findUserTag(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	tag.Name = ctr.Name
+
+	tag, err = s.DB.Tags.Save(tag)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": tag,
+		},
+	}
+}
+
+func (s *APIServer) deleteTag(w http.ResponseWriter, r *http.Request) *Response {
+	tag, resp := s.findUserTag(r, chi.URLParam(r, "id"))
+
+	if resp != nil {
+		return resp
+	}
+
+	err := s.DB.Tags.Delete(tag.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"deleted": tag.ID,
+		},
+	}
+}
+
+// assignTags adds and removes tags on several of the ledger's transactions in
+// a single database transaction.
+func (s *APIServer) assignTags(w http.ResponseWriter, r *http.Request) *Response {
+	atr := &AssignTagsRequest{}
+
+	err := utils.DecodeBody(r, atr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	if resp := s.checkLedgerTransactions(user.ID, ledger.ID, atr.TransactionIDs); resp != nil {
+		return resp
+	}
+
+	if err := models.ValidateTags(models.NormalizeTags(atr.Add)); err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	err = s.DB.Tags.Assign(user.ID, ledger.ID, atr.TransactionIDs, atr.Add, atr.Remove)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": JSON{
+				"transactions": len(atr.TransactionIDs),
+			},
+		},
+	}
+}
+
+// getTagSpending totals the ledger's transactions by tag. The optional from
+// and to query parameters (YYYY-MM-DD, inclusive) limit the date range.
+func (s *APIServer) getTagSpending(w http.ResponseWriter, r *http.Request) *Response {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	var from, to sql.NullTime
+
+	for _, param := range []struct {
+		name  string
+		value *sql.NullTime
+	}{{"from", &from}, {"to", &to}} {
+		dateString := r.URL.Query().Get(param.name)
+
+		if dateString == "" {
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02", dateString)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+
+		*param.value = sql.NullTime{Time: date, Valid: true}
+	}
+
+	spending, err := s.DB.Tags.Spending(user.ID, ledger.ID, user.BaseCurrency, from, to)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": spending,
+		},
+	}
+}
+
+// checkLedgerTransactions makes sure every transaction exists, belongs to the
+// user and the ledger, and is neither a transfer leg nor reconciled.
+func (s *APIServer) checkLedgerTransactions(userId, ledgerId string, ids []string) *Response {
+	if len(ids) == 0 {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "no transactions given",
+			},
+		}
+	}
+
+	transactions, err := s.DB.Transactions.Find(userId, ledgerId)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	byId := map[string]*models.Transaction{}
+
+	for _, t := range transactions {
+		byId[t.ID] = t
+	}
+
+	for _, id := range ids {
+		t, ok := byId[id]
+
+		if !ok {
+			return &Response{
+				Status: http.StatusNotFound,
+				Content: JSON{
+					"error": "transaction not found",
+				},
+			}
+		}
+
+		if t.TransferID.Valid {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "transfers must be updated through /api/transfers",
+				},
+			}
+		}
+
+		if resp := checkUnlocked(t); resp != nil {
+			return resp
+		}
+	}
+
+	return nil
+}
+
+// findUserTag loads a tag, checking it belongs to the user and the request's
+// ledger.
+func (s *APIServer) findUserTag(r *http.Request, id string) (*models.Tag, *Response) {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	tag, err := s.DB.Tags.FindOne(&models.Tag{
+		ID: id,
+	})
+
+	if err != nil || tag.UserID != user.ID || tag.LedgerID != ledger.ID {
+		return nil, &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "tag not found",
+			},
+		}
+	}
+
+	return tag, nil
+}
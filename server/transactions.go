@@ -2,39 +2,111 @@ package server
 
 import (
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/alexgaudon/budgie/filter"
 	"github.com/alexgaudon/budgie/models"
 	"github.com/alexgaudon/budgie/utils"
 	"github.com/go-chi/chi/v5"
 )
 
 type CreateTransactionRequest struct {
-	Amount      int                   `json:"amount"`
+	AccountID   models.OptionalString `json:"account_id"`
+	Amount      models.Money          `json:"amount"`
 	CategoryID  string                `json:"category_id"`
 	Description models.OptionalString `json:"description"`
 	Vendor      string                `json:"vendor"`
 	Date        time.Time             `json:"date"`
 	Type        string                `json:"type"`
-}
+	Currency    string                `json:"currency"`
+	Splits      []*CreateSplitRequest `json:"splits"`
+	Tags        []string              `json:"tags"`
 
-func (s *APIServer) registerTransactions() {
-	s.Router.Route("/api/transactions", func(r chi.Router) {
-		r.Get("/", s.WithUser(MakeHandler(s.getTransactions)))
-		r.Get("/{id}", s.WithUser(MakeHandler(s.getTransction)))
+	Reimbursable bool `json:"reimbursable"`
+}
 
-		r.Post("/", s.WithUser(MakeHandler(s.createTransaction)))
+// PatchTransactionRequest is a JSON merge patch of a transaction. Only the
+// fields it includes are changed; a null account, description, splits or
+// tags clears them.
+type PatchTransactionRequest struct {
+	AccountID   models.Patch[models.OptionalString] `json:"account_id"`
+	Amount      models.Patch[models.Money]          `json:"amount"`
+	CategoryID  models.Patch[string]                `json:"category_id"`
+	Description models.Patch[models.OptionalString] `json:"description"`
+	Vendor      models.Patch[string]                `json:"vendor"`
+	Date        models.Patch[time.Time]             `json:"date"`
+	Type        models.Patch[string]                `json:"type"`
+	Currency    models.Patch[string]                `json:"currency"`
+	Splits      models.Patch[[]*CreateSplitRequest] `json:"splits"`
+	Tags        models.Patch[[]string]              `json:"tags"`
+
+	Reimbursable models.Patch[bool] `json:"reimbursable"`
+}
 
-		r.Put("/{id}", s.WithUser(MakeHandler(s.updateTransaction)))
+type CreateSplitRequest struct {
+	CategoryID string                `json:"category_id"`
+	Amount     models.Money          `json:"amount"`
+	Memo       models.OptionalString `json:"memo"`
+}
 
-		r.Delete("/{id}", s.WithUser(MakeHandler(s.deleteTransaction)))
+func (s *APIServer) registerTransactions() {
+	s.Router.Route("/api/transactions", func(r chi.Router) {
+		r.Get("/", s.WithUser(s.WithLedger(MakeHandler(s.getTransactions))))
+		r.Get("/suggest-category", s.WithUser(s.WithLedger(MakeHandler(s.suggestCategory))))
+		r.Get("/search", s.WithUser(s.WithLedger(MakeHandler(s.searchTransactions))))
+		r.Get("/reimbursables", s.WithUser(s.WithLedger(MakeHandler(s.getReimbursables))))
+		r.Get("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.getTransction))))
+
+		r.Post("/", s.WithUser(s.WithLedger(MakeHandler(s.createTransaction))))
+		r.Post("/bulk", s.WithUser(s.WithLedger(MakeHandler(s.bulkTransactions))))
+		r.Post("/{id}/unlock", s.WithUser(s.WithLedger(MakeHandler(s.unlockTransaction))))
+
+		r.Put("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.updateTransaction))))
+		r.Patch("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.patchTransaction))))
+
+		r.Delete("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.deleteTransaction))))
+
+		r.Get("/{id}/attachments", s.WithUser(s.WithLedger(MakeHandler(s.getAttachments))))
+		r.Post("/{id}/attachments", s.WithUser(s.WithLedger(MakeHandler(s.uploadAttachment))))
+		r.Delete("/{id}/attachments/{attachmentId}", s.WithUser(s.WithLedger(MakeHandler(s.deleteAttachment))))
+
+		r.Get("/{id}/links", s.WithUser(s.WithLedger(MakeHandler(s.getLinks))))
+		r.Get("/{id}/links/suggestions", s.WithUser(s.WithLedger(MakeHandler(s.suggestLinks))))
+		r.Post("/{id}/links", s.WithUser(s.WithLedger(MakeHandler(s.createLink))))
+		r.Delete("/{id}/links/{linkId}", s.WithUser(s.WithLedger(MakeHandler(s.deleteLink))))
+
+		// Downloads skip the ledger header so they work as plain links and
+		// image sources; ownership is checked through the transaction.
+		r.Get("/{id}/attachments/{attachmentId}", s.WithUser(s.downloadAttachment))
+		r.Get("/{id}/attachments/{attachmentId}/thumbnail", s.WithUser(s.downloadThumbnail))
 	})
 }
 
+// getTransactions lists the ledger's transactions, narrowed by the query in
+// the "q" parameter when given (see package filter for the syntax).
 func (s *APIServer) getTransactions(w http.ResponseWriter, r *http.Request) *Response {
 	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	query, err := filter.Parse(r.URL.Query().Get("q"))
+
+	if err != nil {
+		content := JSON{
+			"error": err.Error(),
+		}
+
+		if syntaxErr, ok := err.(*filter.Error); ok {
+			content["position"] = syntaxErr.Pos
+		}
+
+		return &Response{
+			Status:  http.StatusBadRequest,
+			Content: content,
+		}
+	}
 
-	transactions, err := s.DB.Transactions.Find(user.ID)
+	transactions, err := s.DB.Transactions.FindFiltered(user.ID, ledger.ID, query)
 
 	if err != nil {
 		return &Response{
@@ -44,6 +116,20 @@ func (s *APIServer) getTransactions(w http.ResponseWriter, r *http.Request) *Res
 			},
 		}
 	}
+
+	// Each tag query parameter narrows the listing to transactions with that
+	// tag.
+	for _, tag := range r.URL.Query()["tag"] {
+		transactions = s.DB.Transactions.Filter(transactions, func(t *models.Transaction) bool {
+			for _, have := range t.Tags {
+				if strings.EqualFold(have, tag) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
 	return &Response{
 		Status: http.StatusOK,
 		Content: JSON{
@@ -53,29 +139,10 @@ func (s *APIServer) getTransactions(w http.ResponseWriter, r *http.Request) *Res
 }
 
 func (s *APIServer) getTransction(w http.ResponseWriter, r *http.Request) *Response {
-	id := chi.URLParam(r, "id")
-	user := r.Context().Value(ContextKey("user")).(*models.User)
+	transaction, resp := s.findUserTransaction(r)
 
-	transaction, err := s.DB.Transactions.FindOne(&models.Transaction{
-		ID: id,
-	})
-
-	if transaction.UserID != user.ID {
-		return &Response{
-			Status: http.StatusNotFound,
-			Content: JSON{
-				"error": "transaction not found",
-			},
-		}
-	}
-
-	if err != nil {
-		return &Response{
-			Status: http.StatusInternalServerError,
-			Content: JSON{
-				"error": err.Error(),
-			},
-		}
+	if resp != nil {
+		return resp
 	}
 
 	return &Response{
@@ -101,17 +168,115 @@ func (s *APIServer) createTransaction(w http.ResponseWriter, r *http.Request) *R
 	}
 
 	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	if ctr.Type == TransferType {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "transfers must be created through /api/transfers",
+			},
+		}
+	}
+
+	if !entryType(ctr.Type) {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": entryTypeError,
+			},
+		}
+	}
+
+	if resp := s.checkTransactionAccount(ledger.ID, ctr.AccountID); resp != nil {
+		return resp
+	}
+
+	defaultCurrency, resp := s.accountCurrency(ctr.AccountID, user.BaseCurrency)
+
+	if resp != nil {
+		return resp
+	}
+
+	currency, resp := transactionCurrency(ctr.Currency, defaultCurrency)
+
+	if resp != nil {
+		return resp
+	}
+
+	amount, resp := amountIn(ctr.Amount, currency)
+
+	if resp != nil {
+		return resp
+	}
 
 	t := &models.Transaction{
 		UserID:      user.ID,
+		LedgerID:    ledger.ID,
+		AccountID:   ctr.AccountID,
 		CategoryID:  ctr.CategoryID,
 		Date:        ctr.Date,
 		Description: ctr.Description,
 		Type:        ctr.Type,
 		Vendor:      ctr.Vendor,
-		Amount:      ctr.Amount,
+		Amount:      amount,
+		Currency:    currency,
+		Tags:        models.NormalizeTags(ctr.Tags),
+
+		Reimbursable: ctr.Reimbursable,
 	}
 
+	rules, err := s.DB.Rules.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	// Rules only pick the category when the request leaves it out.
+	applyRules(rules, t, t.CategoryID == "")
+
+	payees, err := s.DB.Payees.Find(user.ID, ledger.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	// Failing that, the payee's default category is used.
+	if payee := linkPayee(payees, t); payee != nil && t.CategoryID == "" && payee.DefaultCategoryID.Valid {
+		t.CategoryID = payee.DefaultCategoryID.String
+	}
+
+	category, err := s.DB.Categories.FindOne(&models.Category{
+		ID: t.CategoryID,
+	})
+
+	if err != nil || category.LedgerID != ledger.ID {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "category not found in ledger",
+			},
+		}
+	}
+
+	splits, resp := s.transactionSplits(ledger.ID, currency, ctr.Splits)
+
+	if resp != nil {
+		return resp
+	}
+
+	t.Splits = splits
+
 	t, err = s.DB.Transactions.Save(t)
 
 	if err != nil {
@@ -145,43 +310,105 @@ func (s *APIServer) updateTransaction(w http.ResponseWriter, r *http.Request) *R
 		}
 	}
 
-	id := chi.URLParam(r, "id")
+	t, resp := s.findUserTransaction(r)
 
-	user := r.Context().Value(ContextKey("user")).(*models.User)
+	if resp != nil {
+		return resp
+	}
 
-	t, err := s.DB.Transactions.FindOne(&models.Transaction{
-		ID: id,
-	})
+	if t.TransferID.Valid || ctr.Type == TransferType {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "transfers must be updated through /api/transfers",
+			},
+		}
+	}
 
-	if err != nil {
+	if !entryType(ctr.Type) {
 		return &Response{
 			Status: http.StatusBadRequest,
 			Content: JSON{
-				"error": err.Error(),
+				"error": entryTypeError,
 			},
 		}
 	}
 
-	if user.ID != t.UserID {
+	if resp := checkUnlocked(t); resp != nil {
+		return resp
+	}
+
+	category, err := s.DB.Categories.FindOne(&models.Category{
+		ID: ctr.CategoryID,
+	})
+
+	if err != nil || category.LedgerID != t.LedgerID {
 		return &Response{
-			Status: http.StatusNotFound,
+			Status: http.StatusBadRequest,
 			Content: JSON{
-				"error": "transaction not found",
+				"error": "category not found in ledger",
 			},
 		}
 	}
 
+	if resp := s.checkTransactionAccount(t.LedgerID, ctr.AccountID); resp != nil {
+		return resp
+	}
+
+	currency, resp := transactionCurrency(ctr.Currency, t.Currency)
+
+	if resp != nil {
+		return resp
+	}
+
+	amount, resp := amountIn(ctr.Amount, currency)
+
+	if resp != nil {
+		return resp
+	}
+
+	splits, resp := s.transactionSplits(t.LedgerID, currency, ctr.Splits)
+
+	if resp != nil {
+		return resp
+	}
+
 	tempTransaction := models.Transaction{
 		ID:          t.ID,
-		Amount:      ctr.Amount,
+		Amount:      amount,
 		Date:        ctr.Date,
 		UserID:      t.UserID,
+		LedgerID:    t.LedgerID,
+		AccountID:   ctr.AccountID,
 		CategoryID:  ctr.CategoryID,
 		Vendor:      ctr.Vendor,
 		Type:        ctr.Type,
+		Currency:    currency,
 		Description: ctr.Description,
+		Splits:      splits,
+		Tags:        t.Tags,
+
+		Reimbursable: ctr.Reimbursable,
 	}
 
+	// Tags are only replaced when the request includes them.
+	if ctr.Tags != nil {
+		tempTransaction.Tags = models.NormalizeTags(ctr.Tags)
+	}
+
+	payees, err := s.DB.Payees.Find(t.UserID, t.LedgerID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	linkPayee(payees, &tempTransaction)
+
 	updatedTransaction, err := s.DB.Transactions.Save(&tempTransaction)
 
 	if err != nil {
@@ -201,34 +428,210 @@ func (s *APIServer) updateTransaction(w http.ResponseWriter, r *http.Request) *R
 	}
 }
 
-func (s *APIServer) deleteTransaction(w http.ResponseWriter, r *http.Request) *Response {
-	id := chi.URLParam(r, "id")
+// patchTransaction applies a JSON merge patch to a transaction, leaving the
+// fields the patch doesn't mention as they are.
+func (s *APIServer) patchTransaction(w http.ResponseWriter, r *http.Request) *Response {
+	req := &PatchTransactionRequest{}
 
-	user := r.Context().Value(ContextKey("user")).(*models.User)
+	if resp := decodePatch(r, req); resp != nil {
+		return resp
+	}
 
-	t, err := s.DB.Transactions.FindOne(&models.Transaction{
-		ID: id,
-	})
+	t, resp := s.findUserTransaction(r)
 
-	if err != nil {
+	if resp != nil {
+		return resp
+	}
+
+	if t.TransferID.Valid {
 		return &Response{
 			Status: http.StatusBadRequest,
 			Content: JSON{
-				"error": err.Error(),
+				"error": "transfers must be updated through /api/transfers",
 			},
 		}
 	}
 
-	if user.ID != t.UserID {
+	if resp := checkUnlocked(t); resp != nil {
+		return resp
+	}
+
+	invalid := fieldErrors{}
+
+	if req.AccountID.Set {
+		if s.checkTransactionAccount(t.LedgerID, req.AccountID.Value) != nil {
+			invalid["account_id"] = "account not found in ledger"
+		}
+
+		t.AccountID = req.AccountID.Value
+	}
+
+	currency := t.Currency
+
+	if invalid.notNull("currency", req.Currency.Set, req.Currency.Null) {
+		normalized, err := models.NormalizeCurrency(req.Currency.Value)
+
+		if err != nil {
+			invalid["currency"] = err.Error()
+		} else {
+			currency = normalized
+		}
+	}
+
+	if invalid.notNull("type", req.Type.Set, req.Type.Null) {
+		if !entryType(req.Type.Value) {
+			invalid["type"] = entryTypeError
+		}
+
+		t.Type = req.Type.Value
+	}
+
+	if invalid.notNull("reimbursable", req.Reimbursable.Set, req.Reimbursable.Null) {
+		t.Reimbursable = req.Reimbursable.Value
+	}
+
+	if t.Reimbursable && t.Type != models.TypeExpense {
+		invalid["reimbursable"] = "only expenses can be reimbursable"
+	}
+
+	// Without a new amount, the amount keeps its value in a new currency.
+	amount, err := t.Amount.Rebase(currency)
+
+	if invalid.notNull("amount", req.Amount.Set, req.Amount.Null) {
+		amount, err = req.Amount.Value.In(currency)
+	}
+
+	switch {
+	case err != nil:
+		invalid["amount"] = err.Error()
+	case amount.Sign() < 0 && !t.Signed():
+		invalid["amount"] = "can't be negative"
+	}
+
+	t.Amount = amount
+
+	if invalid.notNull("category_id", req.CategoryID.Set, req.CategoryID.Null) {
+		category, err := s.DB.Categories.FindOne(&models.Category{
+			ID: req.CategoryID.Value,
+		})
+
+		if err != nil || category.LedgerID != t.LedgerID {
+			invalid["category_id"] = "category not found in ledger"
+		}
+
+		t.CategoryID = req.CategoryID.Value
+	}
+
+	if req.Description.Set {
+		t.Description = req.Description.Value
+	}
+
+	if invalid.notNull("vendor", req.Vendor.Set, req.Vendor.Null) {
+		t.Vendor = req.Vendor.Value
+	}
+
+	if invalid.notNull("date", req.Date.Set, req.Date.Null) {
+		if req.Date.Value.IsZero() {
+			invalid["date"] = "date is required"
+		}
+
+		t.Date = req.Date.Value
+	}
+
+	if req.Splits.Set {
+		splits, resp := s.transactionSplits(t.LedgerID, currency, req.Splits.Value)
+
+		if resp != nil {
+			invalid["splits"] = resp.Content["error"].(string)
+		}
+
+		t.Splits = splits
+	} else {
+		for _, split := range t.Splits {
+			if split.Amount, err = split.Amount.Rebase(currency); err != nil {
+				invalid["splits"] = err.Error()
+			}
+		}
+	}
+
+	if req.Tags.Set {
+		t.Tags = models.NormalizeTags(req.Tags.Value)
+
+		if err := models.ValidateTags(t.Tags); err != nil {
+			invalid["tags"] = err.Error()
+		}
+	}
+
+	if resp := invalid.response(); resp != nil {
+		return resp
+	}
+
+	if req.Vendor.Set {
+		payees, err := s.DB.Payees.Find(t.UserID, t.LedgerID)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+
+		linkPayee(payees, t)
+	}
+
+	updatedTransaction, err := s.DB.Transactions.Save(t)
+
+	if err != nil {
 		return &Response{
-			Status: http.StatusNotFound,
+			Status: http.StatusBadRequest,
 			Content: JSON{
-				"error": "transaction not found",
+				"error": err.Error(),
 			},
 		}
 	}
 
-	err = s.DB.Transactions.Delete(id)
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": updatedTransaction,
+		},
+	}
+}
+
+func (s *APIServer) deleteTransaction(w http.ResponseWriter, r *http.Request) *Response {
+	t, resp := s.findUserTransaction(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	var err error
+	locked := []*models.Transaction{t}
+
+	if t.TransferID.Valid {
+		locked, err = s.DB.Transactions.FindTransfer(t.TransferID.String)
+
+		if err != nil {
+			return &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": err.Error(),
+				},
+			}
+		}
+	}
+
+	if resp := checkUnlocked(locked...); resp != nil {
+		return resp
+	}
+
+	if t.TransferID.Valid {
+		err = s.DB.Transactions.DeleteTransfer(t.TransferID.String)
+	} else {
+		err = s.DB.Transactions.Delete(t.ID)
+	}
 
 	if err != nil {
 		return &Response{
@@ -242,7 +645,119 @@ func (s *APIServer) deleteTransaction(w http.ResponseWriter, r *http.Request) *R
 	return &Response{
 		Status: http.StatusOK,
 		Content: JSON{
-			"deleted": id,
+			"deleted": t.ID,
 		},
 	}
 }
+
+// entryTypeError explains which types entryType accepts.
+const entryTypeError = "type must be expense, income, refund or adjustment"
+
+// entryType reports whether a transaction can be given the type directly.
+// Transfers are made through /api/transfers instead.
+func entryType(kind string) bool {
+	return models.TransactionTypes[kind] && kind != TransferType
+}
+
+// transactionCurrency normalizes the currency given on a transaction request,
+// falling back to the given currency when there's none.
+func transactionCurrency(code, fallback string) (string, *Response) {
+	if code == "" {
+		return fallback, nil
+	}
+
+	currency, err := models.NormalizeCurrency(code)
+
+	if err != nil {
+		return "", &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return currency, nil
+}
+
+// accountCurrency returns the currency of the account, or the fallback when
+// there's no account.
+func (s *APIServer) accountCurrency(accountId models.OptionalString, fallback string) (string, *Response) {
+	if !accountId.Valid {
+		return fallback, nil
+	}
+
+	account, err := s.DB.Accounts.FindOne(&models.Account{
+		ID: accountId.String,
+	})
+
+	if err != nil {
+		return "", &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "account not found in ledger",
+			},
+		}
+	}
+
+	return account.Currency, nil
+}
+
+// checkTransactionAccount makes sure an account given on a transaction
+// request exists in the transaction's ledger.
+func (s *APIServer) checkTransactionAccount(ledgerId string, accountId models.OptionalString) *Response {
+	if !accountId.Valid {
+		return nil
+	}
+
+	account, err := s.DB.Accounts.FindOne(&models.Account{
+		ID: accountId.String,
+	})
+
+	if err != nil || account.LedgerID != ledgerId {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "account not found in ledger",
+			},
+		}
+	}
+
+	return nil
+}
+
+// transactionSplits turns split requests into splits in the currency, making
+// sure every split category belongs to the transaction's ledger.
+func (s *APIServer) transactionSplits(ledgerId, currency string, requests []*CreateSplitRequest) ([]*models.Split, *Response) {
+	splits := []*models.Split{}
+
+	for _, req := range requests {
+		category, err := s.DB.Categories.FindOne(&models.Category{
+			ID: req.CategoryID,
+		})
+
+		if err != nil || category.LedgerID != ledgerId {
+			return nil, &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "split category not found in ledger",
+				},
+			}
+		}
+
+		amount, resp := amountIn(req.Amount, currency)
+
+		if resp != nil {
+			return nil, resp
+		}
+
+		splits = append(splits, &models.Split{
+			Category:   category.Name,
+			CategoryID: category.ID,
+			Amount:     amount,
+			Memo:       req.Memo,
+		})
+	}
+
+	return splits, nil
+}
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/alexgaudon/budgie/models"
+	"github.com/alexgaudon/budgie/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+const TransferType = models.TypeTransfer
+
+// CreateTransferRequest moves Amount, in the currency of the from account,
+// between two accounts. When the accounts' currencies differ, ToAmount is what
+// arrived in the to account's currency; left out, it's converted at the rate
+// on the transfer date.
+type CreateTransferRequest struct {
+	FromAccountID string                `json:"from_account_id"`
+	ToAccountID   string                `json:"to_account_id"`
+	Amount        models.Money          `json:"amount"`
+	ToAmount      models.Money          `json:"to_amount"`
+	Description   models.OptionalString `json:"description"`
+	Date          time.Time             `json:"date"`
+}
+
+type Transfer struct {
+	ID   string              `json:"id"`
+	From *models.Transaction `json:"from"`
+	To   *models.Transaction `json:"to"`
+}
+
+func (s *APIServer) registerTransfers() {
+	s.Router.Route("/api/transfers", func(r chi.Router) {
+		r.Get("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.getTransfer))))
+
+		r.Post("/", s.WithUser(s.WithLedger(MakeHandler(s.createTransfer))))
+
+		r.Put("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.updateTransfer))))
+
+		r.Delete("/{id}", s.WithUser(s.WithLedger(MakeHandler(s.deleteTransfer))))
+	})
+}
+
+func (s *APIServer) getTransfer(w http.ResponseWriter, r *http.Request) *Response {
+	transfer, resp := s.findUserTransfer(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": transfer,
+		},
+	}
+}
+
+func (s *APIServer) createTransfer(w http.ResponseWriter, r *http.Request) *Response {
+	ctr := &CreateTransferRequest{}
+
+	err := utils.DecodeBody(r, ctr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	out := &models.Transaction{
+		UserID:   user.ID,
+		LedgerID: ledger.ID,
+	}
+	in := &models.Transaction{
+		UserID:   user.ID,
+		LedgerID: ledger.ID,
+	}
+
+	if resp := s.applyTransferRequest(r, ctr, out, in); resp != nil {
+		return resp
+	}
+
+	err = s.DB.Transactions.SaveTransfer(out, in)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": &Transfer{
+				ID:   out.TransferID.String,
+				From: out,
+				To:   in,
+			},
+		},
+	}
+}
+
+func (s *APIServer) updateTransfer(w http.ResponseWriter, r *http.Request) *Response {
+	ctr := &CreateTransferRequest{}
+
+	err := utils.DecodeBody(r, ctr)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	transfer, resp := s.findUserTransfer(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	if resp := checkUnlocked(transfer.From, transfer.To); resp != nil {
+		return resp
+	}
+
+	if resp := s.applyTransferRequest(r, ctr, transfer.From, transfer.To); resp != nil {
+		return resp
+	}
+
+	err = s.DB.Transactions.SaveTransfer(transfer.From, transfer.To)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"data": transfer,
+		},
+	}
+}
+
+func (s *APIServer) deleteTransfer(w http.ResponseWriter, r *http.Request) *Response {
+	transfer, resp := s.findUserTransfer(r)
+
+	if resp != nil {
+		return resp
+	}
+
+	if resp := checkUnlocked(transfer.From, transfer.To); resp != nil {
+		return resp
+	}
+
+	err := s.DB.Transactions.DeleteTransfer(transfer.ID)
+
+	if err != nil {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	return &Response{
+		Status: http.StatusOK,
+		Content: JSON{
+			"deleted": transfer.ID,
+		},
+	}
+}
+
+// applyTransferRequest validates the request and copies it onto the outflow
+// and inflow legs. The outflow leg carries a negative amount so account
+// balances can sum transfer legs directly.
+func (s *APIServer) applyTransferRequest(r *http.Request, ctr *CreateTransferRequest, out, in *models.Transaction) *Response {
+	if ctr.Amount.Sign() <= 0 {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "amount must be positive",
+			},
+		}
+	}
+
+	if ctr.FromAccountID == ctr.ToAccountID {
+		return &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "cannot transfer to the same account",
+			},
+		}
+	}
+
+	from, resp := s.findUserAccount(r, ctr.FromAccountID)
+
+	if resp != nil {
+		return resp
+	}
+
+	to, resp := s.findUserAccount(r, ctr.ToAccountID)
+
+	if resp != nil {
+		return resp
+	}
+
+	amount, resp := amountIn(ctr.Amount, from.Currency)
+
+	if resp != nil {
+		return resp
+	}
+
+	toAmount, resp := s.transferToAmount(r, ctr, amount, to)
+
+	if resp != nil {
+		return resp
+	}
+
+	out.AccountID = models.OptionalString{String: from.ID, Valid: true}
+	out.Amount, _ = amount.Neg()
+	out.Currency = from.Currency
+	out.Vendor = to.Name
+
+	in.AccountID = models.OptionalString{String: to.ID, Valid: true}
+	in.Amount = toAmount
+	in.Currency = to.Currency
+	in.Vendor = from.Name
+
+	for _, t := range []*models.Transaction{out, in} {
+		t.CategoryID = ""
+		t.Description = ctr.Description
+		t.Date = ctr.Date
+		t.Type = TransferType
+	}
+
+	return nil
+}
+
+// transferToAmount works out how much of the amount arrives in the to
+// account, in its currency.
+func (s *APIServer) transferToAmount(r *http.Request, ctr *CreateTransferRequest, amount models.Money, to *models.Account) (models.Money, *Response) {
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+
+	if amount.Currency == to.Currency {
+		toAmount, err := ctr.ToAmount.In(to.Currency)
+
+		if err != nil || (!toAmount.IsZero() && toAmount != amount) {
+			return models.Money{}, &Response{
+				Status: http.StatusBadRequest,
+				Content: JSON{
+					"error": "to_amount is only for transfers between currencies",
+				},
+			}
+		}
+
+		return amount, nil
+	}
+
+	if ctr.ToAmount.Sign() < 0 {
+		return models.Money{}, &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": "to_amount must be positive",
+			},
+		}
+	}
+
+	if ctr.ToAmount.Sign() > 0 {
+		return amountIn(ctr.ToAmount, to.Currency)
+	}
+
+	rate, err := s.DB.ExchangeRates.Rate(user.ID, amount.Currency, to.Currency, ctr.Date)
+
+	if err == nil {
+		var converted models.Money
+
+		converted, err = amount.Convert(to.Currency, rate)
+
+		if err == nil {
+			return converted, nil
+		}
+	}
+
+	return models.Money{}, &Response{
+		Status: http.StatusBadRequest,
+		Content: JSON{
+			"error": err.Error(),
+		},
+	}
+}
+
+func (s *APIServer) findUserTransfer(r *http.Request) (*Transfer, *Response) {
+	id := chi.URLParam(r, "id")
+	user := r.Context().Value(ContextKey("user")).(*models.User)
+	ledger := r.Context().Value(ContextKey("ledger")).(*models.Ledger)
+
+	legs, err := s.DB.Transactions.FindTransfer(id)
+
+	if err != nil {
+		return nil, &Response{
+			Status: http.StatusBadRequest,
+			Content: JSON{
+				"error": err.Error(),
+			},
+		}
+	}
+
+	if legs[0].UserID != user.ID || legs[0].LedgerID != ledger.ID {
+		return nil, &Response{
+			Status: http.StatusNotFound,
+			Content: JSON{
+				"error": "transfer not found",
+			},
+		}
+	}
+
+	return &Transfer{
+		ID:   id,
+		From: legs[0],
+		To:   legs[1],
+	}, nil
+}
@@ -14,12 +14,24 @@ import (
 )
 
 type DBStore struct {
-	migrationPath string
-	db            *sql.DB
-	User          *models.UserRepo
-	Categories    *models.CategoriesRepo
-	Budgets       *models.BudgetsRepo
-	Transactions  *models.TransactionsRepo
+	migrationPath   string
+	db              *sql.DB
+	User            *models.UserRepo
+	Ledgers         *models.LedgersRepo
+	Accounts        *models.AccountsRepo
+	Categories      *models.CategoriesRepo
+	Budgets         *models.BudgetsRepo
+	Transactions    *models.TransactionsRepo
+	Recurring       *models.RecurringRepo
+	ImportProfiles  *models.ImportProfilesRepo
+	ImportBatches   *models.ImportBatchesRepo
+	Rules           *models.RulesRepo
+	Payees          *models.PayeesRepo
+	Tags            *models.TagsRepo
+	Attachments     *models.AttachmentsRepo
+	Reconciliations *models.ReconciliationsRepo
+	ExchangeRates   *models.ExchangeRatesRepo
+	Links           *models.TransactionLinksRepo
 }
 
 func (d *DBStore) Initialize() error {
@@ -27,6 +39,14 @@ func (d *DBStore) Initialize() error {
 		DB: d.db,
 	}
 
+	d.Ledgers = &models.LedgersRepo{
+		DB: d.db,
+	}
+
+	d.Accounts = &models.AccountsRepo{
+		DB: d.db,
+	}
+
 	d.Categories = &models.CategoriesRepo{
 		DB: d.db,
 	}
@@ -39,6 +59,46 @@ func (d *DBStore) Initialize() error {
 		DB: d.db,
 	}
 
+	d.Recurring = &models.RecurringRepo{
+		DB: d.db,
+	}
+
+	d.ImportProfiles = &models.ImportProfilesRepo{
+		DB: d.db,
+	}
+
+	d.ImportBatches = &models.ImportBatchesRepo{
+		DB: d.db,
+	}
+
+	d.Rules = &models.RulesRepo{
+		DB: d.db,
+	}
+
+	d.Payees = &models.PayeesRepo{
+		DB: d.db,
+	}
+
+	d.Tags = &models.TagsRepo{
+		DB: d.db,
+	}
+
+	d.Attachments = &models.AttachmentsRepo{
+		DB: d.db,
+	}
+
+	d.Reconciliations = &models.ReconciliationsRepo{
+		DB: d.db,
+	}
+
+	d.ExchangeRates = &models.ExchangeRatesRepo{
+		DB: d.db,
+	}
+
+	d.Links = &models.TransactionLinksRepo{
+		DB: d.db,
+	}
+
 	err := d.handleMigrations()
 
 	return err
@@ -0,0 +1,122 @@
+// Package thumbnail makes small JPEG previews of images using only the
+// standard library's decoders, so it handles JPEG, PNG and GIF.
+package thumbnail
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	// Registered for image.Decode.
+	_ "image/gif"
+	_ "image/png"
+)
+
+// maxPixels bounds the size of images that are decoded at all, since a small
+// file can declare enormous dimensions.
+const maxPixels = 50_000_000
+
+// Supported reports whether thumbnails can be made for the content type.
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Make decodes the image and returns a JPEG scaled down to fit in a size by
+// size square, keeping its aspect ratio. Smaller images aren't scaled up.
+// Transparent areas come out white.
+func Make(data []byte, size int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, fmt.Errorf("image is %dx%d, too large for a thumbnail", config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+
+	if err := jpeg.Encode(&out, scale(src, size), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// scale shrinks src to fit in a size by size square by averaging the source
+// pixels under each destination pixel.
+func scale(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+
+	if w > size || h > size {
+		if w >= h {
+			dw, dh = size, h*size/w
+		} else {
+			dw, dh = w*size/h, size
+		}
+	}
+
+	if dw < 1 {
+		dw = 1
+	}
+
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		y0, y1 := bounds.Min.Y+y*h/dh, bounds.Min.Y+(y+1)*h/dh
+
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+
+		for x := 0; x < dw; x++ {
+			x0, x1 := bounds.Min.X+x*w/dw, bounds.Min.X+(x+1)*w/dw
+
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, n uint64
+
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					sr, sg, sb, sa := src.At(sx, sy).RGBA()
+
+					// Composite onto white; the channels are premultiplied.
+					r += uint64(sr + 0xffff - sa)
+					g += uint64(sg + 0xffff - sa)
+					b += uint64(sb + 0xffff - sa)
+					n++
+				}
+			}
+
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: 0xffff,
+			})
+		}
+	}
+
+	return dst
+}